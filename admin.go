@@ -0,0 +1,387 @@
+// An admin API for steering a running snapshot, served by the HttpServer. Every request needs an
+// "Authorization: Bearer <ADMIN_TOKEN>" header, and the whole thing is turned off if ADMIN_TOKEN isn't set.
+//   POST /admin/pause[?table=NAME]              stops handing out work for the whole snapshot or one table
+//   POST /admin/resume[?table=NAME]             undoes a pause
+//   POST /admin/resnapshot?table=NAME           throws away a table's progress and snapshots it again
+//   POST /admin/cancel-resnapshot?table=NAME    stops a re-snapshot and goes back to the old progress
+// Each request is written to the audit log, whether it worked or not. Pauses and re-snapshots are kept in the
+// state storage, so they last through a restart, and they still work once the first snapshot is over.
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ADMIN_TIMEOUT = 10 * time.Second
+
+var ADMIN_ACTIONS = []string{"pause", "resume", "resnapshot", "cancel-resnapshot"}
+
+// Sent to the Snapshotter's Run loop, which answers on 'reply'.
+type adminCommand struct {
+	Action string
+	Table string  // Empty means the whole snapshot, for pause and resume.
+	reply chan error
+}
+
+var errAdminTimeout = errors.New("The snapshot didn't answer in time.")
+
+// Answered by waitForResnapshot() while there's no snapshot running.
+var idleAdminChan = make(chan adminCommand)
+
+// Asks the Run loop to carry out an admin action, and returns its answer.
+func (s *Snapshotter) Admin(action, table string, timeout time.Duration) error {
+	return sendAdminCommand(s.adminChan, action, table, timeout)
+}
+
+func sendAdminCommand(adminChan chan adminCommand, action, table string, timeout time.Duration) error {
+	command := adminCommand{action, table, make(chan error, 1)}
+	select {
+	case adminChan <- command:
+		return <-command.reply
+	case <-time.After(timeout):
+		return errAdminTimeout
+	}
+}
+
+// Answers admin requests once the snapshot is over. Returns true as soon as a table needs re-snapshotting, so
+// that main() can start another snapshot, or false if we're shutting down.
+func waitForResnapshot() bool {
+	for {
+		select {
+		case command := <-idleAdminChan:
+			err := runIdleAdminCommand(command)
+			command.reply <- err
+			if err == nil && command.Action == "resnapshot" {
+				return true
+			}
+		case <-shutdown.RequestedSignal():
+			return false
+		}
+	}
+}
+
+// The same as runAdminCommand(), but with nothing to change except the state storage, which the next snapshot
+// reads when it starts.
+func runIdleAdminCommand(command adminCommand) error {
+	tableName := command.Table
+	switch command.Action {
+	case "pause":
+		if tableName != "" {
+			if err := checkAdminTable(tableName); err != nil {
+				return err
+			}
+		}
+		return setPaused(tableName, true)
+	case "resume":
+		if tableName != "" {
+			if err := checkPaused(tableName); err != nil {
+				return err
+			}
+		}
+		return setPaused(tableName, false)
+	case "resnapshot":
+		if _, ok, err := resnapshotProgress(tableName); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("Table '%s' is already being re-snapshotted.", tableName)
+		} else if err := checkAdminTable(tableName); err != nil {
+			return err
+		}
+		_, err := swapProgressForResnapshot(tableName)
+		if err == nil {
+			adminLogger.Info("Re-snapshotting table.", "table", tableName)
+		}
+		return err
+	case "cancel-resnapshot":
+		progress, ok, err := resnapshotProgress(tableName)
+		if err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("Table '%s' isn't being re-snapshotted.", tableName)
+		}
+		return restoreResnapshotProgress(tableName, progress)
+	default:
+		return fmt.Errorf("Unknown admin action '%s'", command.Action)
+	}
+}
+
+// Only call this from Run().
+func (s *Snapshotter) runAdminCommand(command adminCommand) error {
+	tableName := command.Table
+	switch command.Action {
+	case "pause":
+		if tableName != "" && !s.State.HasTable(tableName) {
+			return fmt.Errorf("Table '%s' isn't being snapshotted.", tableName)
+		} else if err := setPaused(tableName, true); err != nil {
+			return err
+		} else if tableName == "" {
+			s.paused = true
+		} else {
+			s.State.PauseTable(tableName)
+		}
+	case "resume":
+		if tableName != "" {
+			if err := checkPaused(tableName); err != nil {
+				return err
+			}
+		}
+		if err := setPaused(tableName, false); err != nil {
+			return err
+		} else if tableName == "" {
+			s.paused = false
+		} else {
+			s.State.ResumeTable(tableName)
+		}
+	case "resnapshot":
+		return s.resnapshotTable(tableName)
+	case "cancel-resnapshot":
+		return s.cancelResnapshot(tableName)
+	default:
+		return fmt.Errorf("Unknown admin action '%s'", command.Action)
+	}
+	return nil
+}
+
+// Throws away a table's snapshot progress and starts it from scratch, while the other tables keep going. We
+// remember the old progress so that the re-snapshot can be cancelled.
+func (s *Snapshotter) resnapshotTable(tableName string) error {
+	if !s.handingOutWork() {
+		return errors.New("The snapshot is finishing, so it can't start on another table.")
+	} else if _, ok := s.resnapshots[tableName]; ok {
+		return fmt.Errorf("Table '%s' is already being re-snapshotted.", tableName)
+	} else if _, ok := s.drainingTables[tableName]; ok {
+		return fmt.Errorf("Table '%s' is still finishing its intervals in progress. Try again in a moment.", tableName)
+	} else if err := checkAdminTable(tableName); err != nil {
+		return err
+	}
+
+	progress, err := swapProgressForResnapshot(tableName)
+	if err != nil {
+		return err
+	}
+	s.State.RemoveTable(tableName)
+	s.resnapshots[tableName] = progress
+	s.State.ResumeTable(tableName)
+	adminLogger.Info("Re-snapshotting table.", "table", tableName)
+	return s.restartTable(tableName)
+}
+
+// Stops a re-snapshot and puts the table's old progress back. If the table was still being snapshotted when
+// the re-snapshot started, it carries on from where it was.
+func (s *Snapshotter) cancelResnapshot(tableName string) error {
+	progress, ok := s.resnapshots[tableName]
+	if !ok {
+		return fmt.Errorf("Table '%s' isn't being re-snapshotted.", tableName)
+	}
+
+	if err := restoreResnapshotProgress(tableName, progress); err != nil {
+		return err
+	}
+	s.State.RemoveTable(tableName)
+	delete(s.resnapshots, tableName)
+	adminLogger.Info("Cancelled the re-snapshot of table.", "table", tableName)
+	return s.restartTable(tableName)
+}
+
+// Adds a removed table back once its intervals in progress are done, so that their late completions don't get
+// mixed up with the new progress.
+func (s *Snapshotter) restartTable(tableName string) error {
+	if s.busyIntervals[tableName] == 0 {
+		s.includeTable(tableName, false)
+		return nil
+	}
+	schema, err := GetTableSchema(tableName)
+	if err != nil {
+		return err
+	}
+	s.drainingTables[tableName] = schema
+	return nil
+}
+
+// Forgets a finished or abandoned re-snapshot, leaving the table's progress as it is.
+func (s *Snapshotter) forgetResnapshot(tableName string) {
+	delete(s.resnapshots, tableName)
+	if err := stateStorage.Delete("table_resnapshot/" + tableName); err != nil {
+		snapshotLogger.Error("Can't forget the re-snapshot of table", "table", tableName, "error", err)
+	}
+}
+
+// Picks up the pauses and re-snapshots from before a restart.
+func (s *Snapshotter) loadAdminState() error {
+	paused, err := stateStorage.Get(pausedKey(""))
+	if err != nil {
+		return err
+	}
+	s.paused = paused != ""
+
+	keys, err := stateStorage.Keys("table_snapshot_paused/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if tableName := strings.TrimPrefix(key, "table_snapshot_paused/"); s.State.HasTable(tableName) {
+			s.State.PauseTable(tableName)
+		}
+	}
+
+	keys, err = stateStorage.Keys("table_resnapshot/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		tableName := strings.TrimPrefix(key, "table_resnapshot/")
+		progress, err := stateStorage.Get(key)
+		if err != nil {
+			return err
+		}
+		s.resnapshots[tableName] = progress
+		if !s.State.HasTable(tableName) {
+			s.forgetResnapshot(tableName)  // It finished just before we stopped.
+		}
+	}
+	return nil
+}
+
+// The whole snapshot's pause has its own key, and each table's has one under table_snapshot_paused/.
+func pausedKey(tableName string) string {
+	if tableName == "" {
+		return "snapshot_paused"
+	}
+	return "table_snapshot_paused/" + tableName
+}
+
+func setPaused(tableName string, paused bool) error {
+	if paused {
+		return stateStorage.Set(pausedKey(tableName), "true")
+	}
+	return stateStorage.Delete(pausedKey(tableName))
+}
+
+func checkPaused(tableName string) error {
+	paused, err := stateStorage.Get(pausedKey(tableName))
+	if err != nil {
+		return err
+	} else if paused == "" {
+		return fmt.Errorf("Table '%s' isn't paused.", tableName)
+	}
+	return nil
+}
+
+// Only tables that exist and aren't excluded can be paused or re-snapshotted while there's no snapshot running.
+func checkAdminTable(tableName string) error {
+	if config.IsExcluded(tableName) {
+		return fmt.Errorf("Table '%s' is excluded.", tableName)
+	}
+	tables, err := ListTables()
+	if err != nil {
+		return err
+	} else if !StringInList(tableName, tables) {
+		return fmt.Errorf("Table '%s' doesn't exist.", tableName)
+	}
+	return nil
+}
+
+// Returns the progress a table had before its re-snapshot, and whether it's being re-snapshotted at all.
+func resnapshotProgress(tableName string) (string, bool, error) {
+	key := "table_resnapshot/" + tableName
+	keys, err := stateStorage.Keys(key)
+	if err != nil || !StringInList(key, keys) {
+		return "", false, err
+	}
+	progress, err := stateStorage.Get(key)
+	return progress, err == nil, err
+}
+
+// Throws away a table's progress (and any pause), keeping a copy under table_resnapshot/ in case the
+// re-snapshot is cancelled. It all happens in one go, so a crash can't lose the old progress.
+func swapProgressForResnapshot(tableName string) (string, error) {
+	progressKey := "table_snapshot_progress/" + tableName
+	progress, err := stateStorage.Get(progressKey)
+	if err != nil {
+		return "", err
+	}
+	err = stateStorage.Replace([]string{progressKey, pausedKey(tableName)}, map[string]string{"table_resnapshot/" + tableName: progress})
+	return progress, err
+}
+
+func restoreResnapshotProgress(tableName, progress string) error {
+	progressKey := "table_snapshot_progress/" + tableName
+	values := map[string]string{}
+	if progress != "" {
+		values[progressKey] = progress
+	}
+	return stateStorage.Replace([]string{"table_resnapshot/" + tableName, progressKey}, values)
+}
+
+func RegisterAdminHandlers(mux *http.ServeMux) {
+	for _, action := range ADMIN_ACTIONS {
+		mux.HandleFunc("/admin/" + action, adminHandler(action))
+	}
+}
+
+func adminHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		} else if !validAdminToken(r) {
+			writeJson(w, http.StatusUnauthorized, map[string]string{"error": "Missing or wrong admin token."})
+			return
+		} else if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "Use POST."})
+			return
+		}
+
+		table := r.URL.Query().Get("table")
+		status, err := runAdminAction(action, table)
+		auditLog(r, action, table, err)
+		if err != nil {
+			writeJson(w, status, map[string]string{"error": err.Error()})
+		} else {
+			writeJson(w, status, map[string]string{"status": "ok"})
+		}
+	}
+}
+
+// Returns the HTTP status to answer with.
+func runAdminAction(action, table string) (int, error) {
+	if table == "" && (action == "resnapshot" || action == "cancel-resnapshot") {
+		return http.StatusBadRequest, errors.New("The 'table' parameter is required.")
+	}
+	var err error
+	if s := snapshotter.Load(); s != nil && getPhase() == PHASE_SNAPSHOT {
+		err = s.Admin(action, table, ADMIN_TIMEOUT)
+	} else if getPhase() == PHASE_STREAMING {
+		err = sendAdminCommand(idleAdminChan, action, table, ADMIN_TIMEOUT)
+	} else {
+		return http.StatusConflict, fmt.Errorf("The exporter can't take admin requests in the '%s' phase.", getPhase())
+	}
+	if err == errAdminTimeout {
+		return http.StatusServiceUnavailable, err
+	} else if err != nil {
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
+
+func validAdminToken(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// Every admin request gets logged with audit=true, so they're easy to pick out of the logs.
+func auditLog(r *http.Request, action, table string, err error) {
+	fields := []any{"audit", true, "action", action, "table", table, "remote_addr", r.RemoteAddr}
+	if err != nil {
+		adminLogger.Warn("Admin request failed", append(fields, "result", "error", "error", err)...)
+	} else {
+		adminLogger.Info("Admin request", append(fields, "result", "ok")...)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func postAdmin(t *testing.T, path, token string) (int, map[string]string) {
+	mux := http.NewServeMux()
+	RegisterAdminHandlers(mux)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("POST", path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer " + token)
+	}
+	mux.ServeHTTP(recorder, request)
+
+	body := map[string]string{}
+	if recorder.Code != http.StatusNotFound {
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	}
+	return recorder.Code, body
+}
+
+func TestAdminApiAuthentication(t *testing.T) {
+	code, _ := postAdmin(t, "/admin/pause", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	WithConfig("ADMIN_TOKEN", "sekrit", func() {
+		code, _ := postAdmin(t, "/admin/pause", "")
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = postAdmin(t, "/admin/pause", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		mux := http.NewServeMux()
+		RegisterAdminHandlers(mux)
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/admin/pause", nil)
+		request.Header.Set("Authorization", "Bearer sekrit")
+		mux.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
+
+func TestAdminApiErrors(t *testing.T) {
+	setPhase(PHASE_STANDBY)
+	defer setPhase(PHASE_STARTING)
+
+	WithLogBuffer(nil, func(logs *bytes.Buffer) {
+		WithConfig("ADMIN_TOKEN", "sekrit", func() {
+			code, body := postAdmin(t, "/admin/resnapshot", "sekrit")
+			assert.Equal(t, http.StatusBadRequest, code)
+			assert.Contains(t, body["error"], "'table' parameter")
+
+			code, body = postAdmin(t, "/admin/pause?table=foo", "sekrit")
+			assert.Equal(t, http.StatusConflict, code)
+			assert.Contains(t, body["error"], "'standby' phase")
+		})
+
+		lines := ParseJsonLogs(t, logs)
+		assert.Len(t, lines, 2)
+		entry := lines[1]
+		assert.Equal(t, true, entry["audit"])
+		assert.Equal(t, "admin", entry["component"])
+		assert.Equal(t, "pause", entry["action"])
+		assert.Equal(t, "foo", entry["table"])
+		assert.Equal(t, "error", entry["result"])
+		assert.Contains(t, entry["error"], "can't take admin requests")
+	})
+}
+
+func TestAdminPauseTables(t *testing.T) {
+	WithStateStorage(map[string]string{}, func() {
+		state := NewFakeSnapshotState([]string{"foo", "bar"}, 1000)
+		snapshotter := NewCustomSnapshotter(state)
+
+		assert.Error(t, snapshotter.runAdminCommand(adminCommand{"pause", "nope", nil}))
+		assert.NoError(t, snapshotter.runAdminCommand(adminCommand{"pause", "bar", nil}))
+		assert.True(t, state.TableStatuses()["bar"].Paused)
+		paused, _ := stateStorage.Get("table_snapshot_paused/bar")
+		assert.Equal(t, "true", paused)
+		for {
+			pi, ok := state.GetNextPendingInterval()
+			if !ok {
+				break
+			}
+			assert.Equal(t, "foo", pi.Schema.Name)
+		}
+
+		assert.ErrorContains(t, snapshotter.runAdminCommand(adminCommand{"resume", "foo", nil}), "Table 'foo' isn't paused.")
+		assert.NoError(t, snapshotter.runAdminCommand(adminCommand{"resume", "bar", nil}))
+		pi, ok := state.GetNextPendingInterval()
+		assert.True(t, ok)
+		assert.Equal(t, "bar", pi.Schema.Name)
+		keys, _ := stateStorage.Keys("table_snapshot_paused/")
+		assert.Empty(t, keys)
+
+		assert.NoError(t, snapshotter.runAdminCommand(adminCommand{"pause", "", nil}))
+		assert.True(t, snapshotter.status().Paused)
+		paused, _ = stateStorage.Get("snapshot_paused")
+		assert.Equal(t, "true", paused)
+		assert.NoError(t, snapshotter.runAdminCommand(adminCommand{"resume", "", nil}))
+		assert.False(t, snapshotter.status().Paused)
+	})
+}
+
+func TestAdminStateSurvivesRestarts(t *testing.T) {
+	WithStateStorage(map[string]string{
+		"snapshot_paused": "true",
+		"table_snapshot_paused/bar": "true",
+		"table_snapshot_paused/gone": "true",
+		"table_resnapshot/foo": "0-500",
+		"table_resnapshot/done": "done",
+	}, func() {
+		state := NewFakeSnapshotState([]string{"foo", "bar"}, 1000)
+		snapshotter := NewCustomSnapshotter(state)
+		assert.NoError(t, snapshotter.loadAdminState())
+
+		status := snapshotter.status()
+		assert.True(t, status.Paused)
+		assert.True(t, status.Tables["bar"].Paused)
+		assert.False(t, status.Tables["foo"].Paused)
+		assert.True(t, status.Tables["foo"].Resnapshot)
+		assert.Equal(t, map[string]string{"foo": "0-500"}, snapshotter.resnapshots)
+		// A re-snapshot of a table that's already done must have finished just before the restart.
+		keys, _ := stateStorage.Keys("table_resnapshot/")
+		assert.Equal(t, []string{"table_resnapshot/foo"}, keys)
+	})
+}
+
+func TestAdminAfterSnapshot(t *testing.T) {
+	WithStateStorage(map[string]string{"table_snapshot_progress/foo": "done"}, func() {
+		SetFakeResponses(FakeMysqlResponse{false, math.MaxInt, []string{"Tables"}, [][]any{{"foo"}, {"bar"}}})
+		oldShutdown := shutdown
+		shutdown = NewCustomShutdownCoordinator(time.Minute, func(int) {})
+		defer func() { shutdown = oldShutdown }()
+		setPhase(PHASE_STREAMING)
+		defer setPhase(PHASE_STARTING)
+		resnapshot := make(chan bool)
+		go func() { resnapshot <- waitForResnapshot() }()
+
+		WithConfig("ADMIN_TOKEN", "sekrit", func() {
+			code, body := postAdmin(t, "/admin/resume?table=foo", "sekrit")
+			assert.Equal(t, http.StatusConflict, code)
+			assert.Equal(t, "Table 'foo' isn't paused.", body["error"])
+			code, _ = postAdmin(t, "/admin/pause?table=nope", "sekrit")
+			assert.Equal(t, http.StatusConflict, code)
+			code, _ = postAdmin(t, "/admin/pause?table=foo", "sekrit")
+			assert.Equal(t, http.StatusOK, code)
+			code, _ = postAdmin(t, "/admin/pause", "sekrit")
+			assert.Equal(t, http.StatusOK, code)
+			code, _ = postAdmin(t, "/admin/resume", "sekrit")
+			assert.Equal(t, http.StatusOK, code)
+			code, _ = postAdmin(t, "/admin/cancel-resnapshot?table=foo", "sekrit")
+			assert.Equal(t, http.StatusConflict, code)
+
+			// A re-snapshot sends us back to main(), to start another snapshot.
+			code, _ = postAdmin(t, "/admin/resnapshot?table=foo", "sekrit")
+			assert.Equal(t, http.StatusOK, code)
+		})
+		assert.True(t, <-resnapshot)
+
+		contents := map[string]string{}
+		for _, key := range []string{"snapshot_paused", "table_snapshot_paused/foo", "table_snapshot_progress/foo", "table_resnapshot/foo"} {
+			contents[key], _ = stateStorage.Get(key)
+		}
+		assert.Equal(t, map[string]string{
+			"snapshot_paused": "",
+			"table_snapshot_paused/foo": "",
+			"table_snapshot_progress/foo": "",
+			"table_resnapshot/foo": "done",
+		}, contents)
+
+		go func() { resnapshot <- waitForResnapshot() }()
+		shutdown.Shutdown("testing", func() {})
+		assert.False(t, <-resnapshot)
+	})
+}
+
+func TestAdminResnapshot(t *testing.T) {
+	WithStateStorage(map[string]string{"table_snapshot_progress/foo": "0-500"}, func() {
+		state := NewFakeSnapshotState([]string{"foo", "bar"}, 1000)
+		for _, table := range state.(*FakeSnapshotState).Tables {
+			tableSchemaCache[table.Schema.Name] = table.Schema
+		}
+		defer delete(tableSchemaCache, "foo")
+		defer delete(tableSchemaCache, "bar")
+		SetFakeResponses(FakeMysqlResponse{false, math.MaxInt, []string{"Tables"}, [][]any{{"foo"}, {"bar"}}})
+		snapshotter := NewCustomSnapshotter(state)
+		snapshotter.busyIntervals["foo"] = 1
+
+		// It waits for the interval in progress before starting over.
+		assert.NoError(t, snapshotter.runAdminCommand(adminCommand{"resnapshot", "foo", nil}))
+		assert.False(t, state.HasTable("foo"))
+		assert.Contains(t, snapshotter.drainingTables, "foo")
+		progress, _ := stateStorage.Get("table_snapshot_progress/foo")
+		assert.Equal(t, "", progress)
+		progress, _ = stateStorage.Get("table_resnapshot/foo")
+		assert.Equal(t, "0-500", progress)
+		assert.Error(t, snapshotter.runAdminCommand(adminCommand{"resnapshot", "foo", nil}))
+
+		delete(snapshotter.busyIntervals, "foo")
+		snapshotter.finishDraining("foo")
+		assert.True(t, state.HasTable("foo"))
+		assert.True(t, snapshotter.status().Tables["foo"].Resnapshot)
+		assert.Error(t, snapshotter.runAdminCommand(adminCommand{"resnapshot", "nope", nil}))
+
+		// Cancelling puts the old progress back.
+		assert.NoError(t, snapshotter.runAdminCommand(adminCommand{"cancel-resnapshot", "foo", nil}))
+		progress, _ = stateStorage.Get("table_snapshot_progress/foo")
+		assert.Equal(t, "0-500", progress)
+		keys, _ := stateStorage.Keys("table_resnapshot/")
+		assert.Empty(t, keys)
+		assert.True(t, state.HasTable("foo"))
+		assert.False(t, snapshotter.status().Tables["foo"].Resnapshot)
+		assert.Error(t, snapshotter.runAdminCommand(adminCommand{"cancel-resnapshot", "foo", nil}))
+
+		// Nothing new can start once the snapshot is finishing.
+		snapshotter.pendingClosed = true
+		assert.Error(t, snapshotter.runAdminCommand(adminCommand{"resnapshot", "bar", nil}))
+	})
+}
+
+func TestAdminPauseRunningSnapshot(t *testing.T) {
+	sink := &FakeSink{Delay: time.Millisecond}
+	sinks = []Sink{sink}
+	defer func() {
+		sinks = nil
+		snapshotter.Store(nil)
+	}()
+	SetFakeResponses(
+		FakeMysqlResponse{false, math.MaxInt, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+	rowsEvents := func() int {
+		sink.Lock.Lock()
+		defer sink.Lock.Unlock()
+		return sink.RowsEvents
+	}
+
+	os.Setenv("SNAPSHOT_CHUNK_SIZE", "100")
+	defer os.Unsetenv("SNAPSHOT_CHUNK_SIZE")
+	WithConfig("ADMIN_TOKEN", "sekrit", func() {
+		s := NewCustomSnapshotter(NewFakeSnapshotState([]string{"foo"}, 1_000_000))
+		snapshotter.Store(s)
+		setPhase(PHASE_SNAPSHOT)
+		defer setPhase(PHASE_STARTING)
+		done := make(chan bool)
+		go func() { done <- s.Run() }()
+		assert.Eventually(t, func() bool { return rowsEvents() > 0 }, 5 * time.Second, time.Millisecond)
+
+		code, _ := postAdmin(t, "/admin/pause", "sekrit")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Eventually(t, func() bool {
+			status, _ := s.Status(STATUS_TIMEOUT)
+			return status.Paused && status.Workers.Busy == 0
+		}, 5 * time.Second, time.Millisecond)
+		paused := rowsEvents()
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, paused, rowsEvents())
+
+		code, _ = postAdmin(t, "/admin/resume", "sekrit")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Eventually(t, func() bool { return rowsEvents() > paused }, 5 * time.Second, time.Millisecond)
+
+		s.Exit()
+		<-done
+	})
+}
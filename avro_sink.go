@@ -0,0 +1,338 @@
+// Writes tables to Avro object container files, with a record schema made from each table's schema. See
+// https://avro.apache.org/docs/1.11.1/specification/ for the formats; we only need a small part of it, so we
+// encode everything ourselves.
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var AVRO_CODECS = []string{"deflate", "null"}
+
+func init() {
+	RegisterSinkType("avro", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, append(ROTATING_FILE_PARAMS, "codec")...); err != nil {
+			return nil, err
+		}
+		settings, err := ParseRotatingFileSettings(params)
+		if err != nil {
+			return nil, err
+		}
+		codec := "deflate"
+		if value, ok := params["codec"]; ok {
+			if value == "none" {
+				value = "null"
+			}
+			if !StringInList(value, AVRO_CODECS) {
+				return nil, fmt.Errorf("Bogus value for codec: '%s' (expected deflate or none)", params["codec"])
+			}
+			codec = value
+		}
+		return NewAvroSink(settings, codec), nil
+	})
+}
+
+// Each table gets its own series of files. A schema change finishes the current file, and the next one gets the
+// new schema; files are also finished when they get too big or too old, like the JsonlSink's.
+type AvroSink struct {
+	Lock sync.Mutex
+	RotatingFileSettings
+	Codec string
+	Files map[string]*RotatingFile
+	Tables map[string]*avroTable
+	done chan struct{}
+	exitOnce sync.Once
+}
+
+type avroTable struct {
+	Schema *TableSchema
+	Header []byte
+	Sync []byte
+}
+
+func NewAvroSink(settings RotatingFileSettings, codec string) *AvroSink {
+	sink := &AvroSink{
+		sync.Mutex{}, settings, codec,
+		make(map[string]*RotatingFile), make(map[string]*avroTable), make(chan struct{}), sync.Once{},
+	}
+	if settings.MaxAge > 0 {
+		go rotateOldFiles(sinkLogger.With("directory", settings.Directory), settings.MaxAge, &sink.Lock, sink.Files, sink.done)
+	}
+	return sink
+}
+
+func (sink *AvroSink) Open(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	table, err := sink.newAvroTable(ts)
+	if err != nil {
+		return err
+	}
+	file := sink.NewFile(ts.Name, "avro")
+	file.OnOpen = func(f *os.File) error {
+		_, err := f.Write(sink.Tables[ts.Name].Header)
+		return err
+	}
+	sink.Files[ts.Name] = file
+	sink.Tables[ts.Name] = table
+	return nil
+}
+
+func (sink *AvroSink) newAvroTable(ts *TableSchema) (*avroTable, error) {
+	schema, err := AvroSchema(ts)
+	if err != nil {
+		return nil, err
+	}
+	sync := make([]byte, 16)
+	if _, err = rand.Read(sync); err != nil {
+		return nil, err
+	}
+	return &avroTable{ts, avroHeader(schema, sink.Codec, sync), sync}, nil
+}
+
+func (sink *AvroSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[ts.Name]
+	if !ok {
+		panic(fmt.Errorf("Can't close non-existent file for table '%s'!", ts.Name))
+	}
+	delete(sink.Files, ts.Name)
+	delete(sink.Tables, ts.Name)
+	return file.Finish()
+}
+
+// Each batch of rows becomes one block.
+func (sink *AvroSink) WriteRows(rows RowsEvent) {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	table, ok := sink.Tables[rows.Schema.Name]
+	if !ok {
+		panic(fmt.Errorf("Can't find file for table '%s'!", rows.Schema.Name))
+	}
+
+	if len(rows.Data) == 0 {
+		rows.ResponseChan <- nil
+		return
+	}
+	data := []byte{}
+	var err error
+	for _, row := range rows.Data {
+		for i, column := range table.Schema.Columns {
+			if data, err = appendAvroValue(data, row[i], column); err != nil {
+				rows.ResponseChan <- err
+				return
+			}
+		}
+	}
+	block, err := avroBlock(len(rows.Data), data, sink.Codec, table.Sync)
+	if err == nil {
+		_, err = sink.Files[rows.Schema.Name].Write(block)
+	}
+	rows.ResponseChan <- err
+}
+
+func (sink *AvroSink) SchemaChange(newSchema *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[newSchema.Name]
+	if !ok {
+		return fmt.Errorf("Can't change the schema of table '%s', which was never opened", newSchema.Name)
+	}
+	table, err := sink.newAvroTable(newSchema)
+	if err != nil {
+		return err
+	}
+	if err = file.Finish(); err != nil {
+		return err
+	}
+	sink.Tables[newSchema.Name] = table
+	return nil
+}
+
+// Safe to call more than once.
+func (sink *AvroSink) Exit() error {
+	sink.exitOnce.Do(func() { close(sink.done) })
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	var firstErr error
+	for _, file := range sink.Files {
+		if err := file.Finish(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var invalidAvroNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Avro names can only have letters, digits and underscores, and can't start with a digit.
+func avroName(name string) string {
+	name = invalidAvroNameRegexp.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// Makes an Avro record schema for the table, in JSON.
+// Columns whose names come out the same once they're made into Avro names are an error, since Avro readers reject
+// records with duplicate fields.
+func AvroSchema(ts *TableSchema) (string, error) {
+	fields := make([]map[string]any, len(ts.Columns))
+	columnsByName := map[string]string{}
+	for i, column := range ts.Columns {
+		avroType, err := avroType(column)
+		if err != nil {
+			return "", err
+		}
+		name := avroName(column.Name)
+		if other, found := columnsByName[name]; found {
+			return "", fmt.Errorf("Columns '%s' and '%s' of table '%s' would both be called '%s' in Avro", other, column.Name, ts.Name, name)
+		}
+		columnsByName[name] = column.Name
+		fields[i] = map[string]any{"name": name, "type": avroType}
+		if column.Nullable {
+			fields[i]["type"] = []any{"null", avroType}
+			fields[i]["default"] = nil
+		}
+	}
+	schema, err := json.Marshal(map[string]any{"type": "record", "name": avroName(ts.Name), "fields": fields})
+	return string(schema), err
+}
+
+func avroType(column Column) (any, error) {
+	switch column.SqlType {
+	case "tinyint", "smallint", "mediumint":
+		return "int", nil
+	case "int":
+		if column.Signed {
+			return "int", nil
+		}
+		return "long", nil
+	case "bigint":
+		return "long", nil
+	case "float":
+		return "float", nil
+	case "double":
+		return "double", nil
+	case "decimal":
+		return map[string]any{"type": "bytes", "logicalType": "decimal", "precision": column.Width, "scale": column.Scale}, nil
+	case "char", "varchar", "text", "mediumtext", "longtext":
+		return "string", nil
+	case "binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob":
+		return "bytes", nil
+	case "date":
+		return map[string]any{"type": "int", "logicalType": "date"}, nil
+	case "time":
+		return map[string]any{"type": "long", "logicalType": "time-micros"}, nil
+	case "datetime", "timestamp":
+		return map[string]any{"type": "long", "logicalType": "timestamp-micros"}, nil
+	default:
+		return nil, fmt.Errorf("Can't convert SQL type '%s' of column '%s' to Avro", column.SqlType, column.Name)
+	}
+}
+
+// Appends the binary encoding of a value. Avro's ints and longs are both zig-zag varints, which is what
+// binary.AppendVarint writes.
+func appendAvroValue(buf []byte, datum any, column Column) ([]byte, error) {
+	if column.Nullable {
+		if datum == nil {
+			return binary.AppendVarint(buf, 0), nil  // The index of "null" in the union.
+		}
+		buf = binary.AppendVarint(buf, 1)
+	} else if datum == nil {
+		return nil, fmt.Errorf("Got a null for non-nullable column '%s'", column.Name)
+	}
+
+	switch datum.(type) {
+	case int8:   return binary.AppendVarint(buf, int64(datum.(int8))), nil
+	case uint8:  return binary.AppendVarint(buf, int64(datum.(uint8))), nil
+	case int16:  return binary.AppendVarint(buf, int64(datum.(int16))), nil
+	case uint16: return binary.AppendVarint(buf, int64(datum.(uint16))), nil
+	case int32:  return binary.AppendVarint(buf, int64(datum.(int32))), nil
+	case uint32: return binary.AppendVarint(buf, int64(datum.(uint32))), nil
+	case int64:  return binary.AppendVarint(buf, datum.(int64)), nil
+	case uint64:
+		if datum.(uint64) > math.MaxInt64 {
+			return nil, fmt.Errorf("Value %d of column '%s' is too big for an Avro long", datum.(uint64), column.Name)
+		}
+		return binary.AppendVarint(buf, int64(datum.(uint64))), nil
+
+	case float32: return binary.LittleEndian.AppendUint32(buf, math.Float32bits(datum.(float32))), nil
+	case float64: return binary.LittleEndian.AppendUint64(buf, math.Float64bits(datum.(float64))), nil
+
+	case string:  return appendAvroBytes(buf, []byte(datum.(string))), nil
+	case []uint8: return appendAvroBytes(buf, datum.([]uint8)), nil
+	case *big.Rat:
+		bytes, err := decimalBytes(datum.(*big.Rat), column)
+		if err != nil {
+			return nil, fmt.Errorf("Can't convert column '%s': %w", column.Name, err)
+		}
+		return appendAvroBytes(buf, bytes), nil
+
+	case time.Time:
+		t := datum.(time.Time)
+		switch column.SqlType {
+		case "date":
+			return binary.AppendVarint(buf, int64(EpochDays(t))), nil
+		case "time":
+			midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			return binary.AppendVarint(buf, t.Sub(midnight).Microseconds()), nil
+		default:
+			return binary.AppendVarint(buf, t.UnixMicro()), nil
+		}
+
+	default:
+		return nil, fmt.Errorf("Unexpected type for Avro: '%T'", datum)
+	}
+}
+
+func appendAvroBytes(buf []byte, data []byte) []byte {
+	return append(binary.AppendVarint(buf, int64(len(data))), data...)
+}
+
+// The magic number, the metadata (as an Avro map of bytes) and the sync marker.
+func avroHeader(schema, codec string, sync []byte) []byte {
+	header := []byte("Obj\x01")
+	header = binary.AppendVarint(header, 2)
+	header = appendAvroBytes(header, []byte("avro.schema"))
+	header = appendAvroBytes(header, []byte(schema))
+	header = appendAvroBytes(header, []byte("avro.codec"))
+	header = appendAvroBytes(header, []byte(codec))
+	header = binary.AppendVarint(header, 0)
+	return append(header, sync...)
+}
+
+// The number of rows, the size of the (possibly compressed) data, the data and the sync marker.
+func avroBlock(count int, data []byte, codec string, sync []byte) ([]byte, error) {
+	if codec == "deflate" {
+		var compressed bytes.Buffer
+		writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+		data = compressed.Bytes()
+	}
+	block := binary.AppendVarint([]byte{}, int64(count))
+	block = appendAvroBytes(block, data)
+	return append(block, sync...), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Just enough of an Avro decoder to read back what the sink writes. Unions come back as their value (or nil),
+// and logical types as their underlying type.
+type avroDecoder struct {
+	data []byte
+}
+
+func (d *avroDecoder) long() int64 {
+	value, n := binary.Varint(d.data)
+	if n <= 0 {
+		panic("Bogus Avro varint")
+	}
+	d.data = d.data[n:]
+	return value
+}
+
+func (d *avroDecoder) bytes() []byte {
+	length := d.long()
+	value := d.data[:length]
+	d.data = d.data[length:]
+	return value
+}
+
+func (d *avroDecoder) fixed(length int) []byte {
+	value := d.data[:length]
+	d.data = d.data[length:]
+	return value
+}
+
+func (d *avroDecoder) value(avroType any) any {
+	switch avroType := avroType.(type) {
+	case []any:
+		if d.long() == 0 {
+			return nil
+		}
+		return d.value(avroType[1])
+	case map[string]any:
+		return d.value(avroType["type"])
+	case string:
+		switch avroType {
+		case "int": return int32(d.long())
+		case "long": return d.long()
+		case "float": return math.Float32frombits(binary.LittleEndian.Uint32(d.fixed(4)))
+		case "double": return math.Float64frombits(binary.LittleEndian.Uint64(d.fixed(8)))
+		case "string": return string(d.bytes())
+		case "bytes": return d.bytes()
+		}
+	}
+	panic(fmt.Sprintf("Unexpected Avro type %v", avroType))
+}
+
+// Reads every record from the Avro files matching the pattern, in order, along with each file's schema.
+func readAvroFiles(t *testing.T, pattern string) (schemas []string, records []map[string]any) {
+	paths, err := filepath.Glob(pattern)
+	assert.NoError(t, err)
+	sort.Strings(paths)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		if !assert.Equal(t, "Obj\x01", string(data[:4])) {
+			continue
+		}
+		d := &avroDecoder{data[4:]}
+		metadata := map[string]string{}
+		for count := d.long(); count != 0; count = d.long() {
+			for ; count > 0; count-- {
+				key := string(d.bytes())
+				metadata[key] = string(d.bytes())
+			}
+		}
+		sync := d.fixed(16)
+		schemas = append(schemas, metadata["avro.schema"])
+		var schema struct{ Fields []struct{ Name string; Type any } }
+		assert.NoError(t, json.Unmarshal([]byte(metadata["avro.schema"]), &schema))
+
+		for len(d.data) > 0 {
+			count := d.long()
+			block := d.bytes()
+			assert.Equal(t, sync, d.fixed(16))
+			if metadata["avro.codec"] == "deflate" {
+				block, err = io.ReadAll(flate.NewReader(bytes.NewReader(block)))
+				assert.NoError(t, err)
+			}
+			blockDecoder := &avroDecoder{block}
+			for ; count > 0; count-- {
+				record := map[string]any{}
+				for _, field := range schema.Fields {
+					record[field.Name] = blockDecoder.value(field.Type)
+				}
+				records = append(records, record)
+			}
+			assert.Empty(t, blockDecoder.data)
+		}
+	}
+	return schemas, records
+}
+
+var avroTestSchema = ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`price` decimal(6,2) DEFAULT NULL,\n" +
+	"`blob` varbinary(10),\n`born` date,\n`alarm` time,\n`at` datetime(6),\n`small` tinyint NOT NULL,\n" +
+	"`ratio` double,\n`name` varchar(10)\n)")
+
+func TestAvroSink(t *testing.T) {
+	for _, codec := range AVRO_CODECS {
+		dir := t.TempDir()
+		sink := NewAvroSink(RotatingFileSettings{dir, 0, 0, nil}, codec)
+		assert.NoError(t, sink.Open(avroTestSchema))
+
+		at := time.Date(2021, 10, 29, 6, 5, 22, 123456000, time.UTC)
+		alarm, _ := time.Parse("15:04:05", "06:30:00")
+		second, _ := time.Parse("15:04:05", "00:00:01")
+		rows := RowsEvent{make(chan error, 1), avroTestSchema, [][]any{
+			{uint64(1), big.NewRat(-12345, 100), []byte("hi"), time.Date(1973, 8, 30, 0, 0, 0, 0, time.UTC), alarm, at, int8(-5), 0.1, "honk"},
+			{uint64(2), nil, nil, time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC), second, nil, int8(5), nil, nil},
+		}, RowsSource{}}
+		sink.WriteRows(rows)
+		assert.NoError(t, <-rows.ResponseChan)
+		assert.NoError(t, sink.Exit())
+
+		_, records := readAvroFiles(t, filepath.Join(dir, "foo.*.avro"))
+		if !assert.Len(t, records, 2, codec) {
+			continue
+		}
+		assert.Equal(t, map[string]any{
+			"id": int64(1), "price": []byte{0xcf, 0xc7}, "blob": []byte("hi"), "born": int32(1337),
+			"alarm": (6 * time.Hour + 30 * time.Minute).Microseconds(), "at": at.UnixMicro(), "small": int32(-5),
+			"ratio": 0.1, "name": "honk",
+		}, records[0])
+		assert.Equal(t, map[string]any{
+			"id": int64(2), "price": nil, "blob": nil, "born": int32(-1), "alarm": time.Second.Microseconds(),
+			"at": nil, "small": int32(5), "ratio": nil, "name": nil,
+		}, records[1])
+	}
+}
+
+func TestAvroSinkSchemaChange(t *testing.T) {
+	dir := t.TempDir()
+	oldSchema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL\n)")
+	newSchema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`name` varchar(10) NOT NULL\n)")
+	sink := NewAvroSink(RotatingFileSettings{dir, 0, 0, nil}, "deflate")
+	assert.NoError(t, sink.Open(oldSchema))
+
+	rows := RowsEvent{make(chan error, 1), oldSchema, [][]any{{uint64(1)}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+	assert.NoError(t, sink.SchemaChange(newSchema))
+	rows = RowsEvent{make(chan error, 1), newSchema, [][]any{{uint64(2), "honk"}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+	assert.NoError(t, sink.Exit())
+
+	schemas, records := readAvroFiles(t, filepath.Join(dir, "foo.*.avro"))
+	assert.Equal(t, []string{
+		`{"fields":[{"name":"id","type":"long"}],"name":"foo","type":"record"}`,
+		`{"fields":[{"name":"id","type":"long"},{"name":"name","type":"string"}],"name":"foo","type":"record"}`,
+	}, schemas)
+	assert.Equal(t, []map[string]any{{"id": int64(1)}, {"id": int64(2), "name": "honk"}}, records)
+}
+
+func TestAvroSchema(t *testing.T) {
+	schema, err := AvroSchema(ParseSchema(MustReadFile("test_schemas/all_number_types.sql")))
+	assert.NoError(t, err)
+	assert.Contains(t, schema, `{"default":null,"name":"tinyint_so","type":["null","int"]}`)
+	assert.Contains(t, schema, `{"name":"int_ur","type":"long"}`)
+	assert.Contains(t, schema, `{"default":null,"name":"float_o","type":["null","float"]}`)
+	assert.Contains(t, schema, `{"name":"smalldecimal_r","type":{"logicalType":"decimal","precision":6,"scale":3,"type":"bytes"}}`)
+
+	_, err = AvroSchema(ParseSchema("CREATE TABLE `foo` (\n`shape` geometry NOT NULL\n)"))
+	assert.ErrorContains(t, err, "Can't convert SQL type 'geometry' of column 'shape' to Avro")
+	assert.Equal(t, "_2fast_2_furious", avroName("2fast-2-furious"))
+
+	_, err = AvroSchema(ParseSchema("CREATE TABLE `foo` (\n`a-b` int NOT NULL,\n`a_b` int NOT NULL\n)"))
+	assert.EqualError(t, err, "Columns 'a-b' and 'a_b' of table 'foo' would both be called 'a_b' in Avro")
+}
+
+func TestAvroSinkParams(t *testing.T) {
+	_, err := sinkTypes["avro"](map[string]string{"directory": "/tmp", "codec": "snappy"})
+	assert.ErrorContains(t, err, "Bogus value for codec: 'snappy' (expected deflate or none)")
+
+	sink, err := sinkTypes["avro"](map[string]string{"directory": "/tmp", "codec": "none"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "null", sink.(*AvroSink).Codec)
+	assert.NoError(t, sink.Exit())
+	assert.NoError(t, sink.Exit())  // Exiting twice is fine.
+}
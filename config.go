@@ -1,13 +1,17 @@
-// A simple global object for storing configuration settings.
+// A simple global object for storing configuration settings. Settings come from environment variables, or from
+// a YAML file named by CONFIG_FILE; if both are present, the environment variable wins.
 
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 const DEFAULT_SNAPSHOT_CHUNK_SIZE = 100_000
@@ -17,7 +21,15 @@ const DEFAULT_DATADOG_PORT = "8125"
 const DEFAULT_MYSQL_PORT = "3306"
 const DEFAULT_REDIS_PORT = "6379"
 const DEFAULT_SNAPSHOT_WORKERS = 10
-const DEFAULT_MYSQL_CONNECTIONS = int64(10)
+const RESERVED_MYSQL_CONNS = 2  // Kept free for metadata queries (schemas, binlog positions) while the workers are busy.
+// Enough for every default worker plus the reserved connections, so that the default config passes Validate().
+const DEFAULT_MYSQL_CONNECTIONS = int64(DEFAULT_SNAPSHOT_WORKERS + RESERVED_MYSQL_CONNS)
+const DEFAULT_LEADER_LEASE_TTL = 30 * time.Second
+const DEFAULT_HTTP_ADDRESS = ":8080"
+const DEFAULT_SHUTDOWN_TIMEOUT = 25 * time.Second  // Kubernetes kills us after 30 seconds by default.
+const DEFAULT_SINK_WRITE_TIMEOUT = 5 * time.Minute
+const DEFAULT_SINK_STUCK_POLICY = "requeue"
+const DEFAULT_SINK_BUFFER_SIZE = 10
 
 type Config struct {
 	MysqlHost string
@@ -34,12 +46,34 @@ type Config struct {
 	S3Path string
 
 	ExcludeTables []string
+	Tables map[string]TableConfig
+	Sinks map[string]SinkConfig
 
 	SnapshotChunkSize uint64
 	SnapshotWorkers int64
 
+	LeaderLeaseTTL time.Duration
+	ShutdownTimeout time.Duration
+
+	MetricsBackend string
 	DatadogHost string
 	DatadogPort string
+	HttpAddress string
+	AdminToken string  // The admin API is turned off unless this is set.
+
+	LogLevel string
+	LogFormat string
+	LogLevels map[string]string  // Per-component overrides for LogLevel.
+
+	DeadLetterSink string
+	DeadLetterPath string
+	DeadLetterBudget int64  // How many rows can fail to convert before we give up.
+
+	SinkWriteTimeout time.Duration
+	SinkWriteTimeouts map[string]time.Duration  // Per-sink overrides for SinkWriteTimeout.
+	SinkStuckPolicy string                      // What to do when a sink misses its write deadline.
+	SinkBufferSize int64                        // How many batches each sink can be working on at once.
+	OptionalSinks []string                      // Sinks whose failures don't hold up the snapshot.
 
 	BugsnagApiKey string
 	BugsnagReleaseStage string
@@ -49,105 +83,408 @@ type Config struct {
 	SyntheticColumnTypes map[string]string
 	SyntheticColumnNames string
 	SyntheticColumnValues string
+	SyntheticColumnDefs []SyntheticColumn
+
+	// Problems we ran into while parsing the settings. Reported by Validate().
+	errors []error
+}
+
+// A sink from the config file. Every setting other than `type` is passed to the sink's constructor.
+type SinkConfig struct {
+	Type string `yaml:"type"`
+	Params map[string]string `yaml:",inline"`
+}
+
+// Per-table settings, which can only be set in the config file.
+type TableConfig struct {
+	ChunkSize uint64 `yaml:"chunk_size"`
+	IncludeColumns []string `yaml:"include_columns"`
+	ExcludeColumns []string `yaml:"exclude_columns"`
+	RowFilter string `yaml:"row_filter"`  // Trusted SQL, pasted into the WHERE clause as it is.
+	Sinks []string `yaml:"sinks"`
+}
+
+// Every setting that can be given as an environment variable. The config file uses the same names in lower case.
+var CONFIG_SETTINGS = []string{
+	"MYSQL_HOST", "MYSQL_PORT", "MYSQL_DATABASE", "MYSQL_USER", "MYSQL_PASSWORD", "MYSQL_MAX_CONNS",
+	"REDIS_HOST", "REDIS_PORT", "REDIS_PASSWORD",
+	"S3_PATH",
+	"EXCLUDE_TABLES",
+	"SNAPSHOT_CHUNK_SIZE", "SNAPSHOT_WORKERS",
+	"LEADER_LEASE_TTL", "SHUTDOWN_TIMEOUT",
+	"METRICS_BACKEND", "DATADOG_HOST", "DATADOG_PORT", "HTTP_ADDRESS", "ADMIN_TOKEN",
+	"LOG_LEVEL", "LOG_FORMAT", "LOG_LEVELS",
+	"DEAD_LETTER_SINK", "DEAD_LETTER_PATH", "DEAD_LETTER_BUDGET",
+	"SINK_WRITE_TIMEOUT", "SINK_WRITE_TIMEOUTS", "SINK_STUCK_POLICY", "SINK_BUFFER_SIZE", "OPTIONAL_SINKS",
+	"BUGSNAG_API_KEY", "BUGSNAG_RELEASE_STAGE",
+	"CLIO_REGION",
+	"SYNTHETIC_COLUMNS",
+}
+
+type ConfigFile struct {
+	Settings map[string]string
+	Tables map[string]TableConfig
+	Sinks map[string]SinkConfig
+}
+
+type configFileContents struct {
+	Settings map[string]yaml.Node `yaml:",inline"`
+	Tables map[string]TableConfig `yaml:"tables"`
+	Sinks map[string]SinkConfig `yaml:"sinks"`
+}
+
+// Reads and validates a YAML config file. All of the problems with the file are returned together.
+func LoadConfigFile(filename string) (ConfigFile, error) {
+	file := ConfigFile{map[string]string{}, map[string]TableConfig{}, map[string]SinkConfig{}}
+	f, err := os.Open(filename)
+	if err != nil {
+		return file, fmt.Errorf("Can't open config file: %s", err)
+	}
+	defer f.Close()
+
+	var contents configFileContents
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	errs := []error{}
+	if err = decoder.Decode(&contents); err != nil {
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			return file, fmt.Errorf("Can't parse config file %s: %s", filename, err)
+		}
+		for _, message := range typeError.Errors {
+			errs = append(errs, errors.New(message))
+		}
+	}
+
+	knownSettings := make([]string, len(CONFIG_SETTINGS))
+	for i, setting := range CONFIG_SETTINGS {
+		knownSettings[i] = strings.ToLower(setting)
+	}
+	for name, node := range contents.Settings {
+		if !StringInList(name, knownSettings) {
+			errs = append(errs, fmt.Errorf("line %d: unknown setting '%s'", node.Line, name))
+			continue
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			file.Settings[name] = node.Value
+		case yaml.SequenceNode:
+			values := make([]string, len(node.Content))
+			for i, item := range node.Content {
+				values[i] = item.Value
+			}
+			file.Settings[name] = strings.Join(values, ",")
+		default:
+			errs = append(errs, fmt.Errorf("line %d: setting '%s' must be a string or a list", node.Line, name))
+		}
+	}
+
+	for tableName, table := range contents.Tables {
+		if len(table.IncludeColumns) > 0 && len(table.ExcludeColumns) > 0 {
+			errs = append(errs, fmt.Errorf("Table '%s' can't have both include_columns and exclude_columns", tableName))
+		}
+		if len(table.IncludeColumns) > 0 && !StringInList("id", table.IncludeColumns) {
+			errs = append(errs, fmt.Errorf("Table '%s' must include the 'id' column", tableName))
+		}
+		if StringInList("id", table.ExcludeColumns) {
+			errs = append(errs, fmt.Errorf("Table '%s' can't exclude the 'id' column", tableName))
+		}
+		if table.RowFilter != "" && strings.Contains(table.RowFilter, ";") {
+			errs = append(errs, fmt.Errorf("Table '%s' has a row_filter containing ';'", tableName))
+		}
+		file.Tables[tableName] = table
+	}
+
+	for sinkName, sink := range contents.Sinks {
+		if sink.Type == "" {
+			errs = append(errs, fmt.Errorf("Sink '%s' doesn't have a type", sinkName))
+		}
+		if sink.Params == nil {
+			sink.Params = map[string]string{}
+		}
+		file.Sinks[sinkName] = sink
+	}
+
+	if len(errs) > 0 {
+		return file, fmt.Errorf("Invalid config file %s:\n%w", filename, errors.Join(errs...))
+	}
+	return file, nil
+}
+
+// Looks settings up in the environment first, then the config file.
+type configSource struct {
+	file ConfigFile
 }
 
+func (src configSource) lookup(name string) (string, bool) {
+	if value, found := os.LookupEnv(name); found {
+		return value, true
+	}
+	value, found := src.file.Settings[strings.ToLower(name)]
+	return value, found
+}
+
+func (src configSource) get(name string) string {
+	value, _ := src.lookup(name)
+	return value
+}
+
+// Reads the configuration. This never fails; if any of the settings are bogus, Validate() will tell you.
 func NewConfig() Config {
+	errs := []error{}
+	file := ConfigFile{map[string]string{}, map[string]TableConfig{}, map[string]SinkConfig{}}
+	if filename, found := os.LookupEnv("CONFIG_FILE"); found {
+		var err error
+		file, err = LoadConfigFile(filename)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	source := configSource{file}
+
 	var snapshotChunkSize int64 = DEFAULT_SNAPSHOT_CHUNK_SIZE
 	var snapshotWorkers int64 = DEFAULT_SNAPSHOT_WORKERS
 	var err error
 	datadogHost := DEFAULT_DATADOG_HOST
 	datadogPort := DEFAULT_DATADOG_PORT
+	metricsBackend := "datadog"
+	httpAddress := DEFAULT_HTTP_ADDRESS
 	bugsnagReleaseStage := "production"
 	mysqlPort := DEFAULT_MYSQL_PORT
 	redisPort := DEFAULT_REDIS_PORT
 	excludeTables := []string{}
 	maxMysqlConns := DEFAULT_MYSQL_CONNECTIONS
+	leaderLeaseTTL := DEFAULT_LEADER_LEASE_TTL
+	shutdownTimeout := DEFAULT_SHUTDOWN_TIMEOUT
+	logLevel := DEFAULT_LOG_LEVEL
+	logFormat := DEFAULT_LOG_FORMAT
+	logLevels := map[string]string{}
+	deadLetterSink := DEFAULT_DEAD_LETTER_SINK
+	deadLetterPath := DEFAULT_DEAD_LETTER_PATH
+	var deadLetterBudget int64 = DEFAULT_DEAD_LETTER_BUDGET
+	sinkWriteTimeout := DEFAULT_SINK_WRITE_TIMEOUT
+	sinkWriteTimeouts := map[string]time.Duration{}
+	sinkStuckPolicy := DEFAULT_SINK_STUCK_POLICY
+	var sinkBufferSize int64 = DEFAULT_SINK_BUFFER_SIZE
+	optionalSinks := []string{}
 
-	value, found := os.LookupEnv("MYSQL_PORT")
+	value, found := source.lookup("MYSQL_PORT")
 	if found {
 		mysqlPort = value
 	}
 
-	value, found = os.LookupEnv("MYSQL_MAX_CONNS")
+	value, found = source.lookup("MYSQL_MAX_CONNS")
 	if found {
 		maxMysqlConns, err = strconv.ParseInt(value, 10, 32)
 		if err != nil || maxMysqlConns <= 0 || maxMysqlConns > 10_000 {
-			panic(fmt.Sprintf("Bogus value for SNAPSHOT_CHUNK_SIZE: '%s'", value))
+			errs = append(errs, fmt.Errorf("Bogus value for MYSQL_MAX_CONNS: '%s'", value))
 		}
 	}
 
-	value, found = os.LookupEnv("REDIS_PORT")
+	value, found = source.lookup("REDIS_PORT")
 	if found {
 		redisPort = value
 	}
 
-	value, found = os.LookupEnv("SNAPSHOT_CHUNK_SIZE")
+	value, found = source.lookup("SNAPSHOT_CHUNK_SIZE")
 	if found {
 		snapshotChunkSize, err = strconv.ParseInt(value, 10, 32)
 		if err != nil || snapshotChunkSize <= 0 {
-			panic(fmt.Sprintf("Bogus value for SNAPSHOT_CHUNK_SIZE: '%s'", value))
+			errs = append(errs, fmt.Errorf("Bogus value for SNAPSHOT_CHUNK_SIZE: '%s'", value))
 		}
 	}
 
-	value, found = os.LookupEnv("SNAPSHOT_WORKERS")
+	value, found = source.lookup("SNAPSHOT_WORKERS")
 	if found {
 		snapshotWorkers, err = strconv.ParseInt(value, 10, 32)
 		if err != nil || snapshotWorkers <= 0 {
-			panic(fmt.Sprintf("Bogus value for SNAPSHOT_WORKERS: '%s'", value))
+			errs = append(errs, fmt.Errorf("Bogus value for SNAPSHOT_WORKERS: '%s'", value))
 		}
 	}
 
-	value, found = os.LookupEnv("EXCLUDE_TABLES")
+	value, found = source.lookup("LEADER_LEASE_TTL")
+	if found {
+		leaderLeaseTTL, err = time.ParseDuration(value)
+		if err != nil || leaderLeaseTTL <= 0 {
+			errs = append(errs, fmt.Errorf("Bogus value for LEADER_LEASE_TTL: '%s'", value))
+		}
+	}
+
+	value, found = source.lookup("SHUTDOWN_TIMEOUT")
+	if found {
+		shutdownTimeout, err = time.ParseDuration(value)
+		if err != nil || shutdownTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("Bogus value for SHUTDOWN_TIMEOUT: '%s'", value))
+		}
+	}
+
+	value, found = source.lookup("EXCLUDE_TABLES")
 	if found {
 		excludeTables = strings.Split(value, ",")
 	}
 
-	value, found = os.LookupEnv("DATADOG_HOST")
+	value, found = source.lookup("METRICS_BACKEND")
+	if found {
+		metricsBackend = value
+		if !StringInList(value, METRICS_BACKENDS) {
+			errs = append(errs, fmt.Errorf("Bogus value for METRICS_BACKEND: '%s' (expected one of %s)", value, strings.Join(METRICS_BACKENDS, ", ")))
+		}
+	}
+	value, found = source.lookup("HTTP_ADDRESS")
+	if found {
+		httpAddress = value
+	}
+	value, found = source.lookup("DATADOG_HOST")
 	if found {
 		datadogHost = value
 	}
-	value, found = os.LookupEnv("DATADOG_PORT")
+	value, found = source.lookup("DATADOG_PORT")
 	if found {
 		datadogPort = value
 	}
-	value, found = os.LookupEnv("BUGSNAG_RELEASE_STAGE")
+	value, found = source.lookup("LOG_LEVEL")
+	if found {
+		logLevel = value
+		if _, err := parseLogLevel(value); err != nil {
+			errs = append(errs, fmt.Errorf("Bogus value for LOG_LEVEL: '%s'", value))
+		}
+	}
+	value, found = source.lookup("LOG_FORMAT")
+	if found {
+		logFormat = value
+		if !StringInList(value, LOG_FORMATS) {
+			errs = append(errs, fmt.Errorf("Bogus value for LOG_FORMAT: '%s' (expected one of %s)", value, strings.Join(LOG_FORMATS, ", ")))
+		}
+	}
+	value, found = source.lookup("LOG_LEVELS")
+	if found && value != "" {
+		for _, override := range strings.Split(value, ",") {
+			component, level, _ := strings.Cut(override, "=")
+			if !StringInList(component, LOG_COMPONENTS) {
+				errs = append(errs, fmt.Errorf("Unknown component in LOG_LEVELS: '%s' (expected one of %s)", component, strings.Join(LOG_COMPONENTS, ", ")))
+			} else if _, err := parseLogLevel(level); err != nil {
+				errs = append(errs, fmt.Errorf("Bogus level in LOG_LEVELS for '%s': '%s'", component, level))
+			}
+			logLevels[component] = level
+		}
+	}
+	value, found = source.lookup("DEAD_LETTER_SINK")
+	if found {
+		deadLetterSink = value
+		if !StringInList(value, DEAD_LETTER_SINKS) {
+			errs = append(errs, fmt.Errorf("Bogus value for DEAD_LETTER_SINK: '%s' (expected one of %s)", value, strings.Join(DEAD_LETTER_SINKS, ", ")))
+		}
+	}
+	value, found = source.lookup("DEAD_LETTER_PATH")
+	if found {
+		deadLetterPath = value
+	}
+	value, found = source.lookup("DEAD_LETTER_BUDGET")
+	if found {
+		deadLetterBudget, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || deadLetterBudget < 0 {
+			errs = append(errs, fmt.Errorf("Bogus value for DEAD_LETTER_BUDGET: '%s'", value))
+		}
+	}
+	value, found = source.lookup("SINK_WRITE_TIMEOUT")
+	if found {
+		sinkWriteTimeout, err = time.ParseDuration(value)
+		if err != nil || sinkWriteTimeout <= 0 {
+			errs = append(errs, fmt.Errorf("Bogus value for SINK_WRITE_TIMEOUT: '%s'", value))
+		}
+	}
+	value, found = source.lookup("SINK_WRITE_TIMEOUTS")
+	if found && value != "" {
+		for _, override := range strings.Split(value, ",") {
+			sink, timeoutString, _ := strings.Cut(override, "=")
+			timeout, err := time.ParseDuration(timeoutString)
+			if err != nil || timeout <= 0 {
+				errs = append(errs, fmt.Errorf("Bogus timeout in SINK_WRITE_TIMEOUTS for '%s': '%s'", sink, timeoutString))
+			}
+			sinkWriteTimeouts[sink] = timeout
+		}
+	}
+	value, found = source.lookup("SINK_STUCK_POLICY")
+	if found {
+		sinkStuckPolicy = value
+		if !StringInList(value, SINK_STUCK_POLICIES) {
+			errs = append(errs, fmt.Errorf("Bogus value for SINK_STUCK_POLICY: '%s' (expected one of %s)", value, strings.Join(SINK_STUCK_POLICIES, ", ")))
+		}
+	}
+	value, found = source.lookup("SINK_BUFFER_SIZE")
+	if found {
+		sinkBufferSize, err = strconv.ParseInt(value, 10, 32)
+		if err != nil || sinkBufferSize <= 0 {
+			errs = append(errs, fmt.Errorf("Bogus value for SINK_BUFFER_SIZE: '%s'", value))
+		}
+	}
+	value, found = source.lookup("OPTIONAL_SINKS")
+	if found && value != "" {
+		optionalSinks = strings.Split(value, ",")
+	}
+	value, found = source.lookup("BUGSNAG_RELEASE_STAGE")
 	if found {
 		bugsnagReleaseStage = value
 	}
 
 	c := Config{
-		MysqlHost: os.Getenv("MYSQL_HOST"),
-		MysqlDatabase: os.Getenv("MYSQL_DATABASE"),
-		MysqlUser: os.Getenv("MYSQL_USER"),
-		MysqlPassword: os.Getenv("MYSQL_PASSWORD"),
+		MysqlHost: source.get("MYSQL_HOST"),
+		MysqlDatabase: source.get("MYSQL_DATABASE"),
+		MysqlUser: source.get("MYSQL_USER"),
+		MysqlPassword: source.get("MYSQL_PASSWORD"),
 		MysqlPort: mysqlPort,
 		MaxMysqlConns: int(maxMysqlConns),
 
-		RedisHost: os.Getenv("REDIS_HOST"),
-		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		RedisHost: source.get("REDIS_HOST"),
+		RedisPassword: source.get("REDIS_PASSWORD"),
 		RedisPort: redisPort,
 
-		S3Path: os.Getenv("S3_PATH"),
+		S3Path: source.get("S3_PATH"),
 
 		ExcludeTables: excludeTables,
+		Tables: file.Tables,
+		Sinks: file.Sinks,
 
 		SnapshotChunkSize: uint64(snapshotChunkSize),
 		SnapshotWorkers: snapshotWorkers,
 
+		LeaderLeaseTTL: leaderLeaseTTL,
+		ShutdownTimeout: shutdownTimeout,
+
+		MetricsBackend: metricsBackend,
 		DatadogHost: datadogHost,
 		DatadogPort: datadogPort,
+		HttpAddress: httpAddress,
+		AdminToken: source.get("ADMIN_TOKEN"),
+
+		LogLevel: logLevel,
+		LogFormat: logFormat,
+		LogLevels: logLevels,
+
+		DeadLetterSink: deadLetterSink,
+		DeadLetterPath: deadLetterPath,
+		DeadLetterBudget: deadLetterBudget,
+
+		SinkWriteTimeout: sinkWriteTimeout,
+		SinkWriteTimeouts: sinkWriteTimeouts,
+		SinkStuckPolicy: sinkStuckPolicy,
+		SinkBufferSize: sinkBufferSize,
+		OptionalSinks: optionalSinks,
 
-		BugsnagApiKey: os.Getenv("BUGSNAG_API_KEY"),
+		BugsnagApiKey: source.get("BUGSNAG_API_KEY"),
 		BugsnagReleaseStage: bugsnagReleaseStage,
-		ClioRegion: os.Getenv("CLIO_REGION"),
+		ClioRegion: source.get("CLIO_REGION"),
 
 		SyntheticColumns: []string{},
 		SyntheticColumnTypes: map[string]string{},
 		SyntheticColumnNames: "",
 		SyntheticColumnValues: "",
+		SyntheticColumnDefs: []SyntheticColumn{},
+
+		errors: errs,
 	}
 
-	value, found = os.LookupEnv("SYNTHETIC_COLUMNS")
+	value, found = source.lookup("SYNTHETIC_COLUMNS")
 	if found {
 		c.parseSyntheticColumns(value)
 	}
@@ -158,8 +495,8 @@ func NewConfig() Config {
 //   SYNTHETIC_COLUMNS="foo,int,1;bar,char(4),'honk'"
 // into a Go data structure like:
 //   map[string]string{"foo": "int", "bar": "char(4)"}
-// and the strings "foo, bar" and "1, 'honk'". The data type will be used to determine the MySQL schema, and the
-// strings will get injected into all of our Parquet output.
+// and the strings "foo, bar" and "1, 'honk'". We also build a SyntheticColumn for each one, whose type and value
+// get injected into every table schema and row that the sinks receive.
 func (c *Config) parseSyntheticColumns(raw string) {
 	columns := strings.Split(raw, ";")
 	c.SyntheticColumnTypes = make(map[string]string, len(columns))
@@ -168,7 +505,8 @@ func (c *Config) parseSyntheticColumns(raw string) {
 	for i, column := range columns {
 		parts := strings.SplitN(column, ",", 3)
 		if len(parts) < 3 {
-			logger.Fatal("Malformed SYNTHETIC_COLUMNS string!")
+			c.errors = append(c.errors, fmt.Errorf("Malformed SYNTHETIC_COLUMNS entry: '%s'", column))
+			continue
 		}
 
 		c.SyntheticColumns = append(c.SyntheticColumns, parts[0])
@@ -179,5 +517,109 @@ func (c *Config) parseSyntheticColumns(raw string) {
 			c.SyntheticColumnNames += ","
 			c.SyntheticColumnValues += ","
 		}
+
+		// Bad types are reported by Validate().
+		if sqlType, _, _, err := tryParseSqlType(parts[1]); err != nil || !StringInList(sqlType, SUPPORTED_SQL_TYPES) {
+			continue
+		}
+		def, err := NewSyntheticColumn(parts[0], parts[1], parts[2])
+		if err != nil {
+			c.errors = append(c.errors, fmt.Errorf("Bad value for synthetic column '%s': %s", parts[0], err))
+			continue
+		}
+		c.SyntheticColumnDefs = append(c.SyntheticColumnDefs, def)
+	}
+}
+
+// Checks the configuration for missing settings, bad values and settings which don't make sense together.
+// Returns all of the problems at once, or nil if there aren't any.
+func (c *Config) Validate() error {
+	errs := append([]error{}, c.errors...)
+
+	required := map[string]string{
+		"MYSQL_HOST": c.MysqlHost,
+		"MYSQL_DATABASE": c.MysqlDatabase,
+		"MYSQL_USER": c.MysqlUser,
+		"REDIS_HOST": c.RedisHost,
+	}
+	for _, name := range CONFIG_SETTINGS {
+		if value, ok := required[name]; ok && value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+
+	if c.MaxMysqlConns > 0 && c.SnapshotWorkers > int64(c.MaxMysqlConns - RESERVED_MYSQL_CONNS) {
+		errs = append(errs, fmt.Errorf(
+			"SNAPSHOT_WORKERS (%d) can't be more than MYSQL_MAX_CONNS (%d) minus the %d connections reserved for metadata queries",
+			c.SnapshotWorkers, c.MaxMysqlConns, RESERVED_MYSQL_CONNS,
+		))
+	}
+
+	// The sink types register themselves when the program starts, so we can't check them until now.
+	for sinkName, sink := range c.Sinks {
+		if _, ok := sinkTypes[sink.Type]; !ok && sink.Type != "" {
+			errs = append(errs, fmt.Errorf("Sink '%s' has unknown type '%s' (expected one of %s)", sinkName, sink.Type, strings.Join(registeredSinkTypes(), ", ")))
+		}
+	}
+	for tableName, table := range c.Tables {
+		for _, sinkName := range table.Sinks {
+			if _, ok := c.Sinks[sinkName]; !ok {
+				errs = append(errs, fmt.Errorf("Table '%s' is routed to sink '%s', which isn't in the config file", tableName, sinkName))
+			}
+		}
+	}
+
+	for _, name := range c.SyntheticColumns {
+		sqlType, _, _, err := tryParseSqlType(c.SyntheticColumnTypes[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Bad type for synthetic column '%s': %s", name, err))
+		} else if !StringInList(sqlType, SUPPORTED_SQL_TYPES) {
+			errs = append(errs, fmt.Errorf("Unsupported type for synthetic column '%s': '%s'", name, sqlType))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns the snapshot chunk size for the given table.
+func (c *Config) ChunkSize(tableName string) uint64 {
+	if table, ok := c.Tables[tableName]; ok && table.ChunkSize > 0 {
+		return table.ChunkSize
+	}
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return c.SnapshotChunkSize
+}
+
+// How long the given sink gets to acknowledge a batch of rows before we decide it's stuck.
+func (c *Config) SinkWriteTimeoutFor(sinkName string) time.Duration {
+	if timeout, ok := c.SinkWriteTimeouts[sinkName]; ok && timeout > 0 {
+		return timeout
+	}
+	return c.SinkWriteTimeout
+}
+
+// Returns the extra WHERE condition for rows of the given table, or "" if there isn't one.
+func (c *Config) RowFilter(tableName string) string {
+	return c.Tables[tableName].RowFilter
+}
+
+// Removes any columns that the config file says we shouldn't export from the schema.
+func (c *Config) FilterColumns(schema *TableSchema) *TableSchema {
+	table, ok := c.Tables[schema.Name]
+	if !ok || (len(table.IncludeColumns) == 0 && len(table.ExcludeColumns) == 0) {
+		return schema
+	}
+
+	filtered := NewTableSchema(schema.Name)
+	for _, column := range schema.Columns {
+		if len(table.IncludeColumns) > 0 && !StringInList(column.Name, table.IncludeColumns) {
+			continue
+		}
+		if StringInList(column.Name, table.ExcludeColumns) {
+			continue
+		}
+		filtered.AddColumn(column)
 	}
+	return &filtered
 }
@@ -0,0 +1,165 @@
+// Sending us SIGHUP re-reads the configuration. Only a few settings can safely change while we're running; the
+// rest need a restart, so we log an explanation and keep using the old values.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var RELOADABLE_SETTINGS = []string{"EXCLUDE_TABLES", "SNAPSHOT_CHUNK_SIZE", "SNAPSHOT_WORKERS"}
+
+// The reloadable settings can change while the workers and the HTTP handlers are reading them, so they're only
+// touched while holding this. Nothing else in the config changes after startup.
+var reloadLock sync.RWMutex
+
+// Copies the reloadable settings from a config returned by ReloadConfig.
+func (c *Config) ApplyReload(newConfig Config) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	c.ExcludeTables = newConfig.ExcludeTables
+	c.SnapshotChunkSize = newConfig.SnapshotChunkSize
+	c.SnapshotWorkers = newConfig.SnapshotWorkers
+}
+
+// A copy that's safe to hand to ReloadConfig and excludedTableChanges.
+func (c *Config) Copy() Config {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return *c
+}
+
+func (c *Config) Workers() int64 {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return c.SnapshotWorkers
+}
+
+func (c *Config) IsExcluded(tableName string) bool {
+	reloadLock.RLock()
+	defer reloadLock.RUnlock()
+	return StringInList(tableName, c.ExcludeTables)
+}
+
+// Reads the configuration again and returns a copy of the current config with any changes to reloadable
+// settings applied. If the new configuration is invalid, this returns the error and the current config.
+func ReloadConfig(current Config) (Config, error) {
+	newConfig := NewConfig()
+	if err := newConfig.Validate(); err != nil {
+		return current, err
+	}
+
+	oldValues := current.settingValues()
+	newValues := newConfig.settingValues()
+	for _, name := range append(CONFIG_SETTINGS, "TABLES", "SINKS") {
+		if StringInList(name, RELOADABLE_SETTINGS) || reflect.DeepEqual(oldValues[name], newValues[name]) {
+			continue
+		}
+		if name == "TABLES" {
+			logger.Warn("The per-table settings in the config file can't be changed without a restart. Ignoring the changes.")
+		} else if name == "SINKS" {
+			logger.Warn("The sinks in the config file can't be changed without a restart. Ignoring the changes.")
+		} else {
+			logger.Warn("This setting can't be changed without a restart. Ignoring the new value.", "setting", name)
+		}
+	}
+
+	reloaded := current
+	reloaded.ExcludeTables = newConfig.ExcludeTables
+	reloaded.SnapshotChunkSize = newConfig.SnapshotChunkSize
+	reloaded.SnapshotWorkers = newConfig.SnapshotWorkers
+
+	// Cross-field checks have to be made against the settings we'll actually be running with.
+	if err := reloaded.Validate(); err != nil {
+		return current, err
+	}
+	return reloaded, nil
+}
+
+// Returns the value of each setting in CONFIG_SETTINGS, plus the per-table settings under "TABLES" and the sinks
+// under "SINKS", so that we can tell which ones have changed.
+func (c *Config) settingValues() map[string]any {
+	return map[string]any{
+		"MYSQL_HOST": c.MysqlHost,
+		"MYSQL_PORT": c.MysqlPort,
+		"MYSQL_DATABASE": c.MysqlDatabase,
+		"MYSQL_USER": c.MysqlUser,
+		"MYSQL_PASSWORD": c.MysqlPassword,
+		"MYSQL_MAX_CONNS": c.MaxMysqlConns,
+		"REDIS_HOST": c.RedisHost,
+		"REDIS_PORT": c.RedisPort,
+		"REDIS_PASSWORD": c.RedisPassword,
+		"S3_PATH": c.S3Path,
+		"EXCLUDE_TABLES": c.ExcludeTables,
+		"SNAPSHOT_CHUNK_SIZE": c.SnapshotChunkSize,
+		"SNAPSHOT_WORKERS": c.SnapshotWorkers,
+		"LEADER_LEASE_TTL": c.LeaderLeaseTTL,
+		"SHUTDOWN_TIMEOUT": c.ShutdownTimeout,
+		"METRICS_BACKEND": c.MetricsBackend,
+		"DATADOG_HOST": c.DatadogHost,
+		"DATADOG_PORT": c.DatadogPort,
+		"HTTP_ADDRESS": c.HttpAddress,
+		"ADMIN_TOKEN": c.AdminToken,
+		"LOG_LEVEL": c.LogLevel,
+		"LOG_FORMAT": c.LogFormat,
+		"LOG_LEVELS": c.LogLevels,
+		"DEAD_LETTER_SINK": c.DeadLetterSink,
+		"DEAD_LETTER_PATH": c.DeadLetterPath,
+		"DEAD_LETTER_BUDGET": c.DeadLetterBudget,
+		"SINK_WRITE_TIMEOUT": c.SinkWriteTimeout,
+		"SINK_WRITE_TIMEOUTS": c.SinkWriteTimeouts,
+		"SINK_STUCK_POLICY": c.SinkStuckPolicy,
+		"SINK_BUFFER_SIZE": c.SinkBufferSize,
+		"OPTIONAL_SINKS": c.OptionalSinks,
+		"BUGSNAG_API_KEY": c.BugsnagApiKey,
+		"BUGSNAG_RELEASE_STAGE": c.BugsnagReleaseStage,
+		"CLIO_REGION": c.ClioRegion,
+		"SYNTHETIC_COLUMNS": []any{c.SyntheticColumnNames, c.SyntheticColumnValues, c.SyntheticColumnTypes},
+		"TABLES": c.Tables,
+		"SINKS": c.Sinks,
+	}
+}
+
+// Returns the tables that are excluded in 'newConfig' but weren't in 'oldConfig', and vice versa.
+func excludedTableChanges(oldConfig, newConfig Config) (newlyExcluded []string, newlyIncluded []string) {
+	for _, table := range newConfig.ExcludeTables {
+		if !StringInList(table, oldConfig.ExcludeTables) {
+			newlyExcluded = append(newlyExcluded, table)
+		}
+	}
+	for _, table := range oldConfig.ExcludeTables {
+		if !StringInList(table, newConfig.ExcludeTables) {
+			newlyIncluded = append(newlyIncluded, table)
+		}
+	}
+	return newlyExcluded, newlyIncluded
+}
+
+// Opens or closes the given table on every sink it's routed to. Returns all of the sinks' errors together.
+func openTableOnSinks(schema *TableSchema) error {
+	errs := []error{}
+	for _, sink := range sinksForTable(schema.Name) {
+		if err := sink.Open(schema); err != nil {
+			errs = append(errs, fmt.Errorf("Can't open table '%s' on %s: %s", schema.Name, sinkName(sink), err))
+		}
+	}
+	if len(errs) == 0 {
+		if err := saveTableSchema(schema); err != nil {
+			errs = append(errs, fmt.Errorf("Can't save the schema of table '%s': %s", schema.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func closeTableOnSinks(schema *TableSchema) error {
+	errs := []error{}
+	for _, sink := range sinksForTable(schema.Name) {
+		if err := sink.Close(schema); err != nil {
+			errs = append(errs, fmt.Errorf("Can't close table '%s' on %s: %s", schema.Name, sinkName(sink), err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfig(t *testing.T) {
+	settings := map[string]string{
+		"MYSQL_HOST": "mysql", "MYSQL_DATABASE": "test_db", "MYSQL_USER": "warehouse", "REDIS_HOST": "redis",
+	}
+	for key, value := range settings {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	current := NewConfig()
+
+	os.Setenv("MYSQL_HOST", "some-other-mysql")
+	os.Setenv("EXCLUDE_TABLES", "foo,bar")
+	os.Setenv("SNAPSHOT_WORKERS", "4")
+	defer os.Unsetenv("EXCLUDE_TABLES")
+	defer os.Unsetenv("SNAPSHOT_WORKERS")
+
+	reloaded, err := ReloadConfig(current)
+	assert.NoError(t, err)
+	assert.Equal(t, "mysql", reloaded.MysqlHost)
+	assert.Equal(t, []string{"foo", "bar"}, reloaded.ExcludeTables)
+	assert.Equal(t, int64(4), reloaded.SnapshotWorkers)
+	assert.Equal(t, []string{}, current.ExcludeTables)
+
+	newlyExcluded, newlyIncluded := excludedTableChanges(reloaded, current)
+	assert.Empty(t, newlyExcluded)
+	assert.Equal(t, []string{"foo", "bar"}, newlyIncluded)
+
+	// The new worker count is checked against the connection limit we're actually running with.
+	os.Setenv("SNAPSHOT_WORKERS", "11")
+	os.Setenv("MYSQL_MAX_CONNS", "20")
+	defer os.Unsetenv("MYSQL_MAX_CONNS")
+	reloaded, err = ReloadConfig(current)
+	assert.ErrorContains(t, err, "SNAPSHOT_WORKERS (11) can't be more than MYSQL_MAX_CONNS (12)")
+	assert.Equal(t, current.SnapshotWorkers, reloaded.SnapshotWorkers)
+
+	os.Setenv("SNAPSHOT_WORKERS", "zero")
+	_, err = ReloadConfig(current)
+	assert.ErrorContains(t, err, "Bogus value for SNAPSHOT_WORKERS")
+}
+
+func TestSettingValuesCoversAllSettings(t *testing.T) {
+	values := config.settingValues()
+	for _, name := range CONFIG_SETTINGS {
+		assert.Contains(t, values, name)
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -24,3 +26,170 @@ func TestParseSyntheticColumns(t *testing.T) {
 	assert.True(t, config.SyntheticColumnNames == "foo,bar")
 	assert.True(t, config.SyntheticColumnValues == "1,'honk, woop'")
 }
+
+func writeConfigFile(t *testing.T, contents string) string {
+	filename := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(filename, []byte(contents), 0644))
+	return filename
+}
+
+func TestConfigFile(t *testing.T) {
+	filename := writeConfigFile(t, `
+mysql_host: db.example.com
+mysql_port: 3307
+snapshot_workers: 4
+snapshot_chunk_size: 5000
+exclude_tables: [secrets, schema_migrations]
+sink_write_timeouts: [CsvSink=30s]
+optional_sinks: [CsvSink]
+tables:
+  users:
+    chunk_size: 100
+    exclude_columns: [password_hash]
+    row_filter: deleted_at IS NULL
+    sinks: [parquet]
+  posts:
+    include_columns: [id, title]
+`)
+	os.Setenv("SNAPSHOT_WORKERS", "7")
+	defer os.Unsetenv("SNAPSHOT_WORKERS")
+
+	WithConfig("CONFIG_FILE", filename, func() {
+		assert.Equal(t, "db.example.com", config.MysqlHost)
+		assert.Equal(t, "3307", config.MysqlPort)
+		assert.Equal(t, int64(7), config.SnapshotWorkers)  // The environment variable wins.
+		assert.Equal(t, []string{"secrets", "schema_migrations"}, config.ExcludeTables)
+
+		assert.Equal(t, uint64(100), config.ChunkSize("users"))
+		assert.Equal(t, uint64(5000), config.ChunkSize("posts"))
+		assert.Equal(t, "deleted_at IS NULL", config.RowFilter("users"))
+		assert.Equal(t, "", config.RowFilter("posts"))
+		assert.Equal(t, []string{"parquet"}, config.Tables["users"].Sinks)
+		assert.Equal(t, 30 * time.Second, config.SinkWriteTimeoutFor("CsvSink"))
+		assert.Equal(t, DEFAULT_SINK_WRITE_TIMEOUT, config.SinkWriteTimeoutFor("FakeSink"))
+		assert.Equal(t, []string{"CsvSink"}, config.OptionalSinks)
+
+		users := &TableSchema{"users", []Column{
+			{"id", "bigint", 20, 0, false, false},
+			{"name", "varchar", 255, 0, true, true},
+			{"password_hash", "varchar", 255, 0, true, true},
+		}}
+		assert.Equal(t, []string{"id", "name"}, columnNames(config.FilterColumns(users)))
+		posts := &TableSchema{"posts", []Column{
+			{"id", "bigint", 20, 0, false, false},
+			{"title", "varchar", 255, 0, true, true},
+			{"body", "text", 0, 0, true, true},
+		}}
+		assert.Equal(t, []string{"id", "title"}, columnNames(config.FilterColumns(posts)))
+	})
+}
+
+func TestConfigFileReportsAllErrors(t *testing.T) {
+	filename := writeConfigFile(t, `
+mysql_hostname: oops
+snapshot_workers: {nested: true}
+tables:
+  users:
+    chunk_size: lots
+    include_columns: [name]
+    exclude_columns: [id]
+    colour: blue
+`)
+	_, err := LoadConfigFile(filename)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown setting 'mysql_hostname'")
+	assert.Contains(t, err.Error(), "'snapshot_workers' must be a string or a list")
+	assert.Contains(t, err.Error(), "cannot unmarshal !!str `lots`")
+	assert.Contains(t, err.Error(), "field colour not found")
+	assert.Contains(t, err.Error(), "can't have both include_columns and exclude_columns")
+	assert.Contains(t, err.Error(), "must include the 'id' column")
+	assert.Contains(t, err.Error(), "can't exclude the 'id' column")
+}
+
+func columnNames(schema *TableSchema) []string {
+	names := []string{}
+	for _, column := range schema.Columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+func TestConfigValidate(t *testing.T) {
+	settings := map[string]string{
+		"MYSQL_HOST": "mysql", "MYSQL_DATABASE": "test_db", "MYSQL_USER": "warehouse", "REDIS_HOST": "redis",
+	}
+	for key, value := range settings {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	c := NewConfig()
+	assert.NoError(t, c.Validate())
+
+	os.Unsetenv("MYSQL_HOST")
+	os.Setenv("MYSQL_MAX_CONNS", "eleven")
+	os.Setenv("SNAPSHOT_CHUNK_SIZE", "-5")
+	os.Setenv("SNAPSHOT_WORKERS", "20")
+	os.Setenv("SYNTHETIC_COLUMNS", "region,varchar(x),'us';tenant,geometry,1;broken")
+	defer os.Unsetenv("MYSQL_MAX_CONNS")
+	defer os.Unsetenv("SNAPSHOT_CHUNK_SIZE")
+	defer os.Unsetenv("SNAPSHOT_WORKERS")
+	defer os.Unsetenv("SYNTHETIC_COLUMNS")
+
+	c = NewConfig()
+	err := c.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "MYSQL_HOST is required")
+	assert.Contains(t, err.Error(), "Bogus value for MYSQL_MAX_CONNS: 'eleven'")
+	assert.Contains(t, err.Error(), "Bogus value for SNAPSHOT_CHUNK_SIZE: '-5'")
+	assert.Contains(t, err.Error(), "Bad type for synthetic column 'region'")
+	assert.Contains(t, err.Error(), "Unsupported type for synthetic column 'tenant': 'geometry'")
+	assert.Contains(t, err.Error(), "Malformed SYNTHETIC_COLUMNS entry: 'broken'")
+
+	os.Setenv("METRICS_BACKEND", "graphite")
+	defer os.Unsetenv("METRICS_BACKEND")
+	c = NewConfig()
+	assert.ErrorContains(t, c.Validate(), "Bogus value for METRICS_BACKEND: 'graphite' (expected one of datadog, prometheus)")
+	os.Unsetenv("METRICS_BACKEND")
+
+	os.Setenv("LOG_LEVEL", "chatty")
+	os.Setenv("LOG_FORMAT", "xml")
+	os.Setenv("LOG_LEVELS", "sink=warn,binlog=debug,state=loud")
+	c = NewConfig()
+	err = c.Validate()
+	assert.ErrorContains(t, err, "Bogus value for LOG_LEVEL: 'chatty'")
+	assert.ErrorContains(t, err, "Bogus value for LOG_FORMAT: 'xml' (expected one of logfmt, json)")
+	assert.ErrorContains(t, err, "Unknown component in LOG_LEVELS: 'binlog'")
+	assert.ErrorContains(t, err, "Bogus level in LOG_LEVELS for 'state': 'loud'")
+	assert.Equal(t, map[string]string{"sink": "warn", "binlog": "debug", "state": "loud"}, c.LogLevels)
+	os.Unsetenv("LOG_LEVEL")
+	os.Unsetenv("LOG_FORMAT")
+	os.Unsetenv("LOG_LEVELS")
+
+	os.Setenv("DEAD_LETTER_SINK", "kafka")
+	os.Setenv("DEAD_LETTER_BUDGET", "-1")
+	c = NewConfig()
+	err = c.Validate()
+	assert.ErrorContains(t, err, "Bogus value for DEAD_LETTER_SINK: 'kafka' (expected one of jsonl, log)")
+	assert.ErrorContains(t, err, "Bogus value for DEAD_LETTER_BUDGET: '-1'")
+	os.Unsetenv("DEAD_LETTER_SINK")
+	os.Unsetenv("DEAD_LETTER_BUDGET")
+
+	os.Setenv("SINK_WRITE_TIMEOUT", "soon")
+	os.Setenv("SINK_WRITE_TIMEOUTS", "CsvSink=10s,KafkaSink=never")
+	os.Setenv("SINK_STUCK_POLICY", "panic")
+	os.Setenv("SINK_BUFFER_SIZE", "0")
+	c = NewConfig()
+	err = c.Validate()
+	assert.ErrorContains(t, err, "Bogus value for SINK_WRITE_TIMEOUT: 'soon'")
+	assert.ErrorContains(t, err, "Bogus timeout in SINK_WRITE_TIMEOUTS for 'KafkaSink': 'never'")
+	assert.ErrorContains(t, err, "Bogus value for SINK_STUCK_POLICY: 'panic' (expected one of retry, requeue, shutdown)")
+	assert.ErrorContains(t, err, "Bogus value for SINK_BUFFER_SIZE: '0'")
+	os.Unsetenv("SINK_WRITE_TIMEOUT")
+	os.Unsetenv("SINK_WRITE_TIMEOUTS")
+	os.Unsetenv("SINK_STUCK_POLICY")
+	os.Unsetenv("SINK_BUFFER_SIZE")
+
+	os.Setenv("MYSQL_MAX_CONNS", "12")
+	c = NewConfig()
+	assert.ErrorContains(t, c.Validate(), "SNAPSHOT_WORKERS (20) can't be more than MYSQL_MAX_CONNS (12) minus the 2 connections reserved")
+}
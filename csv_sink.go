@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var CSV_BINARY_ENCODINGS = []string{"raw", "base64", "hex"}
+var CSV_COMPRESSIONS = []string{"none", "gzip", "zstd"}
+
+func init() {
+	RegisterSinkType("csv", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, append(ROTATING_FILE_PARAMS, "binary", "compression")...); err != nil {
+			return nil, err
+		}
+		settings, err := ParseRotatingFileSettings(params)
+		if err != nil {
+			return nil, err
+		}
+		binary := "raw"
+		if value, ok := params["binary"]; ok {
+			if !StringInList(value, CSV_BINARY_ENCODINGS) {
+				return nil, fmt.Errorf("Bogus value for binary: '%s' (expected raw, base64 or hex)", value)
+			}
+			binary = value
+		}
+		compression := "none"
+		if value, ok := params["compression"]; ok {
+			if !StringInList(value, CSV_COMPRESSIONS) {
+				return nil, fmt.Errorf("Bogus value for compression: '%s' (expected none, gzip or zstd)", value)
+			}
+			compression = value
+		}
+		return NewCsvSink(settings, binary, compression), nil
+	})
+}
+
+// Writes each table to RFC 4180 CSV files, starting with a header row of column names and types. NULL is an empty
+// field and the empty string is `""`, so the two can be told apart. Compressed files are a series of gzip members
+// or zstd frames, one per batch of rows, which the usual tools decompress as if they were one.
+type CsvSink struct {
+	Lock sync.Mutex
+	RotatingFileSettings
+	Binary string       // How binary columns are written: "raw", "base64" or "hex".
+	Compression string  // "none", "gzip" or "zstd".
+	Files map[string]*RotatingFile
+	Schemas map[string]*TableSchema
+	zstd *zstd.Encoder
+	done chan struct{}
+	exitOnce sync.Once
+}
+
+func NewCsvSink(settings RotatingFileSettings, binary, compression string) *CsvSink {
+	sink := &CsvSink{
+		sync.Mutex{}, settings, binary, compression,
+		make(map[string]*RotatingFile), make(map[string]*TableSchema), nil, make(chan struct{}), sync.Once{},
+	}
+	if compression == "zstd" {
+		// Can't fail without options.
+		sink.zstd, _ = zstd.NewWriter(nil)
+	}
+	if settings.MaxAge > 0 {
+		go rotateOldFiles(sinkLogger.With("directory", settings.Directory), settings.MaxAge, &sink.Lock, sink.Files, sink.done)
+	}
+	return sink
+}
+
+func (sink *CsvSink) Open(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	extension := map[string]string{"none": "csv", "gzip": "csv.gz", "zstd": "csv.zst"}[sink.Compression]
+	file := sink.NewFile(ts.Name, extension)
+	file.OnOpen = func(f *os.File) error {
+		_, err := f.Write(sink.compress(csvHeader(sink.Schemas[ts.Name])))
+		return err
+	}
+	sink.Files[ts.Name] = file
+	sink.Schemas[ts.Name] = ts
+	return nil
+}
+
+func (sink *CsvSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[ts.Name]
+	if !ok {
+		panic(fmt.Errorf("Can't close non-existent file for table '%s'!", ts.Name))
+	}
+	delete(sink.Files, ts.Name)
+	delete(sink.Schemas, ts.Name)
+	return file.Finish()
+}
+
+func (sink *CsvSink) WriteRows(rows RowsEvent) {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[rows.Schema.Name]
+	if !ok {
+		panic(fmt.Errorf("Can't find file for table '%s'!", rows.Schema.Name))
+	}
+
+	// Rows never get split across files, so we encode the whole batch before writing any of it.
+	var buf []byte
+	for _, row := range rows.Data {
+		for i, column := range rows.Schema.Columns {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			field, err := sink.csvField(row[i], column)
+			if err != nil {
+				rows.ResponseChan <- err
+				return
+			}
+			buf = field.append(buf)
+		}
+		buf = append(buf, '\r', '\n')
+	}
+	_, err := file.Write(sink.compress(buf))
+	rows.ResponseChan <- err
+}
+
+// The old files have the old header, so we finish them and start over.
+func (sink *CsvSink) SchemaChange(newSchema *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[newSchema.Name]
+	if !ok {
+		return fmt.Errorf("Can't change the schema of table '%s', which was never opened", newSchema.Name)
+	}
+	sink.Schemas[newSchema.Name] = newSchema
+	return file.Finish()
+}
+
+// Safe to call more than once.
+func (sink *CsvSink) Exit() error {
+	sink.exitOnce.Do(func() { close(sink.done) })
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	var firstErr error
+	for _, file := range sink.Files {
+		if err := file.Finish(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Each call makes a complete gzip member or zstd frame, so a file is always valid up to the last batch written.
+func (sink *CsvSink) compress(data []byte) []byte {
+	switch sink.Compression {
+	case "gzip":
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		writer.Write(data)
+		writer.Close()
+		return buf.Bytes()
+	case "zstd":
+		return sink.zstd.EncodeAll(data, nil)
+	default:
+		return data
+	}
+}
+
+type csvField struct {
+	Value string
+	Null bool
+}
+
+// Quotes the field if it has anything in it that RFC 4180 says needs quoting, or if it's an empty string, so that
+// it doesn't look like NULL.
+func (field csvField) append(buf []byte) []byte {
+	if field.Null {
+		return buf
+	}
+	if field.Value != "" && !strings.ContainsAny(field.Value, ",\"\r\n") {
+		return append(buf, field.Value...)
+	}
+	buf = append(buf, '"')
+	buf = append(buf, strings.ReplaceAll(field.Value, `"`, `""`)...)
+	return append(buf, '"')
+}
+
+// The header row has a "name:type" field for each column, like "price:decimal(6,2)" or "id:bigint(20) unsigned".
+func csvHeader(ts *TableSchema) []byte {
+	var buf []byte
+	for i, column := range ts.Columns {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = csvField{column.Name + ":" + csvColumnType(column), false}.append(buf)
+	}
+	return append(buf, '\r', '\n')
+}
+
+func csvColumnType(column Column) string {
+	sqlType := column.SqlType
+	if column.Width > 0 && column.Scale > 0 {
+		sqlType += fmt.Sprintf("(%d,%d)", column.Width, column.Scale)
+	} else if column.Width > 0 {
+		sqlType += fmt.Sprintf("(%d)", column.Width)
+	}
+	if !column.Signed {
+		sqlType += " unsigned"
+	}
+	return sqlType
+}
+
+func (sink *CsvSink) csvField(datum any, column Column) (csvField, error) {
+	if datum == nil {
+		return csvField{"", true}, nil
+	}
+
+	switch datum.(type) {
+	case string:
+		return csvField{datum.(string), false}, nil
+	case []byte:
+		switch sink.Binary {
+		case "base64": return csvField{base64.StdEncoding.EncodeToString(datum.([]byte)), false}, nil
+		case "hex": return csvField{hex.EncodeToString(datum.([]byte)), false}, nil
+		default: return csvField{string(datum.([]byte)), false}, nil
+		}
+
+	case int8:   return csvField{strconv.FormatInt(int64(datum.(int8)), 10), false}, nil
+	case uint8:  return csvField{strconv.FormatUint(uint64(datum.(uint8)), 10), false}, nil
+	case int16:  return csvField{strconv.FormatInt(int64(datum.(int16)), 10), false}, nil
+	case uint16: return csvField{strconv.FormatUint(uint64(datum.(uint16)), 10), false}, nil
+	case int32:  return csvField{strconv.FormatInt(int64(datum.(int32)), 10), false}, nil
+	case uint32: return csvField{strconv.FormatUint(uint64(datum.(uint32)), 10), false}, nil
+	case int64:  return csvField{strconv.FormatInt(datum.(int64), 10), false}, nil
+	case uint64: return csvField{strconv.FormatUint(datum.(uint64), 10), false}, nil
+
+	// The shortest strings that parse back to exactly the same floats.
+	case float32: return csvField{strconv.FormatFloat(float64(datum.(float32)), 'g', -1, 32), false}, nil
+	case float64: return csvField{strconv.FormatFloat(datum.(float64), 'g', -1, 64), false}, nil
+
+	case *big.Rat:
+		return csvField{datum.(*big.Rat).FloatString(column.Scale), false}, nil
+
+	case time.Time:
+		t := datum.(time.Time)
+		switch column.SqlType {
+		case "date": return csvField{t.Format("2006-01-02"), false}, nil
+		case "time": return csvField{t.Format("15:04:05.999999"), false}, nil
+		case "datetime", "timestamp": return csvField{t.UTC().Format("2006-01-02 15:04:05.999999"), false}, nil
+		}
+	}
+	return csvField{}, fmt.Errorf("Unexpected type %v for CSV column '%s' (%s)", reflect.TypeOf(datum), column.Name, column.SqlType)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+// Reads every file matching the pattern, decompressing it if it needs to be.
+func readCsvFiles(t *testing.T, pattern string) []string {
+	paths, err := filepath.Glob(pattern)
+	assert.NoError(t, err)
+	contents := []string{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var reader io.Reader = file
+		if strings.HasSuffix(path, ".gz") {
+			reader, err = gzip.NewReader(file)
+			assert.NoError(t, err)
+		} else if strings.HasSuffix(path, ".zst") {
+			decoder, err := zstd.NewReader(file)
+			assert.NoError(t, err)
+			defer decoder.Close()
+			reader = decoder
+		}
+		data, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		contents = append(contents, string(data))
+		file.Close()
+	}
+	return contents
+}
+
+func TestCsvSink(t *testing.T) {
+	responseChan := make(chan error, 1)
+	schemaFiles := []string{"all_date_types.sql", "all_number_types.sql", "all_string_types.sql"}
+	schemas := make([]*TableSchema, len(schemaFiles))
+	for i, schemaFile := range schemaFiles {
+		schemas[i] = ParseSchema(MustReadFile("test_schemas/" + schemaFile))
+	}
+
+	sink := NewTestCsvSink(t)
+	for _, schema := range schemas {
+		assert.NoError(t, sink.Open(schema))
+	}
+
+	bloom, _   := time.Parse("2006-01-02T15:04:05Z", "1904-06-16T11:34:56Z")
+	phoenix, _ := time.Parse("2006-01-02T15:04:05Z", "2063-04-04T20:10:31Z")
+	pele, _    := time.Parse("2006-01-02T15:04:05Z", "1996-01-22T23:45:06Z")
+	ocean, _   := time.Parse("2006-01-02T15:04:05Z", "2021-10-29T06:05:22Z")
+	early, _   := time.Parse("15:04:05", "05:55:32")
+	late, _    := time.Parse("15:04:05", "22:30:48")
+	dateRows := RowsEvent{responseChan, schemas[0], [][]any{{
+		uint64(1), time.Date(1973, 8, 30, 0, 0, 0, 0, time.UTC), time.Date(1996, 1, 22, 0, 0, 0, 0, time.UTC), early, late, bloom, phoenix, pele, ocean,
+	}}, RowsSource{}}
+	expectedDates := "id:bigint(20) unsigned,date_o:date,date_r:date,time_o:time,time_r:time,datetime_o:datetime,datetime_r:datetime,timestamp_o:timestamp,timestamp_r:timestamp\r\n" +
+		"1,1973-08-30,1996-01-22,05:55:32,22:30:48,1904-06-16 11:34:56,2063-04-04 20:10:31,1996-01-22 23:45:06,2021-10-29 06:05:22\r\n"
+
+	numberRows := RowsEvent{responseChan, schemas[1], [][]any{{
+		uint64(1), int8(-1), int8(-120), uint8(0), uint8(120), int16(500), int16(-500), uint16(30000), uint16(60000), int32(-8000000), int32(8000000), uint32(500), uint32(10000000), int32(-2000000000), int32(2000000000), uint32(3000000000), uint32(4000000000), int64(-900000000000000000), int64(900000000000000000), uint64(31337), uint64(1000000000000000000), float32(0.1), float32(313.37), float64(3.1337), float64(0.0),
+		nil, big.NewRat(-1, 8), nil, big.NewRat(123456789, 1000), nil, big.NewRat(1, 1),
+	}}, RowsSource{}}
+	expectedNumbers := "1,-1,-120,0,120,500,-500,30000,60000,-8000000,8000000,500,10000000,-2000000000,2000000000,3000000000,4000000000,-900000000000000000,900000000000000000,31337,1000000000000000000,0.1,313.37,3.1337,0,,-0.125,,123456.789000,,1.000000000000\r\n"
+
+	stringRows := RowsEvent{responseChan, schemas[2], [][]any{{
+		uint64(1), "woop", "bloop", `I like "pie"`, `wh"eeee`, "this has,a comma", "this,has two,commas",
+		"honk", "bonk", "", "...", "a", "b", "c", "d", "e", "f", "g", "h", "i", "j",
+	}, {
+		uint64(2), nil, "", "two\nlines", nil, "x", "y", "z", "w", nil, "v",
+		nil, []byte{0, '"', ','}, nil, []byte("hi"), nil, []byte{}, nil, []byte("m"), nil, []byte("l"),
+	}}, RowsSource{}}
+	expectedStrings := `1,woop,bloop,"I like ""pie""","wh""eeee","this has,a comma","this,has two,commas",honk,bonk,"",...,a,b,c,d,e,f,g,h,i,j` + "\r\n" +
+		`2,,"","two` + "\n" + `lines",,x,y,z,w,,v,,"` + "\x00" + `"",",,hi,,"",,m,,l` + "\r\n"
+
+	sink.WriteRows(dateRows)
+	assert.NoError(t, <-responseChan)
+	sink.WriteRows(numberRows)
+	assert.NoError(t, <-responseChan)
+	sink.WriteRows(stringRows)
+	assert.NoError(t, <-responseChan)
+	assert.NoError(t, sink.Exit())
+
+	assert.Equal(t, []string{expectedDates}, readCsvFiles(t, filepath.Join(sink.Directory, "all_date_types.*.csv")))
+	numbers := readCsvFiles(t, filepath.Join(sink.Directory, "all_number_types.*.csv"))
+	if assert.Len(t, numbers, 1) {
+		assert.Contains(t, numbers[0], `,float_r:float,double_o:double,double_r:double,"smalldecimal_o:decimal(6,3)",`)
+		assert.True(t, strings.HasSuffix(numbers[0], "\r\n" + expectedNumbers))
+	}
+	strs := readCsvFiles(t, filepath.Join(sink.Directory, "all_string_types.*.csv"))
+	if assert.Len(t, strs, 1) {
+		assert.Contains(t, strs[0], ",varchar_o:varchar(12),")
+		assert.True(t, strings.HasSuffix(strs[0], "\r\n" + expectedStrings))
+	}
+}
+
+func TestCsvSinkBinaryEncodings(t *testing.T) {
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`data` varbinary(10)\n)")
+	for binary, expected := range map[string]string{"hex": "00ff2c", "base64": "AP8s"} {
+		sink := NewCsvSink(RotatingFileSettings{t.TempDir(), 0, 0, nil}, binary, "none")
+		assert.NoError(t, sink.Open(schema))
+		rows := RowsEvent{make(chan error, 1), schema, [][]any{{uint64(1), []byte{0, 0xff, ','}}}, RowsSource{}}
+		sink.WriteRows(rows)
+		assert.NoError(t, <-rows.ResponseChan)
+		assert.NoError(t, sink.Exit())
+		assert.Equal(t, []string{"id:bigint unsigned,data:varbinary(10)\r\n1," + expected + "\r\n"},
+			readCsvFiles(t, filepath.Join(sink.Directory, "foo.*.csv")), binary)
+	}
+}
+
+func TestCsvSinkCompression(t *testing.T) {
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`name` text,\n`x` double\n)")
+	tenth, fifth := 0.1, 0.2
+	for compression, extension := range map[string]string{"gzip": "csv.gz", "zstd": "csv.zst"} {
+		sink := NewCsvSink(RotatingFileSettings{t.TempDir(), 0, 0, nil}, "raw", compression)
+		assert.NoError(t, sink.Open(schema))
+		for _, row := range [][]any{{uint64(1), "honk,\n\"bonk\"", tenth + fifth}, {uint64(2), nil, 1e300}} {
+			rows := RowsEvent{make(chan error, 1), schema, [][]any{row}, RowsSource{}}
+			sink.WriteRows(rows)
+			assert.NoError(t, <-rows.ResponseChan)
+		}
+		assert.NoError(t, sink.Exit())
+
+		// The batches are compressed separately, but they read back as one file that any CSV parser understands.
+		contents := readCsvFiles(t, filepath.Join(sink.Directory, "foo.*." + extension))
+		if !assert.Len(t, contents, 1, compression) {
+			continue
+		}
+		records, err := csv.NewReader(strings.NewReader(contents[0])).ReadAll()
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"id:bigint unsigned", "name:text", "x:double"},
+			{"1", "honk,\n\"bonk\"", "0.30000000000000004"},
+			{"2", "", "1e+300"},
+		}, records, compression)
+	}
+}
+
+func TestCsvSinkRotation(t *testing.T) {
+	uploadDir := t.TempDir()
+	sink := NewCsvSink(RotatingFileSettings{t.TempDir(), 1, 0, NewLocalUploader(uploadDir)}, "raw", "gzip")
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL\n)")
+	assert.NoError(t, sink.Open(schema))
+	write := func(data ...[]any) {
+		rows := RowsEvent{make(chan error, 1), sink.Schemas["foo"], data, RowsSource{}}
+		sink.WriteRows(rows)
+		assert.NoError(t, <-rows.ResponseChan)
+	}
+
+	// Every write makes the file too big, so each batch ends up in its own file, with its own header.
+	write([]any{uint64(1)})
+	assert.NoError(t, sink.SchemaChange(ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`name` varchar(10)\n)")))
+	write([]any{uint64(2), "honk"})
+	assert.NoError(t, sink.Exit())
+
+	local, _ := filepath.Glob(filepath.Join(sink.Directory, "*"))
+	assert.Empty(t, local)
+	assert.Equal(t, []string{
+		"id:bigint unsigned\r\n1\r\n",
+		"id:bigint unsigned,name:varchar(10)\r\n2,honk\r\n",
+	}, readCsvFiles(t, filepath.Join(uploadDir, "foo", "foo.*.csv.gz")))
+}
+
+func TestCsvSinkErrors(t *testing.T) {
+	sink := NewTestCsvSink(t)
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL\n)")
+	assert.NoError(t, sink.Open(schema))
+	rows := RowsEvent{make(chan error, 1), schema, [][]any{{true}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.ErrorContains(t, <-rows.ResponseChan, "Unexpected type bool for CSV column 'id' (bigint)")
+	bar := ParseSchema("CREATE TABLE `bar` (\n`id` bigint unsigned NOT NULL\n)")
+	assert.EqualError(t, sink.SchemaChange(bar), "Can't change the schema of table 'bar', which was never opened")
+	assert.NoError(t, sink.Exit())
+	assert.NoError(t, sink.Exit())  // Exiting twice is fine.
+}
+
+func TestCsvSinkParams(t *testing.T) {
+	_, err := sinkTypes["csv"](map[string]string{})
+	assert.ErrorContains(t, err, "Missing parameter: directory")
+	_, err = sinkTypes["csv"](map[string]string{"directory": "/tmp", "binary": "octal"})
+	assert.ErrorContains(t, err, "Bogus value for binary: 'octal'")
+	_, err = sinkTypes["csv"](map[string]string{"directory": "/tmp", "compression": "bzip2"})
+	assert.ErrorContains(t, err, "Bogus value for compression: 'bzip2'")
+
+	sink, err := sinkTypes["csv"](map[string]string{"directory": "/tmp", "compression": "zstd", "max_age": "0s"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "raw", sink.(*CsvSink).Binary)
+	assert.Equal(t, "zstd", sink.(*CsvSink).Compression)
+	assert.NoError(t, sink.Exit())
+}
+
+// Makes sure that what we write is what the standard library's RFC 4180 parser reads.
+func TestCsvFieldQuoting(t *testing.T) {
+	values := []string{"plain", "", " spaced ", "com,ma", `"quoted"`, "new\nline", "carriage\rreturn", "\x00"}
+	var buf []byte
+	for i, value := range values {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = csvField{value, false}.append(buf)
+	}
+	records, err := csv.NewReader(bytes.NewReader(append(buf, '\r', '\n'))).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{values}, records)
+}
@@ -0,0 +1,132 @@
+// Rows that we can't convert from MySQL's representation get quarantined in a dead-letter sink instead of stopping
+// the snapshot. Someone can look at them later and fix them up by hand. If too many rows fail, though, something is
+// badly wrong (like a type we don't understand), and we give up instead of dead-lettering the whole database.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_DEAD_LETTER_SINK = "jsonl"
+const DEFAULT_DEAD_LETTER_PATH = "dead_letters.jsonl"
+const DEFAULT_DEAD_LETTER_BUDGET = 100
+
+var DEAD_LETTER_SINKS = []string{"jsonl", "log"}
+
+type DeadLetter struct {
+	Time time.Time `json:"time"`
+	Table string `json:"table"`
+	PrimaryKey any `json:"primary_key"`
+	Column string `json:"column"`
+	Raw []byte `json:"raw"`  // Base64 in the JSON, since it can be any old binary garbage.
+	Error string `json:"error"`
+}
+
+type DeadLetterSink interface {
+	Write(letter DeadLetter) error
+	Close() error
+}
+
+// Appends one JSON document per line to a local file.
+type JsonlDeadLetterSink struct {
+	lock sync.Mutex
+	file *os.File
+	encoder *json.Encoder
+}
+
+func NewJsonlDeadLetterSink(path string) (*JsonlDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open dead-letter file: %w", err)
+	}
+	return &JsonlDeadLetterSink{sync.Mutex{}, file, json.NewEncoder(file)}, nil
+}
+
+func (sink *JsonlDeadLetterSink) Write(letter DeadLetter) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return sink.encoder.Encode(letter)
+}
+
+func (sink *JsonlDeadLetterSink) Close() error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return sink.file.Close()
+}
+
+// Just logs them. Handy when there's no writable disk to speak of.
+type LogDeadLetterSink struct{}
+
+func (sink LogDeadLetterSink) Write(letter DeadLetter) error {
+	snapshotLogger.Warn("Dead-lettered a row.", "table", letter.Table, "primary_key", letter.PrimaryKey,
+		"column", letter.Column, "raw", letter.Raw, "error", letter.Error)
+	return nil
+}
+
+func (sink LogDeadLetterSink) Close() error {
+	return nil
+}
+
+// Counts the dead letters as they go by, and fails once there are more than DEAD_LETTER_BUDGET of them.
+type DeadLetterQueue struct {
+	Sink DeadLetterSink
+	count atomic.Int64
+}
+
+func NewDeadLetterQueue() (*DeadLetterQueue, error) {
+	if config.DeadLetterSink == "log" {
+		return NewCustomDeadLetterQueue(LogDeadLetterSink{}), nil
+	}
+	sink, err := NewJsonlDeadLetterSink(config.DeadLetterPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewCustomDeadLetterQueue(sink), nil
+}
+
+func NewCustomDeadLetterQueue(sink DeadLetterSink) *DeadLetterQueue {
+	return &DeadLetterQueue{Sink: sink}
+}
+
+// Writes a dead letter. Returns an error if we couldn't write it or if we're over budget, either of which should
+// stop the snapshot.
+func (q *DeadLetterQueue) Add(letter DeadLetter) error {
+	count := q.count.Add(1)
+	metrics.Count("snapshot.dead_letters", 1, tableTag(letter.Table))
+	if err := q.Sink.Write(letter); err != nil {
+		return fmt.Errorf("Can't write a dead letter: %w", err)
+	}
+	if count > config.DeadLetterBudget {
+		return fmt.Errorf("%d rows have failed to convert, which is more than DEAD_LETTER_BUDGET (%d)", count, config.DeadLetterBudget)
+	}
+	return nil
+}
+
+// The number of rows that have been dead-lettered since we started.
+func (q *DeadLetterQueue) Count() int64 {
+	return q.count.Load()
+}
+
+func (q *DeadLetterQueue) Close() error {
+	return q.Sink.Close()
+}
+
+// MySQL hands us []byte for most things, but the driver decodes a few types on its own.
+func rawMysqlBytes(value any) []byte {
+	switch value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return value.([]byte)
+	case string:
+		return []byte(value.(string))
+	default:
+		return []byte(fmt.Sprint(value))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type FakeDeadLetterSink struct {
+	Lock sync.Mutex
+	Letters []DeadLetter
+}
+
+func (sink *FakeDeadLetterSink) Write(letter DeadLetter) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Letters = append(sink.Letters, letter)
+	return nil
+}
+
+func (sink *FakeDeadLetterSink) Close() error {
+	return nil
+}
+
+// Collects the dead letters in memory instead, with the given budget.
+func WithDeadLetters(budget int64, fn func(letters *[]DeadLetter)) {
+	sink := &FakeDeadLetterSink{}
+	oldDeadLetters := deadLetters
+	deadLetters = NewCustomDeadLetterQueue(sink)
+	defer func() { deadLetters = oldDeadLetters }()
+
+	WithConfig("DEAD_LETTER_BUDGET", strconv.FormatInt(budget, 10), func() {
+		fn(&sink.Letters)
+	})
+}
+
+func TestJsonlDeadLetterSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	sink, err := NewJsonlDeadLetterSink(path)
+	assert.NoError(t, err)
+	letter := DeadLetter{time.Date(2024, 1, 2, 3, 4, 5, 0, UTC), "foo", "17", "price", []byte("1.2.3"), "no good"}
+	assert.NoError(t, sink.Write(letter))
+	assert.NoError(t, sink.Write(letter))
+	assert.NoError(t, sink.Close())
+
+	contents, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := ParseJsonLogs(t, bytes.NewBuffer(contents))
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "foo", lines[0]["table"])
+	assert.Equal(t, "17", lines[0]["primary_key"])
+	assert.Equal(t, "price", lines[0]["column"])
+	assert.Equal(t, "MS4yLjM=", lines[0]["raw"])
+	assert.Equal(t, "no good", lines[0]["error"])
+	assert.Equal(t, "2024-01-02T03:04:05Z", lines[0]["time"])
+
+	var decoded DeadLetter
+	assert.NoError(t, json.Unmarshal(contents[:len(contents) / 2], &decoded))
+	assert.Equal(t, []byte("1.2.3"), decoded.Raw)
+}
+
+func TestRowsEventSkipsBadRows(t *testing.T) {
+	schema := &TableSchema{"foo", []Column{
+		{Name: "id", SqlType: "bigint", Width: 20},
+		{Name: "price", SqlType: "decimal", Width: 10, Scale: 2, Nullable: true},
+	}}
+	result := &FakeMysqlResponse{false, 1, []string{"id", "price"}, [][]any{
+		{uint64(1), []byte("1.50")},
+		{uint64(2), []byte("1.2.3")},
+		{uint64(3), nil},
+		{uint64(4), []byte("bogus")},
+	}}
+
+	WithDeadLetters(2, func(letters *[]DeadLetter) {
+		event, err := rowsEventFromMysqlResult(schema, result)
+		assert.NoError(t, err)
+		assert.Len(t, event.Data, 2)
+		assert.Equal(t, uint64(1), event.Data[0][0])
+		assert.Equal(t, uint64(3), event.Data[1][0])
+
+		assert.Len(t, *letters, 2)
+		letter := (*letters)[0]
+		assert.Equal(t, "foo", letter.Table)
+		assert.Equal(t, uint64(2), letter.PrimaryKey)
+		assert.Equal(t, "price", letter.Column)
+		assert.Equal(t, []byte("1.2.3"), letter.Raw)
+		assert.Contains(t, letter.Error, "Can't convert string '1.2.3' to decimal")
+		assert.Equal(t, int64(2), deadLetters.Count())
+
+		// One more puts us over budget.
+		_, err = rowsEventFromMysqlResult(schema, result)
+		assert.ErrorContains(t, err, "3 rows have failed to convert, which is more than DEAD_LETTER_BUDGET (2)")
+		assert.Len(t, *letters, 3)
+	})
+}
@@ -0,0 +1,128 @@
+// Rows in the same envelope Debezium uses for MySQL change events, so that consumers which already understand
+// Debezium can read our output. Values are encoded the way Debezium encodes them by default, with
+// decimal.handling.mode=precise, binary.handling.mode=bytes and time.precision.mode=adaptive_time_microseconds.
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+)
+
+type DebeziumEnvelope struct {
+	Before map[string]any `json:"before"`
+	After map[string]any `json:"after"`
+	Source DebeziumSource `json:"source"`
+	Op string `json:"op"`
+	TsMs int64 `json:"ts_ms"`
+}
+
+type DebeziumSource struct {
+	Connector string `json:"connector"`
+	Name string `json:"name"`
+	TsMs int64 `json:"ts_ms"`
+	Snapshot string `json:"snapshot"`
+	Db string `json:"db"`
+	Table string `json:"table"`
+	Gtid *string `json:"gtid"`
+	File string `json:"file"`
+	Pos int64 `json:"pos"`
+}
+
+// Wraps each row in an envelope.
+func debeziumEnvelopes(rows RowsEvent, now time.Time) ([]DebeziumEnvelope, error) {
+	source := DebeziumSource{
+		Connector: "mysql",
+		Name: config.MysqlHost,
+		TsMs: now.UnixMilli(),
+		Snapshot: "false",
+		Db: config.MysqlDatabase,
+		Table: rows.Schema.Name,
+		File: rows.Source.Binlog.File,
+		Pos: rows.Source.Binlog.Pos,
+	}
+	if rows.Source.Binlog.GtidSet != "" {
+		source.Gtid = &rows.Source.Binlog.GtidSet
+	}
+	op := "c"
+	if rows.Source.Snapshot {
+		source.Snapshot = "true"
+		op = "r"
+	}
+	// FIXME: Updates and deletes, once we're streaming the binlog.
+
+	envelopes := make([]DebeziumEnvelope, len(rows.Data))
+	for i, row := range rows.Data {
+		after, err := debeziumRow(rows.Schema, row)
+		if err != nil {
+			return nil, err
+		}
+		envelopes[i] = DebeziumEnvelope{nil, after, source, op, now.UnixMilli()}
+	}
+	return envelopes, nil
+}
+
+func debeziumRow(schema *TableSchema, row []any) (map[string]any, error) {
+	values := make(map[string]any, len(schema.Columns))
+	for i, column := range schema.Columns {
+		value, err := debeziumValue(row[i], column)
+		if err != nil {
+			return nil, err
+		}
+		values[column.Name] = value
+	}
+	return values, nil
+}
+
+// Converts a value to the JSON type that Debezium would use for it. Strings, numbers and nulls are fine as they are.
+func debeziumValue(datum any, column Column) (any, error) {
+	switch datum.(type) {
+	case *big.Rat:
+		bytes, err := decimalBytes(datum.(*big.Rat), column)
+		if err != nil {
+			return nil, fmt.Errorf("Can't convert column '%s': %w", column.Name, err)
+		}
+		return bytes, nil
+
+	case time.Time:
+		t := datum.(time.Time)
+		switch column.SqlType {
+		case "date":
+			return EpochDays(t), nil  // io.debezium.time.Date
+		case "time":
+			midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			return t.Sub(midnight).Microseconds(), nil  // io.debezium.time.MicroTime
+		case "timestamp":
+			return t.UTC().Format(time.RFC3339Nano), nil  // io.debezium.time.ZonedTimestamp
+		default:
+			if column.Width > 3 {
+				return t.UnixMicro(), nil  // io.debezium.time.MicroTimestamp
+			}
+			return t.UnixMilli(), nil  // io.debezium.time.Timestamp
+		}
+	}
+	return datum, nil  // []byte turns into base64 when it's marshalled, which is what Debezium does too.
+}
+
+// Debezium's "precise" decimals (and Avro's decimals) are the unscaled value as big-endian two's complement bytes,
+// which end up base64-encoded in JSON. (The same as Java's BigInteger.toByteArray().)
+func decimalBytes(value *big.Rat, column Column) ([]byte, error) {
+	unscaled, err := UnscaledDecimal(value, column)
+	if err != nil {
+		return nil, err
+	}
+	return twosComplementBytes(unscaled), nil
+}
+
+func twosComplementBytes(n *big.Int) []byte {
+	// The smallest length that leaves room for the sign bit.
+	if n.Sign() >= 0 {
+		length := n.BitLen() / 8 + 1
+		return append(make([]byte, length - len(n.Bytes())), n.Bytes()...)
+	}
+	// Negative numbers wrap around: add 2^(8 * length) to them.
+	length := new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).BitLen() / 8 + 1
+	wrapped := new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8 * length)))
+	return append(make([]byte, length - len(wrapped.Bytes())), wrapped.Bytes()...)
+}
@@ -0,0 +1,84 @@
+// Errors that stop the snapshot carry enough context to figure out what went wrong, and get reported to Bugsnag
+// with that context as metadata.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	"github.com/bugsnag/bugsnag-go"
+)
+
+// An error from snapshotting a chunk of a table. Any of the context fields can be empty.
+type SnapshotError struct {
+	Err error
+	Table string
+	Interval string
+	Sql string
+	Sink string
+}
+
+func (e *SnapshotError) Error() string {
+	context := []string{}
+	if e.Table != "" {
+		context = append(context, fmt.Sprintf("table '%s'", e.Table))
+	}
+	if e.Interval != "" {
+		context = append(context, "interval " + e.Interval)
+	}
+	if e.Sink != "" {
+		context = append(context, "sink " + e.Sink)
+	}
+	if len(context) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s (%s)", e.Err, strings.Join(context, ", "))
+}
+
+func (e *SnapshotError) Unwrap() error {
+	return e.Err
+}
+
+// A panic that we recovered from, so that it can be handled like any other error.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func NewPanicError(value any) *PanicError {
+	return &PanicError{value, debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Swapped out in the tests, so that they don't talk to Bugsnag.
+var notifyBugsnag = func(err error, metadata bugsnag.MetaData) {
+	if notifyErr := bugsnag.Notify(err, metadata); notifyErr != nil {
+		logger.Error("Can't report an error to Bugsnag", "error", notifyErr)
+	}
+}
+
+// Sends an error to Bugsnag, along with whatever context it has.
+func reportError(err error) {
+	metadata := bugsnag.MetaData{}
+	var snapshotErr *SnapshotError
+	if errors.As(err, &snapshotErr) {
+		for key, value := range map[string]string{
+			"table": snapshotErr.Table, "interval": snapshotErr.Interval, "sql": snapshotErr.Sql, "sink": snapshotErr.Sink,
+		} {
+			if value != "" {
+				metadata.Add("snapshot", key, value)
+			}
+		}
+	}
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		metadata.Add("panic", "stack", string(panicErr.Stack))
+	}
+	notifyBugsnag(err, metadata)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bugsnag/bugsnag-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// Collects what would have been sent to Bugsnag.
+func WithBugsnagReports(fn func(reports *[]bugsnag.MetaData)) {
+	oldNotify := notifyBugsnag
+	reports := []bugsnag.MetaData{}
+	notifyBugsnag = func(err error, metadata bugsnag.MetaData) {
+		reports = append(reports, metadata)
+	}
+	defer func() { notifyBugsnag = oldNotify }()
+
+	fn(&reports)
+}
+
+func TestSnapshotErrorMessage(t *testing.T) {
+	err := &SnapshotError{errors.New("HONK"), "users", "0-100", "SELECT 1", ""}
+	assert.Equal(t, "HONK (table 'users', interval 0-100)", err.Error())
+	assert.Equal(t, "HONK", (&SnapshotError{Err: errors.New("HONK")}).Error())
+	assert.ErrorContains(t, &SnapshotError{Err: errors.New("HONK"), Sink: "CsvSink"}, "sink CsvSink")
+}
+
+func TestReportError(t *testing.T) {
+	WithBugsnagReports(func(reports *[]bugsnag.MetaData) {
+		reportError(errors.New("plain"))
+		reportError(&SnapshotError{errors.New("HONK"), "users", "0-100", "SELECT 1", ""})
+		reportError(NewPanicError("OH NO"))
+
+		assert.Len(t, *reports, 3)
+		assert.Empty(t, (*reports)[0])
+		assert.Equal(t, map[string]any{"table": "users", "interval": "0-100", "sql": "SELECT 1"}, (*reports)[1]["snapshot"])
+		assert.Contains(t, (*reports)[2]["panic"]["stack"], "errors_test.go")
+	})
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-mysql-org/go-mysql/client"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,7 +23,7 @@ import (
 func init() {
 	stateStorage.ClearAll()
 
-	pool = &FakeMysqlPool{FakeMysqlClient{true, []FakeMysqlResponse{}}}
+	pool = &FakeMysqlPool{FakeMysqlClient{sync.Mutex{}, true, []FakeMysqlResponse{}}}
 }
 
 func WithConfig(key string, val string, fn func()) {
@@ -30,6 +36,30 @@ func WithConfig(key string, val string, fn func()) {
 	fn()
 }
 
+// Sends the logs to a buffer as JSON, at the debug level unless 'overrides' says otherwise.
+func WithLogBuffer(overrides map[string]string, fn func(logs *bytes.Buffer)) {
+	var logs bytes.Buffer
+	logConfig := Config{LogLevel: "debug", LogFormat: "json", LogLevels: overrides}
+	SetupLogging(&logs, logConfig)
+	defer SetupLogging(os.Stdout, config)
+
+	fn(&logs)
+}
+
+// Decodes each line of JSON logs.
+func ParseJsonLogs(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	lines := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := map[string]any{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &fields))
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
 func WithStateStorage(contents map[string]string, fn func()) {
 	oldStateStorage := stateStorage
 	stateStorage = NewStateStorageMemory()
@@ -92,7 +122,9 @@ func (response *FakeMysqlResponse) RowNumber() int {
 	return len(response.Rows)
 }
 
+// The snapshot workers share the one fake client, so it has a lock.
 type FakeMysqlClient struct {
+	Lock sync.Mutex
 	Connected bool
 	Responses []FakeMysqlResponse
 }
@@ -115,6 +147,8 @@ func (fake *FakeMysqlClient) AddErrorResponse(err string) {
 }
 
 func (fake *FakeMysqlClient) Execute(query string, args ...interface{}) (IMysqlResult, error) {
+	fake.Lock.Lock()
+	defer fake.Lock.Unlock()
 	if len(fake.Responses) == 0 {
 		return nil, errors.New("No fake MySQL responses left!")
 	}
@@ -132,11 +166,17 @@ func (fake *FakeMysqlClient) Execute(query string, args ...interface{}) (IMysqlR
 }
 
 func SetFakeResponses(responses... FakeMysqlResponse) {
-	pool.(*FakeMysqlPool).Client.Responses = responses
+	client := &pool.(*FakeMysqlPool).Client
+	client.Lock.Lock()
+	defer client.Lock.Unlock()
+	client.Responses = responses
 }
 
 func AddFakeResponses(responses... FakeMysqlResponse) {
-	pool.(*FakeMysqlPool).Client.Responses = append(pool.(*FakeMysqlPool).Client.Responses, responses...)
+	client := &pool.(*FakeMysqlPool).Client
+	client.Lock.Lock()
+	defer client.Lock.Unlock()
+	client.Responses = append(client.Responses, responses...)
 }
 
 type FakeMysqlPool struct {
@@ -155,6 +195,10 @@ func (pool *FakeMysqlPool) PutConn(conn IMysqlClient) {
 	// No-op.
 }
 
+func (pool *FakeMysqlPool) Stats() client.ConnectionStats {
+	return client.ConnectionStats{TotalCount: 1, IdleCount: 1, CreatedCount: 1}
+}
+
 func TestPoolExecute(t *testing.T) {
 	SetFakeResponses(
 		FakeMysqlResponse{false, 3, []string{"foo"}, [][]any{{"bar"}}},
@@ -181,31 +225,57 @@ type FakeSnapshotStateTable struct {
 type FakeSnapshotState struct {
 	FinalInterval Interval
 	Tables []*FakeSnapshotStateTable
+	Paused map[string]bool
+	Removed map[string]bool  // Tables taken out by RemoveTable, whose busy intervals can still finish.
 }
 
 func NewFakeSnapshotState(tableNames []string, rowsPerTable int) SnapshotState {
 	numberOfChunks := int(math.Ceil(float64(rowsPerTable) / float64(config.SnapshotChunkSize)))
-	state := FakeSnapshotState{FinalInterval: Interval{0, uint64(numberOfChunks) * config.SnapshotChunkSize}}
+	state := FakeSnapshotState{
+		FinalInterval: Interval{0, uint64(numberOfChunks) * config.SnapshotChunkSize},
+		Paused: map[string]bool{},
+		Removed: map[string]bool{},
+	}
 	for _, tableName := range tableNames {
-		schema := &TableSchema{tableName, []Column{{"id", "bigint", 20, 0, false, false}}}
-		table := FakeSnapshotStateTable{schema, IntervalList{}, IntervalList{}}
-		for i := 0; i < numberOfChunks; i++ {
-			table.PendingIntervals = append(table.PendingIntervals, Interval{uint64(i) * config.SnapshotChunkSize, uint64(i + 1) * config.SnapshotChunkSize})
-		}
-		state.Tables = append(state.Tables, &table)
+		state.AddTable(&TableSchema{tableName, []Column{{"id", "bigint", 20, 0, false, false}}})
 	}
 	return &state
 }
 
+func (state *FakeSnapshotState) AddTable(schema *TableSchema) error {
+	table := FakeSnapshotStateTable{schema, IntervalList{}, IntervalList{}}
+	for start := state.FinalInterval.Start; start < state.FinalInterval.End; start += config.SnapshotChunkSize {
+		table.PendingIntervals = append(table.PendingIntervals, Interval{start, start + config.SnapshotChunkSize})
+	}
+	state.Tables = append(state.Tables, &table)
+	return nil
+}
+
+func (state *FakeSnapshotState) RemoveTable(tableName string) {
+	for i, table := range state.Tables {
+		if table.Schema.Name == tableName {
+			state.Tables = DeleteFromSlice(state.Tables, i)
+			state.Removed[tableName] = true
+			return
+		}
+	}
+}
+
+// Starts looking at a random table, so that the tables finish in a different order each time.
 func (state *FakeSnapshotState) GetNextPendingInterval() (PendingInterval, bool) {
-	table := state.Tables[rand.Intn(len(state.Tables))]
-	if len(table.PendingIntervals) == 0 {
+	if len(state.Tables) == 0 {
 		return PendingInterval{}, false
-	} else {
-		interval := table.PendingIntervals[0]
-		table.PendingIntervals = table.PendingIntervals[1:]
-		return PendingInterval{table.Schema, interval}, true
 	}
+	offset := rand.Intn(len(state.Tables))
+	for i := range state.Tables {
+		table := state.Tables[(offset + i) % len(state.Tables)]
+		if len(table.PendingIntervals) > 0 && !state.Paused[table.Schema.Name] {
+			interval := table.PendingIntervals[0]
+			table.PendingIntervals = table.PendingIntervals[1:]
+			return PendingInterval{table.Schema, interval}, true
+		}
+	}
+	return PendingInterval{}, false
 }
 
 func (state *FakeSnapshotState) MarkIntervalDone(pendingInterval PendingInterval) error {
@@ -219,9 +289,174 @@ func (state *FakeSnapshotState) MarkIntervalDone(pendingInterval PendingInterval
 			return nil
 		}
 	}
+	if state.Removed[pendingInterval.Schema.Name] {
+		return nil  // The table was removed while this interval was in progress.
+	}
 	panic(fmt.Errorf("No such table: %s", pendingInterval.Schema.Name))
 }
 
+func (state *FakeSnapshotState) RequeueInterval(pendingInterval PendingInterval) {
+	for _, table := range state.Tables {
+		if table.Schema.Name == pendingInterval.Schema.Name {
+			table.PendingIntervals = append(IntervalList{pendingInterval.Interval}, table.PendingIntervals...)
+			return
+		}
+	}
+}
+
+func (state *FakeSnapshotState) PendingIntervalCounts() map[string]int {
+	counts := map[string]int{}
+	for _, table := range state.Tables {
+		counts[table.Schema.Name] = len(table.PendingIntervals)
+	}
+	return counts
+}
+
+func (state *FakeSnapshotState) TableStatuses() map[string]TableStatus {
+	statuses := map[string]TableStatus{}
+	for _, table := range state.Tables {
+		statuses[table.Schema.Name] = TableStatus{
+			MaxId: state.FinalInterval.End - 1,
+			CompletedIntervals: table.CompletedIntervals.String(),
+			Paused: state.Paused[table.Schema.Name],
+			completedIds: table.CompletedIntervals.Size(),
+		}
+	}
+	return statuses
+}
+
+func (state *FakeSnapshotState) HasTable(tableName string) bool {
+	for _, table := range state.Tables {
+		if table.Schema.Name == tableName {
+			return true
+		}
+	}
+	return false
+}
+
+func (state *FakeSnapshotState) PauseTable(tableName string) {
+	state.Paused[tableName] = true
+}
+
+func (state *FakeSnapshotState) ResumeTable(tableName string) {
+	delete(state.Paused, tableName)
+}
+
 func (state *FakeSnapshotState) Done() bool {
 	return len(state.Tables) == 0
 }
+
+// A CSV sink that writes uncompressed files into a temporary directory, which gets cleaned up after the test.
+func NewTestCsvSink(t *testing.T) *CsvSink {
+	return NewCsvSink(RotatingFileSettings{t.TempDir(), 0, 0, nil}, "raw", "none")
+}
+
+// A sink which doesn't write anything, but keeps track of what it was asked to do. It waits for Delay before
+// acknowledging each write, and if Stuck is true, it never acknowledges them at all. (Neither does it acknowledge
+// the first StuckWrites writes.) It answers with Err, if that's set.
+type FakeSink struct {
+	Lock sync.Mutex
+	Stuck bool
+	StuckWrites int
+	stuckRows []RowsEvent  // Writes we haven't answered, which Unstick answers.
+	Err error
+	Delay time.Duration
+	Opened []string
+	Closed []string
+	RowsEvents int
+	Exited bool
+}
+
+func (sink *FakeSink) Open(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Opened = append(sink.Opened, ts.Name)
+	return nil
+}
+
+func (sink *FakeSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Closed = append(sink.Closed, ts.Name)
+	return nil
+}
+
+func (sink *FakeSink) WriteRows(rows RowsEvent) {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.RowsEvents++
+	if !sink.Stuck && sink.RowsEvents > sink.StuckWrites {
+		go func() {
+			time.Sleep(sink.Delay)
+			rows.ResponseChan <- sink.Err
+		}()
+	} else {
+		sink.stuckRows = append(sink.stuckRows, rows)
+	}
+}
+
+// Answers every write the sink has been sitting on, and all the ones after.
+func (sink *FakeSink) Unstick() {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Stuck = false
+	sink.StuckWrites = 0
+	for _, rows := range sink.stuckRows {
+		go func(responseChan chan error, err error) { responseChan <- err }(rows.ResponseChan, sink.Err)
+	}
+	sink.stuckRows = nil
+}
+
+func (sink *FakeSink) SchemaChange(newSchema *TableSchema) error {
+	return nil
+}
+
+func (sink *FakeSink) Exit() error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Exited = true
+	return nil
+}
+
+// Remembers every metric it's given, keyed by name and tags, like "snapshot.rows|table:foo".
+type RecordingMetrics struct {
+	Lock sync.Mutex
+	Counts map[string]int64
+	Gauges map[string]float64
+	Timings map[string]int
+}
+
+func NewRecordingMetrics() *RecordingMetrics {
+	return &RecordingMetrics{sync.Mutex{}, map[string]int64{}, map[string]float64{}, map[string]int{}}
+}
+
+func WithRecordingMetrics(fn func(recorder *RecordingMetrics)) {
+	oldMetrics := metrics
+	recorder := NewRecordingMetrics()
+	metrics = recorder
+	defer func() { metrics = oldMetrics }()
+
+	fn(recorder)
+}
+
+func (m *RecordingMetrics) key(name string, tags []string) string {
+	return strings.Join(append([]string{name}, tags...), "|")
+}
+
+func (m *RecordingMetrics) Count(name string, value int64, tags ...string) {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	m.Counts[m.key(name, tags)] += value
+}
+
+func (m *RecordingMetrics) Gauge(name string, value float64, tags ...string) {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	m.Gauges[m.key(name, tags)] = value
+}
+
+func (m *RecordingMetrics) Timing(name string, duration time.Duration, tags ...string) {
+	m.Lock.Lock()
+	defer m.Lock.Unlock()
+	m.Timings[m.key(name, tags)]++
+}
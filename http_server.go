@@ -0,0 +1,37 @@
+// The exporter's own HTTP server, on HTTP_ADDRESS. It serves the health checks and status report, and /metrics
+// when the Prometheus metrics backend is in use.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+type HttpServer struct {
+	Server *http.Server
+	Mux *http.ServeMux
+}
+
+func NewHttpServer(address string) *HttpServer {
+	mux := http.NewServeMux()
+	return &HttpServer{
+		&http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		mux,
+	}
+}
+
+// Serves requests until Exit() is called. Call this in its own goroutine.
+func (hs *HttpServer) Run() error {
+	httpLogger.Info("Listening for HTTP requests.", "address", hs.Server.Addr)
+	err := hs.Server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (hs *HttpServer) Exit() error {
+	return hs.Server.Close()
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
 )
 
+// Lists in the compact encoding start with this, which can never appear in the comma-separated text format.
+const COMPACT_INTERVAL_PREFIX = "~"
+
 // Half-open range, like [start, end). `End` is not in the interval.
 type Interval struct {
 	Start, End uint64
@@ -14,8 +20,27 @@ type Interval struct {
 type IntervalList []Interval
 
 func ParseInterval(s string) Interval {
-	parts := strings.Split(s, "-")
-	return Interval{uint64(MustParseInt(parts[0])), uint64(MustParseInt(parts[1]))}
+	interval, err := parseInterval(s)
+	if err != nil {
+		panic(err)
+	}
+	return interval
+}
+
+func parseInterval(s string) (Interval, error) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		return Interval{}, fmt.Errorf("Bogus interval '%s'", s)
+	}
+	i, err := strconv.ParseUint(start, 10, 64)
+	if err != nil {
+		return Interval{}, fmt.Errorf("Bogus interval '%s': %s", s, err)
+	}
+	j, err := strconv.ParseUint(end, 10, 64)
+	if err != nil {
+		return Interval{}, fmt.Errorf("Bogus interval '%s': %s", s, err)
+	}
+	return Interval{i, j}, nil
 }
 
 func (i Interval) String() string {
@@ -36,16 +61,49 @@ func (i Interval) Includes(j Interval) bool {
 	return i.Start <= j.Start && i.End >= j.End
 }
 
+// Parses either the compact encoding produced by Encode() or the comma-separated format produced by String().
+// Panics on garbage, which is fine for the progress we stored ourselves.
 func ParseIntervalList(s string) IntervalList {
+	list, err := DecodeIntervalList(s)
+	if err != nil {
+		panic(err)
+	}
+	return list
+}
+
+// Like ParseIntervalList, but for lists we didn't write ourselves, like the ones in an imported state document.
+func DecodeIntervalList(s string) (IntervalList, error) {
 	if s == "" {
-		return IntervalList{}
+		return IntervalList{}, nil
+	}
+	if strings.HasPrefix(s, COMPACT_INTERVAL_PREFIX) {
+		return parseCompactIntervalList(strings.TrimPrefix(s, COMPACT_INTERVAL_PREFIX))
 	}
 	intervals := strings.Split(s, ",")
 	cl := make(IntervalList, len(intervals))
 	for i, interval := range intervals {
-		cl[i] = ParseInterval(interval)
+		var err error
+		if cl[i], err = parseInterval(interval); err != nil {
+			return nil, err
+		}
 	}
-	return cl
+	return cl, nil
+}
+
+// Checks that the list is the sort that Merge() makes: every interval non-empty, in order, and none overlapping.
+// Encode() depends on that.
+func (list IntervalList) Validate() error {
+	var previousEnd uint64
+	for i, interval := range list {
+		if interval.Start >= interval.End {
+			return fmt.Errorf("Interval %s is empty or backwards", interval)
+		}
+		if i > 0 && interval.Start < previousEnd {
+			return fmt.Errorf("Interval %s overlaps or comes before %s", interval, list[i - 1])
+		}
+		previousEnd = interval.End
+	}
+	return nil
 }
 
 func (list IntervalList) Includes(interval Interval) bool {
@@ -65,6 +123,82 @@ func (list IntervalList) String() string {
 	return strings.Join(strs, ",")
 }
 
+// Returns a compact encoding of the list, for storing snapshot progress. Huge tables with lots of out-of-order
+// completions can have a lot of intervals, so instead of text we store each interval as two varints: the gap
+// since the end of the previous interval, then the interval's length. Since intervals are almost always
+// multiples of the chunk size, those numbers are divided by their greatest common divisor (stored first) so
+// that they usually fit in a byte or two. The last interval's length is left out of that, because it normally
+// ends at MaxId + 1. The result is base64'd to keep it safe for string-based storage. The list must be sorted,
+// which Merge() guarantees.
+func (list IntervalList) Encode() string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	values := make([]uint64, 0, len(list) * 2)
+	var previousEnd uint64
+	for _, interval := range list {
+		values = append(values, interval.Start - previousEnd, interval.End - interval.Start)
+		previousEnd = interval.End
+	}
+	var unit uint64
+	for _, value := range values[:len(values) - 1] {
+		unit = gcd(unit, value)
+	}
+	if unit == 0 {
+		unit = 1
+	}
+
+	buf := make([]byte, 0, len(values) + binary.MaxVarintLen64 * 2)
+	buf = binary.AppendUvarint(buf, unit)
+	for _, value := range values[:len(values) - 1] {
+		buf = binary.AppendUvarint(buf, value / unit)
+	}
+	buf = binary.AppendUvarint(buf, values[len(values) - 1])
+	return COMPACT_INTERVAL_PREFIX + base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func parseCompactIntervalList(s string) (IntervalList, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode compact interval list '%s': %s", s, err)
+	}
+
+	values := []uint64{}
+	for len(buf) > 0 {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("Corrupt compact interval list '%s'", s)
+		}
+		values = append(values, value)
+		buf = buf[n:]
+	}
+	if len(values) < 3 || len(values) % 2 != 1 || values[0] == 0 {
+		return nil, fmt.Errorf("Corrupt compact interval list '%s'", s)
+	}
+
+	unit, values := values[0], values[1:]
+	list := make(IntervalList, 0, len(values) / 2)
+	var previousEnd uint64
+	for i := 0; i < len(values); i += 2 {
+		start := previousEnd + values[i] * unit
+		length := values[i + 1]
+		if i + 2 < len(values) {
+			length *= unit
+		}
+		list = append(list, Interval{start, start + length})
+		previousEnd = start + length
+	}
+	return list, nil
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a % b
+	}
+	return a
+}
+
 // Returns a copy of 'list' with the interval added.
 func (list IntervalList) Merge(interval Interval) IntervalList {
 	list = append(list, interval)
@@ -105,6 +239,15 @@ func (list IntervalList) Subtract(interval Interval) IntervalList {
 	return result
 }
 
+// Returns the number of IDs covered by the list.
+func (list IntervalList) Size() uint64 {
+	size := uint64(0)
+	for _, interval := range list {
+		size += interval.End - interval.Start
+	}
+	return size
+}
+
 func (list IntervalList) HighestContiguous() uint64 {
 	if len(list) == 0 {
 		return 0
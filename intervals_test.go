@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,6 +20,58 @@ func TestParseIntervalList(t *testing.T) {
 	assert.Equal(t, expected, list)
 }
 
+func TestIntervalListEncode(t *testing.T) {
+	assert.Equal(t, "", IntervalList{}.Encode())
+
+	list := IntervalList{
+		Interval{0, 200},
+		Interval{500, 600},
+		Interval{10010, 31337},
+	}
+	encoded := list.Encode()
+	assert.True(t, strings.HasPrefix(encoded, COMPACT_INTERVAL_PREFIX))
+	assert.Equal(t, list, ParseIntervalList(encoded))
+
+	// Lots of out-of-order completions in a huge table should stay small.
+	list = IntervalList{}
+	for i := uint64(0); i < 10_000; i++ {
+		list = append(list, Interval{5_000_000_000 + i * 200_000, 5_000_000_000 + i * 200_000 + 100_000})
+	}
+	encoded = list.Encode()
+	assert.Less(t, len(encoded) * 5, len(list.String()))
+	assert.Equal(t, list, ParseIntervalList(encoded))
+
+	// The last interval normally ends at MaxId + 1, which isn't a multiple of the chunk size.
+	list = IntervalList{Interval{0, 300_000}, Interval{500_000, 700_000}, Interval{900_000, 931_338}}
+	assert.Equal(t, list, ParseIntervalList(list.Encode()))
+	list = IntervalList{Interval{17, 4_000}, Interval{4_013, 10_000}}
+	assert.Equal(t, list, ParseIntervalList(list.Encode()))
+	list = IntervalList{Interval{0, 31_337}}
+	assert.Equal(t, list, ParseIntervalList(list.Encode()))
+}
+
+func TestIntervalListValidate(t *testing.T) {
+	assert.NoError(t, IntervalList{}.Validate())
+	assert.NoError(t, ParseIntervalList("0-200,200-300,500-600").Validate())
+	assert.EqualError(t, ParseIntervalList("10-5").Validate(), "Interval 10-5 is empty or backwards")
+	assert.EqualError(t, ParseIntervalList("5-5").Validate(), "Interval 5-5 is empty or backwards")
+	assert.EqualError(t, ParseIntervalList("5-10,1-3").Validate(), "Interval 1-3 overlaps or comes before 5-10")
+	assert.EqualError(t, ParseIntervalList("0-10,5-20").Validate(), "Interval 5-20 overlaps or comes before 0-10")
+}
+
+func TestParseIntervalListCorrupt(t *testing.T) {
+	assert.Panics(t, func() { ParseIntervalList(COMPACT_INTERVAL_PREFIX + "!!!") })
+	assert.Panics(t, func() { ParseIntervalList(COMPACT_INTERVAL_PREFIX + "_w") })
+
+	for _, s := range []string{COMPACT_INTERVAL_PREFIX + "!!!", "0-100,honk", "0-100,200", "-5-10"} {
+		_, err := DecodeIntervalList(s)
+		assert.Error(t, err, s)
+	}
+	list, err := DecodeIntervalList("0-100,200-300")
+	assert.NoError(t, err)
+	assert.Equal(t, IntervalList{Interval{0, 100}, Interval{200, 300}}, list)
+}
+
 func TestIntervalListString(t *testing.T) {
 	assert.Equal(t, "", IntervalList{}.String())
 
@@ -133,3 +186,8 @@ func TestIntervalListNextGap(t *testing.T) {
 	s = IntervalList{Interval{0, 2000}, Interval{4000, 5000}}.NextGap(5000).String()
 	assert.Equal(t, "2000-4000", s)
 }
+
+func TestIntervalListSize(t *testing.T) {
+	assert.Equal(t, uint64(0), IntervalList{}.Size())
+	assert.Equal(t, uint64(150), IntervalList{{0, 100}, {200, 250}}.Size())
+}
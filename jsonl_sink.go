@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterSinkType("jsonl", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, ROTATING_FILE_PARAMS...); err != nil {
+			return nil, err
+		}
+		settings, err := ParseRotatingFileSettings(params)
+		if err != nil {
+			return nil, err
+		}
+		return NewJsonlSink(settings), nil
+	})
+}
+
+// Writes each row as a Debezium change event on its own line, with one set of files per table. A file is finished
+// once it's bigger than max_size bytes or older than max_age, then handed to the uploader if there's an `upload`
+// destination.
+type JsonlSink struct {
+	Lock sync.Mutex
+	RotatingFileSettings
+	Files map[string]*RotatingFile
+	done chan struct{}
+	exitOnce sync.Once
+}
+
+func NewJsonlSink(settings RotatingFileSettings) *JsonlSink {
+	sink := &JsonlSink{
+		sync.Mutex{}, settings,
+		make(map[string]*RotatingFile), make(chan struct{}), sync.Once{},
+	}
+	if settings.MaxAge > 0 {
+		go rotateOldFiles(sinkLogger.With("directory", settings.Directory), settings.MaxAge, &sink.Lock, sink.Files, sink.done)
+	}
+	return sink
+}
+
+func (sink *JsonlSink) Open(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Files[ts.Name] = sink.NewFile(ts.Name, "jsonl")
+	return nil
+}
+
+func (sink *JsonlSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[ts.Name]
+	if !ok {
+		panic(fmt.Errorf("Can't close non-existent file for table '%s'!", ts.Name))
+	}
+	delete(sink.Files, ts.Name)
+	return file.Finish()
+}
+
+func (sink *JsonlSink) WriteRows(rows RowsEvent) {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[rows.Schema.Name]
+	if !ok {
+		panic(fmt.Errorf("Can't find file for table '%s'!", rows.Schema.Name))
+	}
+
+	// Rows never get split across files, so we encode the whole batch before writing any of it.
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	envelopes, err := debeziumEnvelopes(rows, time.Now())
+	if err != nil {
+		rows.ResponseChan <- err
+		return
+	}
+	for _, envelope := range envelopes {
+		if err := encoder.Encode(envelope); err != nil {
+			rows.ResponseChan <- err
+			return
+		}
+	}
+	_, err = file.Write(buf.Bytes())
+	rows.ResponseChan <- err
+}
+
+// The old files were written with the old schema, so we finish them and start over.
+func (sink *JsonlSink) SchemaChange(newSchema *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	file, ok := sink.Files[newSchema.Name]
+	if !ok {
+		return fmt.Errorf("Can't change the schema of table '%s', which was never opened", newSchema.Name)
+	}
+	return file.Finish()
+}
+
+// Safe to call more than once.
+func (sink *JsonlSink) Exit() error {
+	sink.exitOnce.Do(func() { close(sink.done) })
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	var firstErr error
+	for _, file := range sink.Files {
+		if err := file.Finish(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readJsonlFiles(t *testing.T, pattern string) []map[string]any {
+	paths, err := filepath.Glob(pattern)
+	assert.NoError(t, err)
+	lines := []map[string]any{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		assert.NoError(t, err)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var line map[string]any
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		file.Close()
+	}
+	return lines
+}
+
+func TestJsonlSinkEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`price` decimal(6,2) DEFAULT NULL,\n" +
+		"`blob` varbinary(10),\n`born` date,\n`at` datetime(6),\n`ts` timestamp,\n`name` varchar(10)\n)")
+	sink := NewJsonlSink(RotatingFileSettings{dir, 0, 0, nil})
+	assert.NoError(t, sink.Open(schema))
+
+	at := time.Date(2021, 10, 29, 6, 5, 22, 123456000, time.UTC)
+	rows := RowsEvent{make(chan error, 1), schema, [][]any{
+		{uint64(1), big.NewRat(-12345, 100), []byte("hi"), time.Date(1973, 8, 30, 0, 0, 0, 0, time.UTC), at, at, "honk"},
+	}, RowsSource{true, BinlogCoordinates{"binlog.000042", 1337, ""}}}
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+	assert.NoError(t, sink.Exit())
+
+	lines := readJsonlFiles(t, filepath.Join(dir, "foo.*.jsonl"))
+	if !assert.Len(t, lines, 1) {
+		return
+	}
+	assert.Nil(t, lines[0]["before"])
+	assert.Equal(t, "r", lines[0]["op"])
+	assert.Equal(t, map[string]any{
+		"id": 1.0, "price": "z8c=", "blob": "aGk=", "born": 1337.0, "at": float64(at.UnixMicro()),
+		"ts": "2021-10-29T06:05:22.123456Z", "name": "honk",
+	}, lines[0]["after"])
+
+	source := lines[0]["source"].(map[string]any)
+	assert.Equal(t, "true", source["snapshot"])
+	assert.Equal(t, "foo", source["table"])
+	assert.Equal(t, "binlog.000042", source["file"])
+	assert.Equal(t, 1337.0, source["pos"])
+	assert.Nil(t, source["gtid"])
+}
+
+func TestJsonlSinkRotation(t *testing.T) {
+	dir, uploadDir := t.TempDir(), t.TempDir()
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL\n)")
+	sink := NewJsonlSink(RotatingFileSettings{dir, 1, 0, NewLocalUploader(uploadDir)})
+	assert.NoError(t, sink.Open(schema))
+
+	for i := 1; i <= 3; i++ {
+		rows := RowsEvent{make(chan error, 1), schema, [][]any{{uint64(i)}}, RowsSource{}}
+		sink.WriteRows(rows)
+		assert.NoError(t, <-rows.ResponseChan)
+	}
+	assert.NoError(t, sink.Exit())
+
+	// Every write makes the file too big, so each row ends up in its own uploaded file.
+	local, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Empty(t, local)
+	uploaded := readJsonlFiles(t, filepath.Join(uploadDir, "foo", "foo.*.jsonl"))
+	assert.Len(t, uploaded, 3)
+	assert.Equal(t, "c", uploaded[0]["op"])
+}
+
+func TestJsonlSinkRotatesOldFiles(t *testing.T) {
+	dir, uploadDir := t.TempDir(), t.TempDir()
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL\n)")
+	sink := NewJsonlSink(RotatingFileSettings{dir, 0, 50 * time.Millisecond, NewLocalUploader(uploadDir)})
+	assert.NoError(t, sink.Open(schema))
+
+	rows := RowsEvent{make(chan error, 1), schema, [][]any{{uint64(1)}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+
+	assert.Eventually(t, func() bool {
+		return len(readJsonlFiles(t, filepath.Join(uploadDir, "foo", "foo.*.jsonl"))) == 1
+	}, 2 * time.Second, 10 * time.Millisecond)
+	assert.NoError(t, sink.Exit())
+	assert.NoError(t, sink.Exit())  // Exiting twice is fine.
+}
+
+func TestJsonlSinkParams(t *testing.T) {
+	_, err := sinkTypes["jsonl"](map[string]string{})
+	assert.ErrorContains(t, err, "Missing parameter: directory")
+	_, err = sinkTypes["jsonl"](map[string]string{"directory": "/tmp", "max_size": "lots"})
+	assert.ErrorContains(t, err, "Bogus value for max_size: 'lots'")
+	_, err = sinkTypes["jsonl"](map[string]string{"directory": "/tmp", "upload": "ftp://example.com"})
+	assert.ErrorContains(t, err, "unsupported scheme 'ftp'")
+
+	sink, err := sinkTypes["jsonl"](map[string]string{"directory": "/tmp", "max_size": "100", "max_age": "10m", "upload": "file:///archive"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, int64(100), sink.(*JsonlSink).MaxSize)
+	assert.Equal(t, 10 * time.Minute, sink.(*JsonlSink).MaxAge)
+	assert.Equal(t, NewLocalUploader("/archive"), sink.(*JsonlSink).Uploader)
+	assert.NoError(t, sink.Exit())
+}
+
+func TestDecimalBytes(t *testing.T) {
+	tests := map[int64][]byte{
+		0: {0x00}, 1: {0x01}, 127: {0x7f}, 128: {0x00, 0x80}, 255: {0x00, 0xff}, 256: {0x01, 0x00},
+		-1: {0xff}, -128: {0x80}, -129: {0xff, 0x7f}, -256: {0xff, 0x00}, -257: {0xfe, 0xff},
+	}
+	for n, expected := range tests {
+		value, err := decimalBytes(big.NewRat(n, 1), Column{Width: 3})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, value, "%d", n)
+	}
+	value, err := decimalBytes(big.NewRat(12345, 100), Column{Width: 6, Scale: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x30, 0x39}, value)
+
+	// Extra digits get rounded off.
+	rounded := map[*big.Rat][]byte{
+		big.NewRat(1, 3): {0x21}, big.NewRat(2, 3): {0x43}, big.NewRat(-2, 3): {0xbd}, big.NewRat(1, 200): {0x01},
+	}
+	for r, expected := range rounded {
+		value, err := decimalBytes(r, Column{Width: 6, Scale: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, value, "%s", r)
+	}
+
+	_, err = decimalBytes(big.NewRat(10000, 1), Column{Width: 6, Scale: 2})
+	assert.ErrorContains(t, err, "Decimal 10000.00 doesn't fit in a decimal(6,2) column")
+}
+
+func TestJsonlSinkDecimalErrors(t *testing.T) {
+	sink := NewJsonlSink(RotatingFileSettings{t.TempDir(), 0, 0, nil})
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`price` decimal(6,2)\n)")
+	assert.NoError(t, sink.Open(schema))
+	rows := RowsEvent{make(chan error, 1), schema, [][]any{{uint64(1), big.NewRat(10000, 1)}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.ErrorContains(t, <-rows.ResponseChan, "Can't convert column 'price'")
+	assert.NoError(t, sink.Exit())
+}
@@ -0,0 +1,122 @@
+// Produces each row to Kafka as a Debezium change event, with one topic per table. The message key is the row's
+// primary key, so all of the changes to a row land on the same partition, in order.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func init() {
+	RegisterSinkType("kafka", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, "brokers", "topic_prefix", "create_topics"); err != nil {
+			return nil, err
+		}
+		if params["brokers"] == "" {
+			return nil, fmt.Errorf("Missing parameter: brokers")
+		}
+		createTopics := false
+		if value, ok := params["create_topics"]; ok {
+			switch value {
+			case "true": createTopics = true
+			case "false": createTopics = false
+			default: return nil, fmt.Errorf("Bogus value for create_topics: '%s'", value)
+			}
+		}
+		return NewKafkaSink(strings.Split(params["brokers"], ","), params["topic_prefix"], createTopics)
+	})
+}
+
+type KafkaSink struct {
+	Client *kgo.Client
+	TopicPrefix string
+	ctx context.Context
+	cancel context.CancelFunc
+}
+
+// The producer is idempotent (franz-go's default), which needs acks from all in-sync replicas, so a broker
+// retrying a batch can't duplicate or reorder the messages in it.
+func NewKafkaSink(brokers []string, topicPrefix string, createTopics bool) (*KafkaSink, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(brokers...),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	}
+	if createTopics {
+		opts = append(opts, kgo.AllowAutoTopicCreation())
+	}
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &KafkaSink{client, topicPrefix, ctx, cancel}, nil
+}
+
+func (sink *KafkaSink) Topic(tableName string) string {
+	return sink.TopicPrefix + tableName
+}
+
+// There's nothing to open or close for each table; the topics are created when we first write to them, if the
+// broker (or create_topics) allows it.
+func (sink *KafkaSink) Open(ts *TableSchema) error {
+	return nil
+}
+
+func (sink *KafkaSink) Close(ts *TableSchema) error {
+	return nil
+}
+
+// Only answers once the broker has acknowledged every message in the batch.
+func (sink *KafkaSink) WriteRows(rows RowsEvent) {
+	records, err := sink.records(rows)
+	if err != nil {
+		rows.ResponseChan <- err
+		return
+	}
+	rows.ResponseChan <- sink.Client.ProduceSync(sink.ctx, records...).FirstErr()
+}
+
+func (sink *KafkaSink) records(rows RowsEvent) ([]*kgo.Record, error) {
+	pk := rows.Schema.PrimaryKeyIndex()
+	envelopes, err := debeziumEnvelopes(rows, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*kgo.Record, len(rows.Data))
+	for i, envelope := range envelopes {
+		value, err := json.Marshal(envelope)
+		if err != nil {
+			return nil, err
+		}
+		// Like Debezium's keys, but without the schema: {"id": 123}.
+		var key []byte
+		if pk >= 0 {
+			column := rows.Schema.Columns[pk]
+			if key, err = json.Marshal(map[string]any{column.Name: envelope.After[column.Name]}); err != nil {
+				return nil, err
+			}
+		}
+		records[i] = &kgo.Record{Topic: sink.Topic(rows.Schema.Name), Key: key, Value: value}
+	}
+	// FIXME: Deletes should be followed by a tombstone, once we're streaming the binlog.
+	return records, nil
+}
+
+// The change events describe themselves, so a new schema doesn't change anything here.
+func (sink *KafkaSink) SchemaChange(newSchema *TableSchema) error {
+	return nil
+}
+
+// Waits for anything still buffered to be acknowledged, then disconnects.
+func (sink *KafkaSink) Exit() error {
+	err := sink.Client.Flush(sink.ctx)
+	sink.cancel()
+	sink.Client.Close()
+	return err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+var kafkaTestSchema = ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`name` varchar(10)\n)")
+
+func newFakeKafka(t *testing.T, opts ...kfake.Opt) *kfake.Cluster {
+	cluster, err := kfake.NewCluster(append([]kfake.Opt{kfake.NumBrokers(1)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+func consumeKafka(t *testing.T, cluster *kfake.Cluster, topic string, count int) []*kgo.Record {
+	client, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...), kgo.ConsumeTopics(topic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	records := []*kgo.Record{}
+	for len(records) < count && ctx.Err() == nil {
+		records = append(records, client.PollFetches(ctx).Records()...)
+	}
+	return records
+}
+
+func TestKafkaSink(t *testing.T) {
+	cluster := newFakeKafka(t, kfake.SeedTopics(3, "test.foo"))
+	sink, err := NewKafkaSink(cluster.ListenAddrs(), "test.", false)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Open(kafkaTestSchema))
+
+	rows := RowsEvent{make(chan error, 1), kafkaTestSchema, [][]any{
+		{uint64(1), "honk"}, {uint64(2), nil}, {uint64(3), "bonk"},
+	}, RowsSource{true, BinlogCoordinates{"binlog.000001", 4, ""}}}
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+	assert.NoError(t, sink.Exit())
+
+	records := consumeKafka(t, cluster, "test.foo", 3)
+	keys := map[string]map[string]any{}
+	for _, record := range records {
+		var envelope map[string]any
+		assert.NoError(t, json.Unmarshal(record.Value, &envelope))
+		keys[string(record.Key)] = envelope
+	}
+	assert.Len(t, keys, 3)
+	assert.Equal(t, "r", keys[`{"id":1}`]["op"])
+	assert.Equal(t, map[string]any{"id": 1.0, "name": "honk"}, keys[`{"id":1}`]["after"])
+	assert.Equal(t, map[string]any{"id": 2.0, "name": nil}, keys[`{"id":2}`]["after"])
+	assert.Equal(t, "foo", keys[`{"id":3}`]["source"].(map[string]any)["table"])
+}
+
+func TestKafkaSinkWaitsForBroker(t *testing.T) {
+	cluster := newFakeKafka(t, kfake.SeedTopics(1, "foo"))
+	release := make(chan struct{})
+	cluster.ControlKey(kmsg.Produce.Int16(), func(kmsg.Request) (kmsg.Response, error, bool) {
+		cluster.SleepControl(func() { <-release })
+		return nil, nil, false
+	})
+	sink, err := NewKafkaSink(cluster.ListenAddrs(), "", false)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Open(kafkaTestSchema))
+
+	rows := RowsEvent{make(chan error, 1), kafkaTestSchema, [][]any{{uint64(1), "honk"}}, RowsSource{}}
+	go sink.WriteRows(rows)
+	select {
+	case err = <-rows.ResponseChan:
+		t.Fatalf("Sink answered before the broker did: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-rows.ResponseChan)
+	assert.NoError(t, sink.Exit())
+	assert.Len(t, consumeKafka(t, cluster, "foo", 1), 1)
+}
+
+func TestKafkaSinkError(t *testing.T) {
+	cluster := newFakeKafka(t)
+	sink, err := NewKafkaSink(cluster.ListenAddrs(), "", false)
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Open(kafkaTestSchema))
+
+	// The topic doesn't exist and we aren't allowed to create it.
+	rows := RowsEvent{make(chan error, 1), kafkaTestSchema, [][]any{{uint64(1), "honk"}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.ErrorContains(t, <-rows.ResponseChan, "UNKNOWN_TOPIC_OR_PARTITION")
+	assert.NoError(t, sink.Exit())
+}
+
+func TestKafkaSinkParams(t *testing.T) {
+	_, err := sinkTypes["kafka"](map[string]string{})
+	assert.ErrorContains(t, err, "Missing parameter: brokers")
+	_, err = sinkTypes["kafka"](map[string]string{"brokers": "localhost:9092", "create_topics": "sure"})
+	assert.ErrorContains(t, err, "Bogus value for create_topics: 'sure'")
+
+	sink, err := sinkTypes["kafka"](map[string]string{"brokers": "localhost:9092", "topic_prefix": "mysql."})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "mysql.users", sink.(*KafkaSink).Topic("users"))
+	sink.(*KafkaSink).Client.Close()
+}
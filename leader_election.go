@@ -0,0 +1,115 @@
+// Makes sure that only one exporter is working on a given database at a time. During a rolling deploy, the
+// new pod waits on standby until the old one lets go of the lease (or dies and lets it expire), then takes over.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const LEADER_LEASE_KEY = "leader_lease"
+
+type LeaderElection struct {
+	Key string
+	Owner string
+	TTL time.Duration
+	lostChan chan struct{}
+	exitChan chan struct{}
+	exitOnce sync.Once
+}
+
+func NewLeaderElection() *LeaderElection {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return NewCustomLeaderElection(LEADER_LEASE_KEY, fmt.Sprintf("%s/%d", hostname, os.Getpid()), config.LeaderLeaseTTL)
+}
+
+func NewCustomLeaderElection(key, owner string, ttl time.Duration) *LeaderElection {
+	return &LeaderElection{
+		key, owner, ttl,
+		make(chan struct{}),
+		make(chan struct{}),
+		sync.Once{},
+	}
+}
+
+// We renew (or retry) three times per TTL, so a single slow request can't cost us the lease.
+func (le *LeaderElection) renewInterval() time.Duration {
+	return le.TTL / 3
+}
+
+// Blocks until we hold the lease. Returns false if we were told to exit before we got it.
+func (le *LeaderElection) WaitForLeadership() bool {
+	waiting := false
+	for {
+		acquired, err := stateStorage.AcquireLease(le.Key, le.Owner, le.TTL)
+		if err != nil {
+			leaderLogger.Error("Can't acquire the leader lease", "error", err)
+		} else if acquired {
+			leaderLogger.Info("Acquired the leader lease.", "owner", le.Owner)
+			return true
+		} else if !waiting {
+			leaderLogger.Info("Another exporter holds the leader lease. Waiting on standby.")
+			waiting = true
+		}
+
+		select {
+		case <-time.After(le.renewInterval()):
+		case <-le.exitChan:
+			return false
+		}
+	}
+}
+
+// Keeps renewing the lease until Exit() is called. If the lease is taken by someone else, or we go so long
+// without a successful renewal that it might have expired, we close the LostSignal channel and stop renewing.
+// Call this in its own goroutine after WaitForLeadership() returns true.
+func (le *LeaderElection) Run() {
+	ticker := time.NewTicker(le.renewInterval())
+	defer ticker.Stop()
+	lastRenewed := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			renewed, err := stateStorage.RenewLease(le.Key, le.Owner, le.TTL)
+			if err == nil && renewed {
+				lastRenewed = time.Now()
+			} else if err == nil {
+				leaderLogger.Error("The leader lease was taken by another exporter!")
+				close(le.lostChan)
+				return
+			} else if time.Since(lastRenewed) + le.renewInterval() >= le.TTL {
+				leaderLogger.Error("Can't renew the leader lease, and it may expire before the next attempt", "error", err)
+				close(le.lostChan)
+				return
+			} else {
+				leaderLogger.Warn("Can't renew the leader lease (will retry)", "error", err)
+			}
+
+		case <-le.exitChan:
+			return
+		}
+	}
+}
+
+// This returns a channel which will be closed if we lose the lease while running.
+func (le *LeaderElection) LostSignal() <-chan struct{} {
+	return le.lostChan
+}
+
+// Stops waiting for or renewing the lease and releases it, so that a standby exporter can take over
+// without waiting for it to expire.
+func (le *LeaderElection) Exit() {
+	le.exitOnce.Do(func() {
+		close(le.exitChan)
+		if err := stateStorage.ReleaseLease(le.Key, le.Owner); err != nil {
+			leaderLogger.Error("Can't release the leader lease", "error", err)
+		}
+	})
+}
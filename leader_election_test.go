@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderElectionStandbyTakesOver(t *testing.T) {
+	WithStateStorage(map[string]string{}, func() {
+		active := NewCustomLeaderElection("leader_lease", "active", 300 * time.Millisecond)
+		standby := NewCustomLeaderElection("leader_lease", "standby", 300 * time.Millisecond)
+
+		assert.True(t, active.WaitForLeadership())
+		go active.Run()
+
+		tookOver := make(chan bool)
+		go func() { tookOver <- standby.WaitForLeadership() }()
+
+		// The active instance keeps renewing, so the standby has to keep waiting.
+		select {
+		case <-tookOver:
+			t.Fatal("The standby took over while the lease was still held!")
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		active.Exit()
+		select {
+		case ok := <-tookOver:
+			assert.True(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("The standby didn't take over after the lease was released.")
+		}
+		standby.Exit()
+	})
+}
+
+func TestLeaderElectionStandbyExit(t *testing.T) {
+	WithStateStorage(map[string]string{}, func() {
+		active := NewCustomLeaderElection("leader_lease", "active", time.Minute)
+		standby := NewCustomLeaderElection("leader_lease", "standby", time.Minute)
+		assert.True(t, active.WaitForLeadership())
+
+		standby.Exit()
+		assert.False(t, standby.WaitForLeadership())
+		active.Exit()
+	})
+}
+
+func TestLeaderElectionLosesLease(t *testing.T) {
+	WithStateStorage(map[string]string{}, func() {
+		active := NewCustomLeaderElection("leader_lease", "active", 150 * time.Millisecond)
+		assert.True(t, active.WaitForLeadership())
+
+		// Pretend the active instance stalled long enough for its lease to expire and be taken.
+		time.Sleep(200 * time.Millisecond)
+		acquired, err := stateStorage.AcquireLease("leader_lease", "usurper", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		go active.Run()
+		select {
+		case <-active.LostSignal():
+		case <-time.After(time.Second):
+			t.Fatal("The active instance didn't notice that it lost the lease.")
+		}
+		active.Exit()
+
+		// Releasing a lease we don't own must not affect the new owner.
+		acquired, err = stateStorage.AcquireLease("leader_lease", "someone_else", time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, acquired)
+	})
+}
@@ -0,0 +1,102 @@
+// Structured logging, built on log/slog. LOG_FORMAT picks "logfmt" (the default) or "json", LOG_LEVEL sets the
+// lowest level that gets logged, and LOG_LEVELS overrides it for individual components, like
+// "snapshotter=debug,sink=warn". Every line has a "component" field saying which part of the exporter wrote it.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+const DEFAULT_LOG_LEVEL = "info"
+const DEFAULT_LOG_FORMAT = "logfmt"
+
+var LOG_FORMATS = []string{"logfmt", "json"}
+var LOG_COMPONENTS = []string{"main", "snapshotter", "state", "sink", "mysql", "leader", "http", "admin"}
+
+var logger *slog.Logger  // For everything that doesn't belong to one of the components below.
+var snapshotLogger *slog.Logger
+var stateLogger *slog.Logger
+var sinkLogger *slog.Logger
+var mysqlLogger *slog.Logger
+var leaderLogger *slog.Logger
+var httpLogger *slog.Logger
+var adminLogger *slog.Logger
+
+// (Re)creates all the loggers. Bad levels are ignored here, since Config.Validate() reports them.
+func SetupLogging(w io.Writer, c Config) {
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}  // componentHandler does the filtering.
+	var handler slog.Handler
+	if c.LogFormat == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+
+	newLogger := func(component string) *slog.Logger {
+		level, err := parseLogLevel(c.LogLevel)
+		if err != nil {
+			level = slog.LevelInfo
+		}
+		if override, found := c.LogLevels[component]; found {
+			if overrideLevel, err := parseLogLevel(override); err == nil {
+				level = overrideLevel
+			}
+		}
+		return slog.New(&componentHandler{level, handler}).With("component", component)
+	}
+	logger = newLogger("main")
+	snapshotLogger = newLogger("snapshotter")
+	stateLogger = newLogger("state")
+	sinkLogger = newLogger("sink")
+	mysqlLogger = newLogger("mysql")
+	leaderLogger = newLogger("leader")
+	httpLogger = newLogger("http")
+	adminLogger = newLogger("admin")
+}
+
+// Accepts the usual names ("debug", "info", "warn", "error"), in any case.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// slog.Logger doesn't have a Fatal, so here's ours.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Adapts a logger to the Printf-style function that go-mysql's connection pool wants.
+func printfLogger(l *slog.Logger) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		l.Info(fmt.Sprintf(format, args...))
+	}
+}
+
+// Wraps the real handler with a component's own level.
+type componentHandler struct {
+	level slog.Level
+	handler slog.Handler
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &componentHandler{h.level, h.handler.WithAttrs(attrs)}
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{h.level, h.handler.WithGroup(name)}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingComponentLevels(t *testing.T) {
+	WithLogBuffer(map[string]string{"sink": "warn", "state": "DEBUG"}, func(logs *bytes.Buffer) {
+		snapshotLogger.Debug("Snapshotting interval.", "table", "foo", "worker", 3)
+		sinkLogger.Info("Wrote rows.")
+		sinkLogger.Warn("Sink is slow.", "sink", "CsvSink")
+		stateLogger.Debug("Marked interval done.")
+
+		lines := ParseJsonLogs(t, logs)
+		assert.Len(t, lines, 3)
+		assert.Equal(t, "snapshotter", lines[0]["component"])
+		assert.Equal(t, "DEBUG", lines[0]["level"])
+		assert.Equal(t, "foo", lines[0]["table"])
+		assert.Equal(t, 3.0, lines[0]["worker"])
+		assert.Equal(t, "Sink is slow.", lines[1]["msg"])
+		assert.Equal(t, "CsvSink", lines[1]["sink"])
+		assert.Equal(t, "state", lines[2]["component"])
+	})
+}
+
+func TestLoggingLogfmt(t *testing.T) {
+	var logs bytes.Buffer
+	SetupLogging(&logs, Config{LogLevel: "warn", LogFormat: "logfmt"})
+	defer SetupLogging(os.Stdout, config)
+
+	logger.Info("Not logged.")
+	leaderLogger.Error("Can't renew the leader lease", "error", "timeout")
+	assert.Equal(t, 1, strings.Count(logs.String(), "\n"))
+	assert.Contains(t, logs.String(), `level=ERROR msg="Can't renew the leader lease" component=leader error=timeout`)
+}
+
+func TestPrintfLogger(t *testing.T) {
+	WithLogBuffer(nil, func(logs *bytes.Buffer) {
+		printfLogger(mysqlLogger)("Connected to %s:%d", "mysql", 3306)
+		lines := ParseJsonLogs(t, logs)
+		assert.Len(t, lines, 1)
+		assert.Equal(t, "Connected to mysql:3306", lines[0]["msg"])
+		assert.Equal(t, "mysql", lines[0]["component"])
+	})
+}
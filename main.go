@@ -2,9 +2,9 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -12,79 +12,230 @@ import (
 	"github.com/bugsnag/bugsnag-go"
 )
 
-// Thread-safe singletons shared throughout the entire app.
-var logger *log.Logger
+// Thread-safe singletons shared throughout the entire app. (The loggers are in logging.go.)
 var config Config
 var datadog *statsd.Client
+var metrics Metrics
 var UTC *time.Location
 var stateStorage StateStorage
 var pool IMysqlPool
-var snapshotter *Snapshotter
+var snapshotter atomic.Pointer[Snapshotter]  // Only set while the snapshot is running.
 var sinks []Sink
+var deadLetters *DeadLetterQueue
+var leader *LeaderElection
+var shutdown *ShutdownCoordinator
+var httpServer *HttpServer
 
 // Common code for initializing tests.
 func init() {
-	logger = log.New(os.Stdout, "", log.Ldate | log.Ltime)
 	config = NewConfig()
+	SetupLogging(os.Stdout, config)
+	metrics = NoopMetrics{}
 	// mysqlController = NewWorkerGroup()
 
 	var err error
 	UTC, err = time.LoadLocation("UTC")
 	if err != nil {
-		logger.Fatal(err)
+		fatal("Can't load the UTC time zone", "error", err)
 	}
 	stateStorage = NewStateStorage()
+	deadLetters = NewCustomDeadLetterQueue(LogDeadLetterSink{})
 }
 
 func main() {
+	if err := config.Validate(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
 	var err error
-  datadog, err = statsd.New(fmt.Sprintf("%s:%s", config.DatadogHost, config.DatadogPort))
-	if err != nil {
-		logger.Fatal(err)
+	httpServer = NewHttpServer(config.HttpAddress)
+	if config.MetricsBackend == "prometheus" {
+		prometheusMetrics := NewPrometheusMetrics()
+		httpServer.Mux.Handle("/metrics", prometheusMetrics.Handler())
+		metrics = prometheusMetrics
+	} else {
+		datadog, err = statsd.New(fmt.Sprintf("%s:%s", config.DatadogHost, config.DatadogPort))
+		if err != nil {
+			fatal("Can't create the Datadog client", "error", err)
+		}
+		metrics = NewDatadogMetrics(datadog)
 	}
+	stateStorage = NewMeasuredStateStorage(stateStorage)
 
 	bugsnag.Configure(bugsnag.Configuration{
 		APIKey:          config.BugsnagApiKey,
 		ReleaseStage:    config.BugsnagReleaseStage,
 		ProjectPackages: []string{"main"},
 		NotifyReleaseStages: []string{"production", "staging"},
+		Synchronous:     true,  // We only report errors we're about to exit over, so they have to get out first.
 	})
 
-	listenForSignals()
+	// Maintenance commands like "export-state" run instead of the exporter.
+	if len(os.Args) > 1 {
+		pool = NewMysqlPool()
+		os.Exit(RunStateCommand(os.Args[1:]))
+	}
+
+	RegisterStatusHandlers(httpServer.Mux)
+	RegisterAdminHandlers(httpServer.Mux)
+	setPhase(PHASE_STANDBY)
+	go func() {
+		if err := httpServer.Run(); err != nil {
+			httpLogger.Error("The HTTP server died", "error", err)
+		}
+	}()
+	defer httpServer.Exit()
+
+	leader = NewLeaderElection()
+	shutdown = NewShutdownCoordinator()
+	stopListening := listenForSignals()
+	defer stopListening()
+	if !leader.WaitForLeadership() {
+		logger.Info("Exited without ever becoming the leader.")
+		return
+	}
+	go leader.Run()
+	go func() {
+		<-leader.LostSignal()
+		gracefulShutdown("lost the leader lease, so the new leader can take over")
+	}()
+	defer leader.Exit()
+
+	setPhase(PHASE_STARTING)
+	deadLetters, err = NewDeadLetterQueue()
+	if err != nil {
+		leader.Exit()  // fatal() skips the deferred one, and the standby shouldn't have to wait out the lease.
+		fatal("Can't set up the dead-letter sink", "error", err)
+	}
+	sinks, err = BuildSinks()
+	if err != nil {
+		leader.Exit()
+		fatal("Can't create the sinks", "error", err)
+	}
+	if len(sinks) == 0 {
+		logger.Warn("No sinks are configured, so nothing will be written anywhere.")
+	}
 	pool = NewMysqlPool()
+	go reportPoolMetrics()
 
-	snapshotter = NewSnapshotter()
-	if snapshotter.Run() {
-		snapshotter = nil
+	s, err := NewSnapshotter()
+	if err != nil {
+		logger.Error("Can't start the snapshot", "error", err)
+		reportError(err)
+		leader.Exit()
+		os.Exit(1)
+	}
+	snapshotter.Store(s)
+	if shutdown.Requested() {
+		s.Exit()  // We were told to stop while the snapshotter was starting up.
+	}
+	setPhase(PHASE_SNAPSHOT)
+	failed := false
+	for {
+		if !s.Run() {
+			failed = !shutdown.Requested()  // Run() has already logged and reported the error.
+			break
+		}
+		snapshotter.Store(nil)
+		setPhase(PHASE_STREAMING)
 		// FIXME: Start binlog replay
+		if !waitForResnapshot() {
+			break
+		}
+
+		setPhase(PHASE_STARTING)
+		if s, err = NewResnapshotter(); err != nil {
+			logger.Error("Can't start the re-snapshot", "error", err)
+			reportError(err)
+			failed = true
+			break
+		}
+		snapshotter.Store(s)
+		if shutdown.Requested() {
+			s.Exit()
+		}
+		setPhase(PHASE_SNAPSHOT)
 	}
 
-	logger.Print("Exited.")
+	err = shutdown.Finish()
+	leader.Exit()
+	if err != nil {
+		logger.Error("Exited with errors", "error", err)
+		os.Exit(1)
+	} else if failed {
+		os.Exit(1)
+	}
+	logger.Info("Exited.")
 }
 
-// Sets up the signal handling: die gracefully on INT or TERM, panic on USR1.
-func listenForSignals() {
-	var terminateChannel chan os.Signal
-	var panicChannel chan os.Signal
+// Sets up the signal handling: die gracefully on INT or TERM (or immediately, on the second one), panic on USR1,
+// reload the config on HUP. Returns a function which stops listening.
+func listenForSignals() func() {
+	terminateChannel := make(chan os.Signal, 2)
+	panicChannel := make(chan os.Signal, 1)
+	reloadChannel := make(chan os.Signal, 1)
+	doneChannel := make(chan struct{})
 
 	signal.Notify(terminateChannel, syscall.SIGINT, syscall.SIGTERM)
 	signal.Notify(panicChannel, syscall.SIGUSR1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
 
 	go func() {
-		select {
-		case sig := <-terminateChannel:
-			logger.Printf("Received %s signal (%d).", sig.String(), sig)
-			gracefulShutdown()
+		for {
+			select {
+			case sig := <-terminateChannel:
+				logger.Info("Received a signal", "signal", sig.String())
+				if shutdown.Requested() {
+					logger.Warn("Already shutting down, so exiting immediately.")
+					shutdown.exit(1)
+				} else {
+					gracefulShutdown(fmt.Sprintf("received %s", sig.String()))
+				}
+
+			case <-reloadChannel:
+				logger.Info("Received SIGHUP. Reloading the configuration.")
+				reloadConfig()
 
-		case <-panicChannel:
-			panic("OH GOD WE'RE BONED LET'S FREAK OUT")   // For testing the panic behaviour.
+			case <-panicChannel:
+				panic("OH GOD WE'RE BONED LET'S FREAK OUT")   // For testing the panic behaviour.
+
+			case <-doneChannel:
+				return
+			}
 		}
 	}()
+
+	return func() {
+		signal.Stop(terminateChannel)
+		signal.Stop(panicChannel)
+		signal.Stop(reloadChannel)
+		close(doneChannel)
+	}
 }
 
-func gracefulShutdown() {
-	if snapshotter != nil {
-		snapshotter.Exit()
+// While the snapshot is running, it applies the new config itself, since it has to resize its workers and
+// add or remove tables. Otherwise, there's nothing running that needs to know about the change.
+func reloadConfig() {
+	if s := snapshotter.Load(); s != nil {
+		s.Reload()
+		return
+	}
+	newConfig, err := ReloadConfig(config.Copy())
+	if err != nil {
+		logger.Error("Not reloading the configuration, because it's invalid", "error", err)
+		return
 	}
-	// FIXME: Stop binlog replay
+	config.ApplyReload(newConfig)
+}
+
+func gracefulShutdown(reason string) {
+	shutdown.Shutdown(reason, func() {
+		if s := snapshotter.Load(); s != nil {
+			s.Exit()
+		} else if leader != nil {
+			// We may still be waiting on standby. (Otherwise, main() releases the lease once the work has stopped.)
+			leader.Exit()
+		}
+		// FIXME: Stop binlog replay
+	})
 }
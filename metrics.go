@@ -0,0 +1,148 @@
+// Metrics about what the exporter is doing. Tags are Datadog-style "key:value" strings, like "table:users".
+// The tests use NoopMetrics, so that they don't need a statsd agent.
+
+package main
+
+import (
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+const METRICS_PREFIX = "mysql_exporter."
+const POOL_METRICS_INTERVAL = 10 * time.Second
+
+var METRICS_BACKENDS = []string{"datadog", "prometheus"}
+
+type Metrics interface {
+	Count(name string, value int64, tags ...string)
+	Gauge(name string, value float64, tags ...string)
+	Timing(name string, duration time.Duration, tags ...string)
+}
+
+type NoopMetrics struct{}
+
+func (m NoopMetrics) Count(name string, value int64, tags ...string) {}
+func (m NoopMetrics) Gauge(name string, value float64, tags ...string) {}
+func (m NoopMetrics) Timing(name string, duration time.Duration, tags ...string) {}
+
+// Sends metrics to a Datadog agent. Statsd is fire-and-forget UDP, so we don't bother reporting errors.
+type DatadogMetrics struct {
+	Client *statsd.Client
+}
+
+func NewDatadogMetrics(client *statsd.Client) *DatadogMetrics {
+	return &DatadogMetrics{client}
+}
+
+func (m *DatadogMetrics) Count(name string, value int64, tags ...string) {
+	m.Client.Count(METRICS_PREFIX + name, value, tags, 1)
+}
+
+func (m *DatadogMetrics) Gauge(name string, value float64, tags ...string) {
+	m.Client.Gauge(METRICS_PREFIX + name, value, tags, 1)
+}
+
+func (m *DatadogMetrics) Timing(name string, duration time.Duration, tags ...string) {
+	m.Client.Timing(METRICS_PREFIX + name, duration, tags, 1)
+}
+
+// Reports how many MySQL connections we're using, forever. Call this in its own goroutine.
+func reportPoolMetrics() {
+	ticker := time.NewTicker(POOL_METRICS_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		stats := pool.Stats()
+		metrics.Gauge("mysql_pool.connections", float64(stats.TotalCount))
+		metrics.Gauge("mysql_pool.idle_connections", float64(stats.IdleCount))
+		metrics.Gauge("mysql_pool.created_connections", float64(stats.CreatedCount))
+	}
+}
+
+func tableTag(tableName string) string {
+	return "table:" + tableName
+}
+
+// Takes the sink's name from sinkName, like "sink:warehouse".
+func sinkTag(sinkName string) string {
+	return "sink:" + sinkName
+}
+
+// Wraps a StateStorage to time every call to it.
+type MeasuredStateStorage struct {
+	Storage StateStorage
+}
+
+func NewMeasuredStateStorage(storage StateStorage) *MeasuredStateStorage {
+	return &MeasuredStateStorage{storage}
+}
+
+func (mss *MeasuredStateStorage) measure(op string, start time.Time, err error) {
+	metrics.Timing("state_storage.time", time.Since(start), "op:" + op)
+	if err != nil {
+		metrics.Count("state_storage.errors", 1, "op:" + op)
+	}
+}
+
+func (mss *MeasuredStateStorage) Get(key string) (string, error) {
+	start := time.Now()
+	value, err := mss.Storage.Get(key)
+	mss.measure("get", start, err)
+	return value, err
+}
+
+func (mss *MeasuredStateStorage) Set(key string, val string) error {
+	start := time.Now()
+	err := mss.Storage.Set(key, val)
+	mss.measure("set", start, err)
+	return err
+}
+
+func (mss *MeasuredStateStorage) Delete(key string) error {
+	start := time.Now()
+	err := mss.Storage.Delete(key)
+	mss.measure("delete", start, err)
+	return err
+}
+
+func (mss *MeasuredStateStorage) ClearAll() error {
+	start := time.Now()
+	err := mss.Storage.ClearAll()
+	mss.measure("clear_all", start, err)
+	return err
+}
+
+func (mss *MeasuredStateStorage) Replace(keys []string, values map[string]string) error {
+	start := time.Now()
+	err := mss.Storage.Replace(keys, values)
+	mss.measure("replace", start, err)
+	return err
+}
+
+func (mss *MeasuredStateStorage) Keys(prefix string) ([]string, error) {
+	start := time.Now()
+	keys, err := mss.Storage.Keys(prefix)
+	mss.measure("keys", start, err)
+	return keys, err
+}
+
+func (mss *MeasuredStateStorage) AcquireLease(key, owner string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	acquired, err := mss.Storage.AcquireLease(key, owner, ttl)
+	mss.measure("acquire_lease", start, err)
+	return acquired, err
+}
+
+func (mss *MeasuredStateStorage) RenewLease(key, owner string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	renewed, err := mss.Storage.RenewLease(key, owner, ttl)
+	mss.measure("renew_lease", start, err)
+	return renewed, err
+}
+
+func (mss *MeasuredStateStorage) ReleaseLease(key, owner string) error {
+	start := time.Now()
+	err := mss.Storage.ReleaseLease(key, owner)
+	mss.measure("release_lease", start, err)
+	return err
+}
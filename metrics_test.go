@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotterMetrics(t *testing.T) {
+	sinks = []Sink{&FakeSink{}}
+	defer func() { sinks = nil }()
+
+	SetFakeResponses(
+		FakeMysqlResponse{false, math.MaxInt, []string{"id"}, [][]any{{uint64(1)}, {uint64(2)}}},
+	)
+	WithRecordingMetrics(func(recorder *RecordingMetrics) {
+		WithConfig("SNAPSHOT_CHUNK_SIZE", "100", func() {
+			snapshotter := NewCustomSnapshotter(NewFakeSnapshotState([]string{"foo"}, 1000))
+			assert.True(t, snapshotter.Run())
+			snapshotter.reportMetrics()
+		})
+
+		assert.Equal(t, int64(20), recorder.Counts["snapshot.rows|table:foo"])
+		assert.Equal(t, int64(10), recorder.Counts["snapshot.intervals_completed|table:foo"])
+		assert.Equal(t, 10, recorder.Timings["snapshot.chunk_query_time|table:foo"])
+		assert.Equal(t, 10, recorder.Timings["sink.write_time|table:foo|sink:FakeSink"])
+		assert.Zero(t, recorder.Counts["snapshot.chunk_query_retries|table:foo"])
+		assert.Contains(t, recorder.Gauges, "snapshot.workers")
+	})
+}
+
+func TestSnapshotterMetricsForRemovedTables(t *testing.T) {
+	sinks = []Sink{&FakeSink{}}
+	defer func() { sinks = nil }()
+
+	WithRecordingMetrics(func(recorder *RecordingMetrics) {
+		WithConfig("SNAPSHOT_CHUNK_SIZE", "100", func() {
+			snapshotter := NewCustomSnapshotter(NewFakeSnapshotState([]string{"foo", "bar"}, 1000))
+			snapshotter.Sinks = NewSinkManager(sinks)
+			snapshotter.busyIntervals["foo"] = 2
+			snapshotter.reportMetrics()
+			assert.Equal(t, 10.0, recorder.Gauges["snapshot.intervals_pending|table:foo"])
+			assert.Equal(t, 2.0, recorder.Gauges["snapshot.intervals_busy|table:foo"])
+			assert.Equal(t, 0.0, recorder.Gauges["snapshot.intervals_busy|table:bar"])
+
+			snapshotter.State.RemoveTable("foo")
+			delete(snapshotter.busyIntervals, "foo")
+			snapshotter.reportMetrics()
+			assert.Equal(t, 0.0, recorder.Gauges["snapshot.intervals_pending|table:foo"])
+			assert.Equal(t, 0.0, recorder.Gauges["snapshot.intervals_busy|table:foo"])
+			assert.Equal(t, 10.0, recorder.Gauges["snapshot.intervals_pending|table:bar"])
+		})
+	})
+}
+
+func TestMeasuredStateStorage(t *testing.T) {
+	WithRecordingMetrics(func(recorder *RecordingMetrics) {
+		storage := NewMeasuredStateStorage(NewStateStorageMemory())
+		assert.NoError(t, storage.Set("foo", "bar"))
+		value, err := storage.Get("foo")
+		assert.NoError(t, err)
+		assert.Equal(t, "bar", value)
+		acquired, err := storage.AcquireLease("lease", "me", time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		assert.Equal(t, 1, recorder.Timings["state_storage.time|op:set"])
+		assert.Equal(t, 1, recorder.Timings["state_storage.time|op:get"])
+		assert.Equal(t, 1, recorder.Timings["state_storage.time|op:acquire_lease"])
+		assert.Empty(t, recorder.Counts)
+	})
+}
+
+func TestSinkTag(t *testing.T) {
+	assert.Equal(t, "sink:CsvSink", sinkTag(sinkName(NewTestCsvSink(t))))
+}
@@ -18,6 +18,7 @@ type IMysqlPool interface {
 	Execute(query string, args ...interface{}) (IMysqlResult, error)
 	GetConn(ctx context.Context) (IMysqlClient, error)
 	PutConn(conn IMysqlClient)
+	Stats() client.ConnectionStats
 }
 
 type IMysqlClient interface {
@@ -59,6 +60,12 @@ func (pw PoolWrapper) PutConn(conn IMysqlClient) {
 	pw.pool.PutConn(conn.(ClientWrapper).conn)
 }
 
+func (pw PoolWrapper) Stats() client.ConnectionStats {
+	var stats client.ConnectionStats
+	pw.pool.GetStats(&stats)
+	return stats
+}
+
 // This tiny wrapper is just to make client.Conn conform to the IMysqlClient interface.
 type ClientWrapper struct {
 	conn *client.Conn
@@ -80,7 +87,7 @@ func NewMysqlPool() IMysqlPool {
 	hostport := fmt.Sprintf("%s:%s", config.MysqlHost, config.MysqlPort)
 	return PoolWrapper{
 		client.NewPool(
-			logger.Printf, MIN_MYSQL_CONNS, config.MaxMysqlConns, MIN_MYSQL_CONNS,
+			printfLogger(mysqlLogger), MIN_MYSQL_CONNS, config.MaxMysqlConns, MIN_MYSQL_CONNS,
 			hostport, config.MysqlUser, config.MysqlPassword, config.MysqlDatabase,
 		),
 	}
@@ -90,31 +97,46 @@ func NewMysqlPool() IMysqlPool {
 // containing the executed GTID set, and an error if one occurred. The uint64 will be a combination
 // of the binary log filename and position, guaranteed to monotonically increase.
 func GetBinlogPosition() (uint64, string, error) {
+	coords, err := GetBinlogCoordinates()
+	if err != nil {
+		return 0, "", err
+	}
+	return ParseBinlogPosition(coords.File, coords.Pos), coords.GtidSet, nil
+}
+
+// Where the server is in its binary logs, as MySQL describes it.
+type BinlogCoordinates struct {
+	File string
+	Pos int64
+	GtidSet string
+}
+
+func GetBinlogCoordinates() (BinlogCoordinates, error) {
 	_, err := pool.Execute("FLUSH TABLES WITH READ LOCK")
 	if err != nil {
-		return 0, "", fmt.Errorf("Can't execute FLUSH TABLES: %s", err)
+		return BinlogCoordinates{}, fmt.Errorf("Can't execute FLUSH TABLES: %s", err)
 	}
 	defer func() { pool.Execute("UNLOCK TABLES") }()
 
 	rows, err := pool.Execute("SHOW MASTER STATUS")
 	if err != nil {
-		return 0, "", fmt.Errorf("Can't execute SHOW MASTER STATUS: %s", err)
+		return BinlogCoordinates{}, fmt.Errorf("Can't execute SHOW MASTER STATUS: %s", err)
 	}
 
 	file, err := rows.GetString(0, 0)
 	if err != nil {
-		return 0, "", fmt.Errorf("Can't retrieve filename from SHOW MASTER STATUS: %s", err)
+		return BinlogCoordinates{}, fmt.Errorf("Can't retrieve filename from SHOW MASTER STATUS: %s", err)
 	}
 	pos, err := rows.GetInt(0, 1)
 	if err != nil {
-		return 0, "", fmt.Errorf("Can't retrieve position from SHOW MASTER STATUS: %s", err)
+		return BinlogCoordinates{}, fmt.Errorf("Can't retrieve position from SHOW MASTER STATUS: %s", err)
 	}
 	gtidset, err := rows.GetString(0, 4)
 	if err != nil {
-		return 0, "", fmt.Errorf("Can't retrieve gtid_executed from SHOW MASTER STATUS: %s", err)
+		return BinlogCoordinates{}, fmt.Errorf("Can't retrieve gtid_executed from SHOW MASTER STATUS: %s", err)
 	}
 
-	return ParseBinlogPosition(file, pos), gtidset, nil
+	return BinlogCoordinates{file, pos, gtidset}, nil
 }
 
 // The "binlog position" is the binary log's index number in the high 24 bits and the position
@@ -156,7 +178,7 @@ func ListTables() ([]string, error) {
 	}
 
 	for i := 0; i < len(tables); i++ {
-		if StringInList(tables[i], config.ExcludeTables) {
+		if config.IsExcluded(tables[i]) {
 			tables = DeleteFromSlice(tables, i)
 			i--
 		}
@@ -177,11 +199,30 @@ func RefreshTableSchema(tableName string) (*TableSchema, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Can't fetch CREATE TABLE column: %s", err)
 	}
-	schema := ParseSchema(createTable)
+	schema, err := AddSyntheticColumns(config.FilterColumns(ParseSchema(createTable)), config.SyntheticColumnDefs)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkRowFilter(tableName); err != nil {
+		return nil, err
+	}
 	tableSchemaCache[tableName] = schema
 	return schema, nil
 }
 
+// The row_filter is pasted into our queries as it is, so it's trusted SQL from whoever wrote the config file.
+// This just makes sure MySQL can make sense of it before we start, rather than failing every chunk.
+func checkRowFilter(tableName string) error {
+	filter := config.RowFilter(tableName)
+	if filter == "" {
+		return nil
+	}
+	if _, err := pool.Execute("EXPLAIN SELECT `id` FROM `" + tableName + "` WHERE (" + filter + ")"); err != nil {
+		return fmt.Errorf("Table '%s' has a row_filter that MySQL won't accept: %s", tableName, err)
+	}
+	return nil
+}
+
 func GetTableSchema(tableName string) (*TableSchema, error) {
 	var err error
 	schema, ok := tableSchemaCache[tableName]
@@ -98,3 +98,23 @@ func TestGetTableSchema(t *testing.T) {
 	assert.Equal(t, "id", schema.Columns[0].Name)
 	assert.Equal(t, "account_id", schema.Columns[8].Name)
 }
+
+func TestGetTableSchemaChecksRowFilter(t *testing.T) {
+	oldTables := config.Tables
+	defer func() { config.Tables = oldTables }()
+	config.Tables = map[string]TableConfig{"users": {RowFilter: "deleted_at IS NULL"}}
+	showCreateTable := FakeMysqlResponse{
+		false, 0,
+		[]string{"Table", "Create Table"},
+		[][]any{{"users", "CREATE TABLE `users` (\n`id` bigint unsigned NOT NULL\n)"}},
+	}
+
+	SetFakeResponses(showCreateTable, FakeMysqlResponse{true, 0, nil, [][]any{{"Unknown column 'deleted_at' in 'where clause'"}}})
+	_, err := RefreshTableSchema("users")
+	assert.ErrorContains(t, err, "Table 'users' has a row_filter that MySQL won't accept: Unknown column 'deleted_at'")
+
+	SetFakeResponses(showCreateTable, FakeMysqlResponse{false, 0, []string{"id"}, [][]any{}})
+	schema, err := RefreshTableSchema("users")
+	assert.NoError(t, err)
+	assert.Equal(t, "users", schema.Name)
+}
@@ -0,0 +1,417 @@
+// Keeps a copy of each table in PostgreSQL, with the same columns and the current version of every row. Snapshot
+// rows are loaded with COPY, and everything is upserted on the primary key, so it's fine to write the same rows twice.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func init() {
+	RegisterSinkType("postgres", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, "url", "schema"); err != nil {
+			return nil, err
+		}
+		if params["url"] == "" {
+			return nil, fmt.Errorf("Missing parameter: url")
+		}
+		schema := params["schema"]
+		if schema == "" {
+			schema = "public"
+		}
+		return NewPostgresSink(params["url"], schema)
+	})
+}
+
+type PostgresSink struct {
+	Lock sync.Mutex
+	Pool *pgxpool.Pool
+	Schema string  // The Postgres schema that the tables go in, not a TableSchema.
+	Tables map[string]*TableSchema
+}
+
+// Doesn't connect until the first table is opened.
+func NewPostgresSink(url, schema string) (*PostgresSink, error) {
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresSink{sync.Mutex{}, pool, schema, make(map[string]*TableSchema)}, nil
+}
+
+// Creates the table if it isn't there yet. If it is, we assume it's the one we made last time.
+func (sink *PostgresSink) Open(ts *TableSchema) error {
+	ddl, err := PostgresCreateTable(sink.Schema, ts)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if _, err = sink.Pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS " + pgx.Identifier{sink.Schema}.Sanitize()); err != nil {
+		return err
+	}
+	if _, err = sink.Pool.Exec(ctx, ddl); err != nil {
+		return fmt.Errorf("Can't create table '%s': %w", ts.Name, err)
+	}
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Tables[ts.Name] = ts
+	return nil
+}
+
+func (sink *PostgresSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	delete(sink.Tables, ts.Name)
+	return nil
+}
+
+func (sink *PostgresSink) WriteRows(rows RowsEvent) {
+	if rows.Source.Snapshot {
+		rows.ResponseChan <- sink.copyRows(rows)
+	} else {
+		rows.ResponseChan <- sink.upsertRows(rows)
+	}
+}
+
+// COPYs the rows into a temporary table, then upserts them from there into the real one, all in one transaction.
+func (sink *PostgresSink) copyRows(rows RowsEvent) error {
+	values, err := postgresRows(rows)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := sink.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	table := sink.table(rows.Schema.Name)
+	stage := pgx.Identifier{"stage_" + rows.Schema.Name}
+	_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s (LIKE %s) ON COMMIT DROP", stage.Sanitize(), table.Sanitize()))
+	if err != nil {
+		return err
+	}
+	if _, err = tx.CopyFrom(ctx, stage, postgresColumnNames(rows.Schema), pgx.CopyFromRows(values)); err != nil {
+		return err
+	}
+	columns := postgresColumnList(rows.Schema)
+	insert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", table.Sanitize(), columns, columns, stage.Sanitize())
+	if _, err = tx.Exec(ctx, insert + postgresOnConflict(rows.Schema)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Rows from the binlog are upserted one at a time, in order, in one round trip.
+func (sink *PostgresSink) upsertRows(rows RowsEvent) error {
+	values, err := postgresRows(rows)
+	if err != nil {
+		return err
+	}
+	placeholders := make([]string, len(rows.Schema.Columns))
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i + 1)
+	}
+	upsert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", sink.table(rows.Schema.Name).Sanitize(),
+		postgresColumnList(rows.Schema), strings.Join(placeholders, ", ")) + postgresOnConflict(rows.Schema)
+
+	batch := &pgx.Batch{}
+	for _, row := range values {
+		batch.Queue(upsert, row...)
+	}
+	return sink.Pool.SendBatch(context.Background(), batch).Close()
+}
+
+// Deletes rows by their primary keys. Nothing calls this yet; it's for deletes from the binlog, once we're
+// streaming it.
+func (sink *PostgresSink) DeleteRows(ts *TableSchema, keys []any) error {
+	pk := ts.PrimaryKeyIndex()
+	if pk < 0 {
+		return fmt.Errorf("Can't delete rows from table '%s', which has no primary key", ts.Name)
+	}
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		value, err := postgresValue(key, ts.Columns[pk])
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s = ANY($1)", sink.table(ts.Name).Sanitize(), pgx.Identifier{ts.Columns[pk].Name}.Sanitize())
+	_, err := sink.Pool.Exec(context.Background(), sql, values)
+	return err
+}
+
+// Alters the table to match the new schema, if Postgres can convert the existing data.
+func (sink *PostgresSink) SchemaChange(newSchema *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	oldSchema, ok := sink.Tables[newSchema.Name]
+	if !ok {
+		return fmt.Errorf("Can't change the schema of table '%s', which was never opened", newSchema.Name)
+	}
+	statements, err := PostgresAlterTable(sink.Schema, oldSchema, newSchema)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := sink.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, statement := range statements {
+		if _, err = tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("Can't alter table '%s': %w", newSchema.Name, err)
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	sink.Tables[newSchema.Name] = newSchema
+	return nil
+}
+
+func (sink *PostgresSink) Exit() error {
+	sink.Pool.Close()
+	return nil
+}
+
+func (sink *PostgresSink) table(tableName string) pgx.Identifier {
+	return pgx.Identifier{sink.Schema, tableName}
+}
+
+func PostgresCreateTable(pgSchema string, ts *TableSchema) (string, error) {
+	lines := []string{}
+	for _, column := range ts.Columns {
+		pgType, err := postgresType(column)
+		if err != nil {
+			return "", err
+		}
+		line := pgx.Identifier{column.Name}.Sanitize() + " " + pgType
+		if !column.Nullable {
+			line += " NOT NULL"
+		}
+		lines = append(lines, line)
+	}
+	if pk := ts.PrimaryKeyIndex(); pk >= 0 {
+		lines = append(lines, "PRIMARY KEY (" + pgx.Identifier{ts.Columns[pk].Name}.Sanitize() + ")")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)", pgx.Identifier{pgSchema, ts.Name}.Sanitize(), strings.Join(lines, ",\n  ")), nil
+}
+
+// The statements that turn the old table into the new one. New columns are always nullable, since the rows that
+// are already there don't have values for them.
+func PostgresAlterTable(pgSchema string, oldSchema, newSchema *TableSchema) ([]string, error) {
+	table := pgx.Identifier{pgSchema, newSchema.Name}.Sanitize()
+	oldColumns := map[string]Column{}
+	for _, column := range oldSchema.Columns {
+		oldColumns[column.Name] = column
+	}
+
+	statements := []string{}
+	for _, column := range newSchema.Columns {
+		name := pgx.Identifier{column.Name}.Sanitize()
+		newType, err := postgresType(column)
+		if err != nil {
+			return nil, err
+		}
+		oldColumn, existed := oldColumns[column.Name]
+		delete(oldColumns, column.Name)
+		if !existed {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, newType))
+			continue
+		}
+
+		oldType, _ := postgresType(oldColumn)
+		if oldType != newType {
+			if !postgresWidens(oldType, newType) {
+				return nil, fmt.Errorf("Can't change column '%s' of table '%s' from %s to %s", column.Name, newSchema.Name, oldType, newType)
+			}
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", table, name, newType, name, newType))
+		}
+		if oldColumn.Nullable && !column.Nullable {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", table, name))
+		} else if !oldColumn.Nullable && column.Nullable {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL", table, name))
+		}
+	}
+	for _, column := range oldSchema.Columns {
+		if _, dropped := oldColumns[column.Name]; dropped {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, pgx.Identifier{column.Name}.Sanitize()))
+		}
+	}
+	return statements, nil
+}
+
+func postgresType(column Column) (string, error) {
+	switch column.SqlType {
+	case "tinyint":
+		return "smallint", nil
+	case "smallint":
+		if column.Signed {
+			return "smallint", nil
+		}
+		return "integer", nil
+	case "mediumint":
+		return "integer", nil
+	case "int":
+		if column.Signed {
+			return "integer", nil
+		}
+		return "bigint", nil
+	case "bigint":
+		if column.Signed {
+			return "bigint", nil
+		}
+		return "numeric(20,0)", nil
+	case "float":
+		return "real", nil
+	case "double":
+		return "double precision", nil
+	case "decimal":
+		return fmt.Sprintf("numeric(%d,%d)", column.Width, column.Scale), nil
+	case "char", "varchar":
+		return fmt.Sprintf("varchar(%d)", column.Width), nil
+	case "text", "mediumtext", "longtext":
+		return "text", nil
+	case "binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob":
+		return "bytea", nil
+	case "date":
+		return "date", nil
+	case "time":
+		return "time", nil
+	case "datetime":
+		return fmt.Sprintf("timestamp(%d)", column.Width), nil
+	case "timestamp":
+		return fmt.Sprintf("timestamptz(%d)", column.Width), nil
+	default:
+		return "", fmt.Errorf("Can't convert SQL type '%s' of column '%s' to Postgres", column.SqlType, column.Name)
+	}
+}
+
+// Whether every value of oldType fits in newType unchanged, so the ALTER can't fail halfway or round anything.
+func postgresWidens(oldType, newType string) bool {
+	var oldWidth, oldScale, newWidth, newScale int
+	integerDigits := map[string]int{"smallint": 5, "integer": 10, "bigint": 19}
+	if oldDigits, ok := integerDigits[oldType]; ok {
+		if newDigits, ok := integerDigits[newType]; ok {
+			return newDigits >= oldDigits
+		}
+		_, err := fmt.Sscanf(newType, "numeric(%d,%d)", &newWidth, &newScale)
+		return err == nil && newWidth-newScale >= oldDigits
+	}
+	if _, err := fmt.Sscanf(oldType, "numeric(%d,%d)", &oldWidth, &oldScale); err == nil {
+		_, err = fmt.Sscanf(newType, "numeric(%d,%d)", &newWidth, &newScale)
+		return err == nil && newScale >= oldScale && newWidth-newScale >= oldWidth-oldScale
+	}
+	if _, err := fmt.Sscanf(oldType, "varchar(%d)", &oldWidth); err == nil {
+		if newType == "text" {
+			return true
+		}
+		_, err = fmt.Sscanf(newType, "varchar(%d)", &newWidth)
+		return err == nil && newWidth >= oldWidth
+	}
+	for _, kind := range []string{"timestamp", "timestamptz"} {
+		if _, err := fmt.Sscanf(oldType, kind+"(%d)", &oldWidth); err == nil {
+			_, err = fmt.Sscanf(newType, kind+"(%d)", &newWidth)
+			return err == nil && newWidth >= oldWidth
+		}
+	}
+	return oldType == "real" && newType == "double precision"
+}
+
+func postgresColumnNames(ts *TableSchema) []string {
+	names := make([]string, len(ts.Columns))
+	for i, column := range ts.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+func postgresColumnList(ts *TableSchema) string {
+	names := make([]string, len(ts.Columns))
+	for i, column := range ts.Columns {
+		names[i] = pgx.Identifier{column.Name}.Sanitize()
+	}
+	return strings.Join(names, ", ")
+}
+
+// Turns an insert into an upsert, if the table has a primary key.
+func postgresOnConflict(ts *TableSchema) string {
+	pk := ts.PrimaryKeyIndex()
+	if pk < 0 {
+		return ""
+	}
+	updates := []string{}
+	for i, column := range ts.Columns {
+		if i != pk {
+			name := pgx.Identifier{column.Name}.Sanitize()
+			updates = append(updates, name + " = EXCLUDED." + name)
+		}
+	}
+	conflict := " ON CONFLICT (" + pgx.Identifier{ts.Columns[pk].Name}.Sanitize() + ") DO "
+	if len(updates) == 0 {
+		return conflict + "NOTHING"
+	}
+	return conflict + "UPDATE SET " + strings.Join(updates, ", ")
+}
+
+func postgresRows(rows RowsEvent) ([][]any, error) {
+	values := make([][]any, len(rows.Data))
+	for r, row := range rows.Data {
+		values[r] = make([]any, len(rows.Schema.Columns))
+		for i, column := range rows.Schema.Columns {
+			value, err := postgresValue(row[i], column)
+			if err != nil {
+				return nil, err
+			}
+			values[r][i] = value
+		}
+	}
+	return values, nil
+}
+
+// Converts a value to something pgx knows how to send as the column's Postgres type.
+func postgresValue(datum any, column Column) (any, error) {
+	switch datum.(type) {
+	case uint64:
+		if column.SqlType == "bigint" && !column.Signed {
+			return pgtype.Numeric{Int: new(big.Int).SetUint64(datum.(uint64)), Valid: true}, nil
+		}
+		if datum.(uint64) > math.MaxInt64 {
+			return nil, fmt.Errorf("Value %d of column '%s' is too big for Postgres", datum.(uint64), column.Name)
+		}
+		return int64(datum.(uint64)), nil
+
+	case time.Time:
+		if column.SqlType == "time" {
+			t := datum.(time.Time)
+			midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+			return pgtype.Time{Microseconds: t.Sub(midnight).Microseconds(), Valid: true}, nil
+		}
+		return datum, nil
+
+	case *big.Rat:
+		unscaled, err := UnscaledDecimal(datum.(*big.Rat), column)
+		if err != nil {
+			return nil, fmt.Errorf("Can't convert column '%s': %w", column.Name, err)
+		}
+		return pgtype.Numeric{Int: unscaled, Exp: int32(-column.Scale), Valid: true}, nil
+
+	default:
+		return datum, nil
+	}
+}
@@ -0,0 +1,125 @@
+// Exposes our metrics in Prometheus's text format at /metrics, for the parts of the fleet that scrape
+// Prometheus instead of running a Datadog agent. Metric names are the same as for Datadog, with the dots
+// turned into underscores; tags become labels.
+
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type PrometheusMetrics struct {
+	Registry *prometheus.Registry
+	lock sync.Mutex
+	counters map[string]*prometheus.CounterVec
+	gauges map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return &PrometheusMetrics{
+		registry,
+		sync.Mutex{},
+		map[string]*prometheus.CounterVec{},
+		map[string]*prometheus.GaugeVec{},
+		map[string]*prometheus.HistogramVec{},
+	}
+}
+
+func (m *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+func (m *PrometheusMetrics) Count(name string, value int64, tags ...string) {
+	labelNames, labels := prometheusLabels(tags)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	counter, ok := m.counters[name]
+	if !ok {
+		counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: prometheusName(name) + "_total"}, labelNames)
+		if !m.register(name, counter) {
+			return
+		}
+		m.counters[name] = counter
+	}
+	if c, err := counter.GetMetricWith(labels); err == nil {
+		c.Add(float64(value))
+	}
+}
+
+func (m *PrometheusMetrics) Gauge(name string, value float64, tags ...string) {
+	labelNames, labels := prometheusLabels(tags)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	gauge, ok := m.gauges[name]
+	if !ok {
+		gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: prometheusName(name)}, labelNames)
+		if !m.register(name, gauge) {
+			return
+		}
+		m.gauges[name] = gauge
+	}
+	if g, err := gauge.GetMetricWith(labels); err == nil {
+		g.Set(value)
+	}
+}
+
+func (m *PrometheusMetrics) Timing(name string, duration time.Duration, tags ...string) {
+	labelNames, labels := prometheusLabels(tags)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	histogram, ok := m.histograms[name]
+	if !ok {
+		histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: prometheusName(name) + "_seconds",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),  // 1ms to about 4.5 minutes
+		}, labelNames)
+		if !m.register(name, histogram) {
+			return
+		}
+		m.histograms[name] = histogram
+	}
+	if h, err := histogram.GetMetricWith(labels); err == nil {
+		h.Observe(duration.Seconds())
+	}
+}
+
+// A metric that can't be registered is a bug, but not one worth crashing over.
+func (m *PrometheusMetrics) register(name string, collector prometheus.Collector) bool {
+	if err := m.Registry.Register(collector); err != nil {
+		logger.Error("Can't register Prometheus metric", "metric", name, "error", err)
+		return false
+	}
+	return true
+}
+
+func prometheusName(name string) string {
+	return strings.ReplaceAll(METRICS_PREFIX + name, ".", "_")
+}
+
+// Turns Datadog-style "key:value" tags into Prometheus labels. Every call for a given metric has to use the
+// same tag keys, since Prometheus needs to know the label names up front.
+func prometheusLabels(tags []string) ([]string, prometheus.Labels) {
+	names := make([]string, 0, len(tags))
+	labels := make(prometheus.Labels, len(tags))
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		names = append(names, key)
+		labels[key] = value
+	}
+	slices.Sort(names)
+	return names, labels
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Count("snapshot.rows", 3, tableTag("foo"))
+	m.Count("snapshot.rows", 4, tableTag("foo"))
+	m.Count("snapshot.rows", 5, tableTag("bar"))
+	m.Gauge("snapshot.workers", 10)
+	m.Timing("state_storage.time", 2 * time.Millisecond, "op:get")
+
+	// A metric whose tags don't match the first call's is dropped instead of crashing.
+	m.Count("snapshot.rows", 1, tableTag("foo"), "sink:CsvSink")
+
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	response, err := server.Client().Get(server.URL)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+
+	text := string(body)
+	assert.Contains(t, text, `mysql_exporter_snapshot_rows_total{table="foo"} 7`)
+	assert.Contains(t, text, `mysql_exporter_snapshot_rows_total{table="bar"} 5`)
+	assert.Contains(t, text, "mysql_exporter_snapshot_workers 10")
+	assert.Contains(t, text, `mysql_exporter_state_storage_time_seconds_count{op="get"} 1`)
+	assert.Contains(t, text, `mysql_exporter_state_storage_time_seconds_bucket{op="get",le="0.004"} 1`)
+	assert.Contains(t, text, "go_goroutines")
+}
+
+func TestPrometheusLabels(t *testing.T) {
+	names, labels := prometheusLabels([]string{"table:foo", "op:get"})
+	assert.Equal(t, []string{"op", "table"}, names)
+	assert.Equal(t, "foo", labels["table"])
+	assert.Equal(t, "get", labels["op"])
+}
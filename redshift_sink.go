@@ -0,0 +1,365 @@
+// Loads tables into Redshift the way Redshift likes: each batch of rows is staged as a file through the uploader
+// (which has to be somewhere Redshift can read, like S3), with a COPY manifest listing it. Then, in one transaction,
+// we COPY the batch into a temporary staging table and MERGE it into the real one on the primary key. The staged
+// files are named after what's in them and MERGE overwrites rows that are already there, so a batch that's written
+// twice ends up the same as one that's written once.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Marks rows that should be deleted from the target table, like Debezium's unwrapped records do.
+const REDSHIFT_DELETED_COLUMN = "__deleted"
+
+func init() {
+	RegisterSinkType("redshift", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, "url", "schema", "upload", "iam_role", "directory"); err != nil {
+			return nil, err
+		}
+		for _, required := range []string{"url", "upload"} {
+			if params[required] == "" {
+				return nil, fmt.Errorf("Missing parameter: %s", required)
+			}
+		}
+		uploader, err := NewUploader(params["upload"])
+		if err != nil {
+			return nil, err
+		}
+		schema, directory := params["schema"], params["directory"]
+		if schema == "" {
+			schema = "public"
+		}
+		if directory == "" {
+			directory = os.TempDir()
+		}
+		return NewRedshiftSink(params["url"], schema, uploader, params["iam_role"], directory)
+	})
+}
+
+type RedshiftSink struct {
+	Lock sync.Mutex
+	Pool *pgxpool.Pool
+	Schema string       // The Redshift schema that the tables go in.
+	Uploader Uploader
+	IamRole string      // The role that COPY uses to read the staged files. Empty means the cluster's default role.
+	Directory string    // Where the staged files are written before they're uploaded.
+	Tables map[string]*TableSchema
+}
+
+// Redshift doesn't understand everything that pgx's default query mode does, so we stick to simple queries.
+// Doesn't connect until the first table is opened.
+func NewRedshiftSink(url, schema string, uploader Uploader, iamRole, directory string) (*RedshiftSink, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &RedshiftSink{sync.Mutex{}, pool, schema, uploader, iamRole, directory, make(map[string]*TableSchema)}, nil
+}
+
+func (sink *RedshiftSink) Open(ts *TableSchema) error {
+	if ts.PrimaryKeyIndex() < 0 {
+		return fmt.Errorf("Table '%s' has no primary key, so it can't be merged into Redshift", ts.Name)
+	}
+	ddl, err := RedshiftCreateTable(sink.Schema, ts)
+	if err != nil {
+		return err
+	}
+	if _, err = sink.Pool.Exec(context.Background(), ddl); err != nil {
+		return fmt.Errorf("Can't create table '%s': %w", ts.Name, err)
+	}
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Tables[ts.Name] = ts
+	return nil
+}
+
+func (sink *RedshiftSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	delete(sink.Tables, ts.Name)
+	return nil
+}
+
+func (sink *RedshiftSink) WriteRows(rows RowsEvent) {
+	rows.ResponseChan <- sink.load(rows)
+}
+
+func (sink *RedshiftSink) load(rows RowsEvent) error {
+	if len(rows.Data) == 0 {
+		return nil
+	}
+	data, err := redshiftJsonLines(rows)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	name := rows.Schema.Name + "/" + rows.Schema.Name + "." + hex.EncodeToString(sum[:16])
+	if err = sink.stage(name + ".json", data); err != nil {
+		return err
+	}
+	manifest, err := json.Marshal(map[string]any{
+		"entries": []map[string]any{{"url": sink.Uploader.Location(name + ".json"), "mandatory": true}},
+	})
+	if err != nil {
+		return err
+	}
+	if err = sink.stage(name + ".manifest", manifest); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tx, err := sink.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	for _, statement := range RedshiftMergeStatements(sink.Schema, rows.Schema, sink.Uploader.Location(name + ".manifest"), sink.IamRole) {
+		if _, err = tx.Exec(ctx, statement); err != nil {
+			return fmt.Errorf("Can't load batch %s into table '%s': %w", name, rows.Schema.Name, err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Writes the data to a local file, uploads it, and removes the local copy.
+func (sink *RedshiftSink) stage(name string, data []byte) error {
+	if err := os.MkdirAll(sink.Directory, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(sink.Directory, "redshift-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return sink.Uploader.Upload(file.Name(), name)
+}
+
+// Adds new columns and drops old ones. Redshift can't change most column types, so we don't try.
+func (sink *RedshiftSink) SchemaChange(newSchema *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	oldSchema, ok := sink.Tables[newSchema.Name]
+	if !ok {
+		return fmt.Errorf("Can't change the schema of table '%s', which was never opened", newSchema.Name)
+	}
+	table := pgx.Identifier{sink.Schema, newSchema.Name}.Sanitize()
+	oldColumns := map[string]bool{}
+	for _, column := range oldSchema.Columns {
+		oldColumns[column.Name] = true
+	}
+
+	statements := []string{}
+	for _, column := range newSchema.Columns {
+		if oldColumns[column.Name] {
+			delete(oldColumns, column.Name)
+			continue
+		}
+		rsType, err := redshiftType(column)
+		if err != nil {
+			return err
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, pgx.Identifier{column.Name}.Sanitize(), rsType))
+	}
+	for _, column := range oldSchema.Columns {
+		if oldColumns[column.Name] {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, pgx.Identifier{column.Name}.Sanitize()))
+		}
+	}
+
+	// Redshift won't run some kinds of ALTER TABLE inside a transaction block, so these go one at a time.
+	for _, statement := range statements {
+		if _, err := sink.Pool.Exec(context.Background(), statement); err != nil {
+			return fmt.Errorf("Can't alter table '%s': %w", newSchema.Name, err)
+		}
+	}
+	sink.Tables[newSchema.Name] = newSchema
+	return nil
+}
+
+func (sink *RedshiftSink) Exit() error {
+	sink.Pool.Close()
+	return nil
+}
+
+func RedshiftCreateTable(rsSchema string, ts *TableSchema) (string, error) {
+	columns, err := redshiftColumnDefinitions(ts)
+	if err != nil {
+		return "", err
+	}
+	pk := pgx.Identifier{ts.Columns[ts.PrimaryKeyIndex()].Name}.Sanitize()
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s,\n  PRIMARY KEY (%s)\n)",
+		pgx.Identifier{rsSchema, ts.Name}.Sanitize(), strings.Join(columns, ",\n  "), pk), nil
+}
+
+func redshiftColumnDefinitions(ts *TableSchema) ([]string, error) {
+	columns := make([]string, len(ts.Columns))
+	for i, column := range ts.Columns {
+		rsType, err := redshiftType(column)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = pgx.Identifier{column.Name}.Sanitize() + " " + rsType
+		if !column.Nullable {
+			columns[i] += " NOT NULL"
+		}
+	}
+	return columns, nil
+}
+
+// Loads one staged batch: COPY it into a staging table, apply the deletes, then MERGE the rest.
+func RedshiftMergeStatements(rsSchema string, ts *TableSchema, manifestLocation, iamRole string) []string {
+	target := pgx.Identifier{rsSchema, ts.Name}.Sanitize()
+	stage := pgx.Identifier{"stage_" + ts.Name}.Sanitize()
+	deleted := pgx.Identifier{REDSHIFT_DELETED_COLUMN}.Sanitize()
+	pk := pgx.Identifier{ts.Columns[ts.PrimaryKeyIndex()].Name}.Sanitize()
+	role := "default"
+	if iamRole != "" {
+		role = quoteRedshiftString(iamRole)
+	}
+
+	// The column definitions were already checked when the table was opened.
+	columns, _ := redshiftColumnDefinitions(ts)
+	names, updates, values := []string{}, []string{}, []string{}
+	for _, column := range ts.Columns {
+		name := pgx.Identifier{column.Name}.Sanitize()
+		names = append(names, name)
+		values = append(values, stage + "." + name)
+		if name != pk {
+			updates = append(updates, name + " = " + stage + "." + name)
+		}
+	}
+	// Redshift's MERGE needs a WHEN MATCHED clause, but a table that's nothing but its primary key has nothing to
+	// update, so we just insert the rows that aren't there yet.
+	merge := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s.%s = %s.%s)",
+		target, pk, stage + "." + pk, stage, target, target, pk, stage, pk)
+	if len(updates) > 0 {
+		merge = fmt.Sprintf("MERGE INTO %s USING %s ON %s.%s = %s.%s\nWHEN MATCHED THEN UPDATE SET %s\nWHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+			target, stage, target, pk, stage, pk, strings.Join(updates, ", "), strings.Join(names, ", "), strings.Join(values, ", "))
+	}
+
+	return []string{
+		fmt.Sprintf("CREATE TEMPORARY TABLE %s (\n  %s,\n  %s boolean\n)", stage, strings.Join(columns, ",\n  "), deleted),
+		fmt.Sprintf("COPY %s FROM %s IAM_ROLE %s MANIFEST FORMAT AS JSON 'auto ignorecase' TIMEFORMAT 'auto'",
+			stage, quoteRedshiftString(manifestLocation), role),
+		fmt.Sprintf("DELETE FROM %s USING %s WHERE %s.%s = %s.%s AND %s.%s", target, stage, target, pk, stage, pk, stage, deleted),
+		fmt.Sprintf("DELETE FROM %s WHERE %s", stage, deleted),
+		merge,
+		fmt.Sprintf("DROP TABLE %s", stage),
+	}
+}
+
+func quoteRedshiftString(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", "''") + "'"
+}
+
+func redshiftType(column Column) (string, error) {
+	switch column.SqlType {
+	case "tinyint":
+		return "SMALLINT", nil
+	case "smallint":
+		if column.Signed {
+			return "SMALLINT", nil
+		}
+		return "INTEGER", nil
+	case "mediumint":
+		return "INTEGER", nil
+	case "int":
+		if column.Signed {
+			return "INTEGER", nil
+		}
+		return "BIGINT", nil
+	case "bigint":
+		if column.Signed {
+			return "BIGINT", nil
+		}
+		return "DECIMAL(20,0)", nil
+	case "float":
+		return "REAL", nil
+	case "double":
+		return "DOUBLE PRECISION", nil
+	case "decimal":
+		return fmt.Sprintf("DECIMAL(%d,%d)", column.Width, column.Scale), nil
+	case "char", "varchar":
+		// Redshift counts bytes, not characters, and a MySQL character can take up to four of them.
+		return fmt.Sprintf("VARCHAR(%d)", min(column.Width * 4, 65535)), nil
+	case "text", "mediumtext", "longtext":
+		return "VARCHAR(65535)", nil
+	case "binary", "varbinary":
+		return fmt.Sprintf("VARBYTE(%d)", max(column.Width, 1)), nil
+	case "blob", "tinyblob", "mediumblob", "longblob":
+		return "VARBYTE(1024000)", nil
+	case "date":
+		return "DATE", nil
+	case "time":
+		return "TIME", nil
+	case "datetime":
+		return "TIMESTAMP", nil
+	case "timestamp":
+		return "TIMESTAMPTZ", nil
+	default:
+		return "", fmt.Errorf("Can't convert SQL type '%s' of column '%s' to Redshift", column.SqlType, column.Name)
+	}
+}
+
+// One JSON object per row, in the formats that COPY's JSON 'auto' and TIMEFORMAT 'auto' understand.
+func redshiftJsonLines(rows RowsEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, row := range rows.Data {
+		object := make(map[string]any, len(rows.Schema.Columns) + 1)
+		for i, column := range rows.Schema.Columns {
+			object[column.Name] = redshiftValue(row[i], column)
+		}
+		object[REDSHIFT_DELETED_COLUMN] = false  // FIXME: Set this for deletes, once we're streaming the binlog.
+		if err := encoder.Encode(object); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func redshiftValue(datum any, column Column) any {
+	switch datum.(type) {
+	case *big.Rat:
+		return json.Number(datum.(*big.Rat).FloatString(column.Scale))
+	case []byte:
+		return hex.EncodeToString(datum.([]byte))  // VARBYTE columns load from hex.
+
+	case time.Time:
+		t := datum.(time.Time)
+		switch column.SqlType {
+		case "date": return t.Format("2006-01-02")
+		case "time": return t.Format("15:04:05.999999")
+		case "timestamp": return t.UTC().Format("2006-01-02 15:04:05.999999+00")
+		default: return t.Format("2006-01-02 15:04:05.999999")
+		}
+	}
+	return datum
+}
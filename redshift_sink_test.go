@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+// A stand-in for Redshift that speaks just enough of the Postgres wire protocol for pgx's simple queries. It
+// records every query and answers them all with success, unless Fail says otherwise.
+type FakePostgresServer struct {
+	Listener net.Listener
+	Lock sync.Mutex
+	Queries []string
+	Fail func(query string) bool
+}
+
+func NewFakePostgresServer(t *testing.T) *FakePostgresServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &FakePostgresServer{listener, sync.Mutex{}, []string{}, func(string) bool { return false }}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *FakePostgresServer) URL() string {
+	return "postgres://test@" + server.Listener.Addr().String() + "/test?sslmode=disable"
+}
+
+func (server *FakePostgresServer) serve(conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+	for {
+		message, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		query, ok := message.(*pgproto3.Query)
+		if !ok {
+			return
+		}
+		server.Lock.Lock()
+		server.Queries = append(server.Queries, query.String)
+		fail := server.Fail(query.String)
+		server.Lock.Unlock()
+		if fail {
+			backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: "Fake failure"})
+		} else {
+			backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("OK")})
+		}
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+// Returns the queries received since the last call, with the whitespace squashed.
+func (server *FakePostgresServer) TakeQueries() []string {
+	server.Lock.Lock()
+	defer server.Lock.Unlock()
+	queries := make([]string, len(server.Queries))
+	for i, query := range server.Queries {
+		queries[i] = strings.Join(strings.Fields(query), " ")
+	}
+	server.Queries = []string{}
+	return queries
+}
+
+var redshiftTestSchema = ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`name` varchar(10) DEFAULT NULL\n)")
+
+func newTestRedshiftSink(t *testing.T) (*RedshiftSink, *FakePostgresServer, string) {
+	server := NewFakePostgresServer(t)
+	uploadDir := t.TempDir()
+	sink, err := NewRedshiftSink(server.URL(), "public", NewLocalUploader(uploadDir), "arn:aws:iam::123:role/loader", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Exit() })
+	assert.NoError(t, sink.Open(redshiftTestSchema))
+	return sink, server, uploadDir
+}
+
+func TestRedshiftSink(t *testing.T) {
+	sink, server, uploadDir := newTestRedshiftSink(t)
+	assert.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS "public"."foo" ( "id" DECIMAL(20,0) NOT NULL, "name" VARCHAR(40), PRIMARY KEY ("id") )`,
+	}, server.TakeQueries())
+
+	rows := RowsEvent{make(chan error, 1), redshiftTestSchema, [][]any{{uint64(1), "honk"}, {uint64(2), nil}}, RowsSource{true, BinlogCoordinates{}}}
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+
+	staged, _ := filepath.Glob(filepath.Join(uploadDir, "foo", "foo.*"))
+	if !assert.Len(t, staged, 2) {
+		return
+	}
+	data, manifestPath := MustReadFile(staged[0]), staged[1]
+	assert.Equal(t, `{"__deleted":false,"id":1,"name":"honk"}` + "\n" + `{"__deleted":false,"id":2,"name":null}` + "\n", data)
+	var manifest map[string][]map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(MustReadFile(manifestPath)), &manifest))
+	assert.Equal(t, []map[string]any{{"url": staged[0], "mandatory": true}}, manifest["entries"])
+
+	expectedQueries := []string{
+		"begin",
+		`CREATE TEMPORARY TABLE "stage_foo" ( "id" DECIMAL(20,0) NOT NULL, "name" VARCHAR(40), "__deleted" boolean )`,
+		`COPY "stage_foo" FROM '` + manifestPath + `' IAM_ROLE 'arn:aws:iam::123:role/loader' MANIFEST FORMAT AS JSON 'auto ignorecase' TIMEFORMAT 'auto'`,
+		`DELETE FROM "public"."foo" USING "stage_foo" WHERE "public"."foo"."id" = "stage_foo"."id" AND "stage_foo"."__deleted"`,
+		`DELETE FROM "stage_foo" WHERE "__deleted"`,
+		`MERGE INTO "public"."foo" USING "stage_foo" ON "public"."foo"."id" = "stage_foo"."id" WHEN MATCHED THEN UPDATE SET "name" = "stage_foo"."name" WHEN NOT MATCHED THEN INSERT ("id", "name") VALUES ("stage_foo"."id", "stage_foo"."name")`,
+		`DROP TABLE "stage_foo"`,
+		"commit",
+	}
+	assert.Equal(t, expectedQueries, server.TakeQueries())
+
+	// Writing the same batch again stages the same files and runs the same merge.
+	rows.ResponseChan = make(chan error, 1)
+	sink.WriteRows(rows)
+	assert.NoError(t, <-rows.ResponseChan)
+	stagedAgain, _ := filepath.Glob(filepath.Join(uploadDir, "foo", "foo.*"))
+	assert.Equal(t, staged, stagedAgain)
+	assert.Equal(t, expectedQueries, server.TakeQueries())
+}
+
+func TestRedshiftMergeStatementsPrimaryKeyOnly(t *testing.T) {
+	schema := ParseSchema("CREATE TABLE `tags` (\n`id` bigint unsigned NOT NULL\n)")
+	statements := RedshiftMergeStatements("public", schema, "s3://bucket/tags.manifest", "")
+	assert.Equal(t, `INSERT INTO "public"."tags" ("id") SELECT "stage_tags"."id" FROM "stage_tags" WHERE NOT EXISTS `+
+		`(SELECT 1 FROM "public"."tags" WHERE "public"."tags"."id" = "stage_tags"."id")`, statements[4])
+	for _, statement := range statements {
+		assert.NotContains(t, statement, "WHEN MATCHED")
+	}
+}
+
+func TestRedshiftSinkRollsBack(t *testing.T) {
+	sink, server, _ := newTestRedshiftSink(t)
+	server.TakeQueries()
+	server.Fail = func(query string) bool { return strings.HasPrefix(query, "COPY") }
+
+	rows := RowsEvent{make(chan error, 1), redshiftTestSchema, [][]any{{uint64(1), "honk"}}, RowsSource{}}
+	sink.WriteRows(rows)
+	assert.ErrorContains(t, <-rows.ResponseChan, "Fake failure")
+	queries := server.TakeQueries()
+	assert.Equal(t, "rollback", queries[len(queries) - 1])
+	assert.NotContains(t, queries, "commit")
+}
+
+func TestRedshiftSinkSchemaChange(t *testing.T) {
+	sink, server, _ := newTestRedshiftSink(t)
+	server.TakeQueries()
+
+	newSchema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`born` date NOT NULL\n)")
+	assert.NoError(t, sink.SchemaChange(newSchema))
+	assert.Equal(t, []string{
+		`ALTER TABLE "public"."foo" ADD COLUMN "born" DATE`,
+		`ALTER TABLE "public"."foo" DROP COLUMN "name"`,
+	}, server.TakeQueries())
+}
+
+func TestRedshiftValues(t *testing.T) {
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`price` decimal(6,2),\n`blob` varbinary(10),\n" +
+		"`born` date,\n`alarm` time,\n`at` datetime(6),\n`ts` timestamp\n)")
+	at := time.Date(2021, 10, 29, 6, 5, 22, 123456000, time.UTC)
+	alarm, _ := time.Parse("15:04:05", "00:00:01")
+	data, err := redshiftJsonLines(RowsEvent{nil, schema, [][]any{
+		{uint64(1), big.NewRat(-12345, 100), []byte("hi"), time.Date(1973, 8, 30, 0, 0, 0, 0, time.UTC), alarm, at, at},
+	}, RowsSource{}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"__deleted":false,"alarm":"00:00:01","at":"2021-10-29 06:05:22.123456","blob":"6869","born":"1973-08-30",` +
+		`"id":1,"price":-123.45,"ts":"2021-10-29 06:05:22.123456+00"}` + "\n", string(data))
+
+	ddl, err := RedshiftCreateTable("public", schema)
+	assert.NoError(t, err)
+	assert.Contains(t, ddl, `"price" DECIMAL(6,2),`)
+	assert.Contains(t, ddl, `"blob" VARBYTE(10),`)
+	assert.Contains(t, ddl, `"ts" TIMESTAMPTZ,`)
+}
+
+func TestRedshiftSinkParams(t *testing.T) {
+	_, err := sinkTypes["redshift"](map[string]string{"url": "postgres://localhost:5439/dev"})
+	assert.ErrorContains(t, err, "Missing parameter: upload")
+
+	sink, err := sinkTypes["redshift"](map[string]string{"url": "postgres://localhost:5439/dev", "upload": "file:///staging"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "public", sink.(*RedshiftSink).Schema)
+	assert.Equal(t, os.TempDir(), sink.(*RedshiftSink).Directory)
+	assert.NoError(t, sink.Exit())
+
+	_, err = sinkTypes["redshift"](map[string]string{"url": "postgres://localhost:5439/dev", "upload": "s3://"})
+	assert.ErrorContains(t, err, "Can't upload to S3 without a bucket name")
+}
+
+func TestS3Uploader(t *testing.T) {
+	var lock sync.Mutex
+	uploaded := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPut {
+			lock.Lock()
+			uploaded[r.URL.Path] = string(body)
+			lock.Unlock()
+		}
+		w.Header().Set("ETag", `"abc"`)
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	uploader, err := NewUploader("s3://bucket/some/prefix/")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "s3://bucket/some/prefix/foo/foo.json", uploader.Location("foo/foo.json"))
+
+	localPath := filepath.Join(t.TempDir(), "foo.json")
+	assert.NoError(t, os.WriteFile(localPath, []byte("honk\n"), 0644))
+	assert.NoError(t, uploader.Upload(localPath, "foo/foo.json"))
+	// The body is signed in chunks, so the file is in there somewhere.
+	assert.Contains(t, uploaded["/bucket/some/prefix/foo/foo.json"], "honk\n")
+}
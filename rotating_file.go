@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DEFAULT_MAX_FILE_SIZE = 100 * 1024 * 1024
+const DEFAULT_MAX_FILE_AGE = time.Hour
+
+// The parameters that every sink which writes rotating files understands.
+var ROTATING_FILE_PARAMS = []string{"directory", "max_size", "max_age", "upload"}
+
+type RotatingFileSettings struct {
+	Directory string
+	MaxSize int64
+	MaxAge time.Duration
+	Uploader Uploader
+}
+
+func ParseRotatingFileSettings(params map[string]string) (settings RotatingFileSettings, err error) {
+	settings = RotatingFileSettings{params["directory"], DEFAULT_MAX_FILE_SIZE, DEFAULT_MAX_FILE_AGE, nil}
+	if settings.Directory == "" {
+		return settings, fmt.Errorf("Missing parameter: directory")
+	}
+	if value, ok := params["max_size"]; ok {
+		if settings.MaxSize, err = strconv.ParseInt(value, 10, 64); err != nil || settings.MaxSize < 0 {
+			return settings, fmt.Errorf("Bogus value for max_size: '%s'", value)
+		}
+	}
+	if value, ok := params["max_age"]; ok {
+		if settings.MaxAge, err = time.ParseDuration(value); err != nil || settings.MaxAge < 0 {
+			return settings, fmt.Errorf("Bogus value for max_age: '%s'", value)
+		}
+	}
+	if destination, ok := params["upload"]; ok {
+		if settings.Uploader, err = NewUploader(destination); err != nil {
+			return settings, err
+		}
+	}
+	return settings, nil
+}
+
+func (settings RotatingFileSettings) NewFile(prefix, extension string) *RotatingFile {
+	return NewRotatingFile(settings.Directory, prefix, extension, settings.MaxSize, settings.MaxAge, settings.Uploader)
+}
+
+// Finishes files that have been sitting around too long, even if nobody's writing to them, until `done` is closed.
+// The lock has to be held to touch the files.
+func rotateOldFiles(log *slog.Logger, maxAge time.Duration, lock *sync.Mutex, files map[string]*RotatingFile, done <-chan struct{}) {
+	ticker := time.NewTicker(min(maxAge, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			lock.Lock()
+			for table, file := range files {
+				if err := file.RotateIfOld(); err != nil {
+					log.Error("Can't rotate file.", "table", table, "error", err)
+				}
+			}
+			lock.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// A local output file which gets finished and replaced with a new one once it's too big or too old. Finished files
+// are handed to the uploader (and deleted) if there is one, and left where they are if not. The file is only
+// created when something is written to it, so idle tables don't leave empty files lying around.
+type RotatingFile struct {
+	Directory string
+	Prefix string      // The files are named like "<Prefix>.<timestamp>.<sequence>.<Extension>".
+	Extension string
+	MaxSize int64      // Zero means no limit.
+	MaxAge time.Duration
+	Uploader Uploader  // Can be nil.
+	OnOpen func(file *os.File) error  // Writes a header to each new file, if the format needs one.
+
+	file *os.File
+	written int64
+	openedAt time.Time
+	sequence int
+}
+
+func NewRotatingFile(directory, prefix, extension string, maxSize int64, maxAge time.Duration, uploader Uploader) *RotatingFile {
+	return &RotatingFile{directory, prefix, extension, maxSize, maxAge, uploader, nil, nil, 0, time.Time{}, 0}
+}
+
+// Writes the data to the current file, starting a new one first if the current one is too old, and finishing it
+// afterwards if it's now too big. The data always goes into a single file.
+func (rf *RotatingFile) Write(data []byte) (int, error) {
+	if err := rf.RotateIfOld(); err != nil {
+		return 0, err
+	}
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(data)
+	rf.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if rf.MaxSize > 0 && rf.written >= rf.MaxSize {
+		return n, rf.Finish()
+	}
+	return n, nil
+}
+
+func (rf *RotatingFile) open() error {
+	if err := os.MkdirAll(rf.Directory, 0755); err != nil {
+		return err
+	}
+	rf.openedAt = time.Now()
+	rf.sequence++
+	name := fmt.Sprintf("%s.%s.%04d.%s", rf.Prefix, rf.openedAt.UTC().Format("20060102T150405Z"), rf.sequence, rf.Extension)
+	file, err := os.OpenFile(filepath.Join(rf.Directory, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	rf.file, rf.written = file, 0
+	if rf.OnOpen != nil {
+		if err = rf.OnOpen(file); err != nil {
+			return err
+		}
+		if info, err := file.Stat(); err == nil {
+			rf.written = info.Size()
+		}
+	}
+	return nil
+}
+
+// Finishes the current file if it's been open longer than MaxAge.
+func (rf *RotatingFile) RotateIfOld() error {
+	if rf.file != nil && rf.MaxAge > 0 && time.Since(rf.openedAt) >= rf.MaxAge {
+		return rf.Finish()
+	}
+	return nil
+}
+
+// Closes the current file and uploads it. The next write starts a new one.
+func (rf *RotatingFile) Finish() error {
+	if rf.file == nil {
+		return nil
+	}
+	path := rf.file.Name()
+	err := rf.file.Close()
+	rf.file = nil
+	if err != nil {
+		return err
+	}
+	if rf.Uploader == nil {
+		return nil
+	}
+	if err = rf.Uploader.Upload(path, rf.Prefix + "/" + filepath.Base(path)); err != nil {
+		return fmt.Errorf("Can't upload %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,89 @@
+// Coordinates a graceful shutdown. Once one starts, we stop handing out work, let the workers finish the
+// intervals they're on (marking each one done, so our checkpoints are current), then tell every sink to flush
+// and exit. If that takes longer than SHUTDOWN_TIMEOUT, we give up and exit with an error, since being killed
+// halfway through by the orchestrator is worse.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type ShutdownCoordinator struct {
+	Timeout time.Duration
+	requestedChan chan struct{}
+	finishedChan chan struct{}
+	requestOnce sync.Once
+	finishOnce sync.Once
+	exit func(code int)
+}
+
+func NewShutdownCoordinator() *ShutdownCoordinator {
+	return NewCustomShutdownCoordinator(config.ShutdownTimeout, os.Exit)
+}
+
+func NewCustomShutdownCoordinator(timeout time.Duration, exit func(code int)) *ShutdownCoordinator {
+	return &ShutdownCoordinator{
+		timeout,
+		make(chan struct{}),
+		make(chan struct{}),
+		sync.Once{},
+		sync.Once{},
+		exit,
+	}
+}
+
+// Starts a graceful shutdown, if one hasn't already started: calls 'stop' to tell the running work to wind
+// down, then starts the clock. Returns immediately.
+func (sc *ShutdownCoordinator) Shutdown(reason string, stop func()) {
+	sc.requestOnce.Do(func() {
+		logger.Info("Shutting down.", "reason", reason)
+		close(sc.requestedChan)
+		stop()
+
+		go func() {
+			select {
+			case <-sc.finishedChan:
+			case <-time.After(sc.Timeout):
+				logger.Error("The shutdown didn't finish in time. Exiting anyway.", "timeout", sc.Timeout)
+				sc.exit(1)
+			}
+		}()
+	})
+}
+
+// This returns a channel which will be closed once a shutdown has been requested.
+func (sc *ShutdownCoordinator) RequestedSignal() <-chan struct{} {
+	return sc.requestedChan
+}
+
+func (sc *ShutdownCoordinator) Requested() bool {
+	select {
+	case <-sc.requestedChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Call this once all the work has stopped. It tells every sink to flush and exit, then stops the clock.
+// Returns all of the sinks' errors together.
+func (sc *ShutdownCoordinator) Finish() error {
+	errs := []error{}
+	sc.finishOnce.Do(func() {
+		for _, sink := range sinks {
+			if err := sink.Exit(); err != nil {
+				errs = append(errs, fmt.Errorf("Error exiting %s: %s", sinkName(sink), err))
+			}
+		}
+		if err := deadLetters.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("Error closing the dead-letter sink: %s", err))
+		}
+		close(sc.finishedChan)
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Sets up the globals for a signal test and starts the snapshot. The function gets a channel which receives the
+// exit code, if the shutdown coordinator tries to exit the process, and one which receives what Run returned.
+// Once the test is over, the sink is unstuck and the snapshot is stopped, so that nothing is left running.
+func withShutdownTest(t *testing.T, timeout time.Duration, sink *FakeSink, state SnapshotState, fn func(exits chan int, done chan bool)) {
+	exits := make(chan int, 2)
+	oldShutdown := shutdown
+	shutdown = NewCustomShutdownCoordinator(timeout, func(code int) { exits <- code })
+	s := NewCustomSnapshotter(state)
+	snapshotter.Store(s)
+	sinks = []Sink{sink}
+
+	done := make(chan bool, 1)
+	stopped := make(chan struct{})
+	go func() {
+		done <- s.Run()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		sink.Unstick()
+		s.Exit()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Error("The snapshot didn't stop after the test")
+		}
+		shutdown, sinks = oldShutdown, nil
+		snapshotter.Store(nil)
+	})
+
+	stopListening := listenForSignals()
+	defer stopListening()
+	fn(exits, done)
+}
+
+func sendSignal(t *testing.T, sig syscall.Signal) {
+	assert.NoError(t, syscall.Kill(os.Getpid(), sig))
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	SetFakeResponses(
+		FakeMysqlResponse{false, 1_000_000, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+	WithConfig("SNAPSHOT_CHUNK_SIZE", "100", func() {
+		sink := &FakeSink{Delay: time.Millisecond}
+		state := NewFakeSnapshotState([]string{"foo"}, 1_000_000)
+		withShutdownTest(t, 5 * time.Second, sink, state, func(exits chan int, done chan bool) {
+			assert.Eventually(t, func() bool {
+				sink.Lock.Lock()
+				defer sink.Lock.Unlock()
+				return sink.RowsEvents > 0
+			}, 5 * time.Second, time.Millisecond)
+			sendSignal(t, syscall.SIGTERM)
+			select {
+			case result := <-done:
+				assert.False(t, result)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "The snapshot didn't stop after SIGTERM")
+			}
+			assert.True(t, shutdown.Requested())
+			assert.NoError(t, shutdown.Finish())
+			assert.True(t, sink.Exited)
+
+			// Every interval that was written to the sink was marked done before we stopped.
+			completed := uint64(0)
+			for _, interval := range state.(*FakeSnapshotState).Tables[0].CompletedIntervals {
+				completed += interval.End - interval.Start
+			}
+			assert.Equal(t, uint64(sink.RowsEvents) * 100, completed)
+			assert.Empty(t, exits)
+		})
+	})
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	SetFakeResponses(
+		FakeMysqlResponse{false, 1_000_000, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+	sink := &FakeSink{Stuck: true}
+	withShutdownTest(t, 100 * time.Millisecond, sink, NewFakeSnapshotState([]string{"foo"}, 10_000), func(exits chan int, done chan bool) {
+		sendSignal(t, syscall.SIGINT)
+		select {
+		case code := <-exits:
+			assert.Equal(t, 1, code)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "The shutdown deadline didn't exit the process")
+		}
+		assert.False(t, sink.Exited)
+	})
+}
+
+func TestShutdownSecondSignal(t *testing.T) {
+	SetFakeResponses(
+		FakeMysqlResponse{false, 1_000_000, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+	sink := &FakeSink{Stuck: true}
+	withShutdownTest(t, time.Minute, sink, NewFakeSnapshotState([]string{"foo"}, 10_000), func(exits chan int, done chan bool) {
+		sendSignal(t, syscall.SIGTERM)
+		assert.Eventually(t, shutdown.Requested, 5 * time.Second, 10 * time.Millisecond)
+		sendSignal(t, syscall.SIGTERM)
+		select {
+		case code := <-exits:
+			assert.Equal(t, 1, code)
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "A second SIGTERM didn't exit the process")
+		}
+	})
+}
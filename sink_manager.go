@@ -0,0 +1,239 @@
+// The SinkManager hands each batch of rows to all of its table's sinks at once and collects their answers. Every sink has
+// room for SINK_BUFFER_SIZE batches in progress, so a slow sink can fall a little behind the others without holding
+// them up, and the workers can get on with the next interval while the sinks write. A batch keeps its place in the
+// buffer until the sink answers, even if we've stopped waiting for it. Once its buffer is full, a required sink makes
+// the workers wait for it. An optional sink (one listed in
+// OPTIONAL_SINKS) misses out on the batch instead, and its failures are only logged, so it can never stop the rest.
+// An interval only counts as done once every required sink has acknowledged it. We never send a sink the same interval
+// while it's still working on an earlier copy of it, so a slow sink can't end up writing the rows twice. And when an
+// interval is re-queued because one sink is stuck, the sinks that already wrote it don't get it again.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrSinkStuck = errors.New("Sink is stuck")
+
+type ManagedSink struct {
+	Name string
+	Sink Sink
+	Optional bool
+	buffer chan struct{}  // Holds one token for each batch the sink is working on, until it answers.
+	lock sync.Mutex
+	stuckWrites map[string]*sinkWrite  // Writes of re-queued intervals that the sink still hasn't answered.
+	written map[string]bool  // Re-queued intervals that the sink has already written.
+}
+
+// One call to the sink's WriteRows, which we may wait on more than once.
+type sinkWrite struct {
+	event RowsEvent
+	sentAt time.Time
+	deadlines int  // How many write deadlines it's missed so far.
+}
+
+type SinkManager struct {
+	Sinks []*ManagedSink
+}
+
+func NewSinkManager(sinks []Sink) *SinkManager {
+	managed := make([]*ManagedSink, len(sinks))
+	for i, sink := range sinks {
+		name := sinkName(sink)
+		managed[i] = &ManagedSink{
+			name, sink, StringInList(name, config.OptionalSinks), make(chan struct{}, config.SinkBufferSize),
+			sync.Mutex{}, map[string]*sinkWrite{}, map[string]bool{},
+		}
+	}
+	return &SinkManager{managed}
+}
+
+// One sink's answer about one batch.
+type SinkAck struct {
+	Sink *ManagedSink
+	Err error
+}
+
+// A batch of rows on its way to the sinks. Only the required sinks answer on Acks.
+type SinkBatch struct {
+	Acks chan SinkAck
+	required int
+	pi PendingInterval
+}
+
+// Starts sending the rows to every sink. Only blocks if a required sink's buffer is full, and gives up (returning
+// nil) if 'stop' is closed while it's waiting.
+func (m *SinkManager) Write(log *slog.Logger, pi PendingInterval, rows RowsEvent, stop <-chan struct{}) *SinkBatch {
+	batch := &SinkBatch{make(chan SinkAck, len(m.Sinks)), 0, pi}
+	for _, sink := range m.Sinks {
+		if !sinkWantsTable(sink.Name, pi.Schema.Name) || sink.takeWritten(pi) {
+			continue
+		}
+		write := sink.takeStuckWrite(pi)
+		if write != nil {
+			// The sink is still working on an earlier copy of the interval, which already has a place in the buffer.
+			if !sink.Optional {
+				batch.required++
+			}
+		} else if !sink.Optional {
+			select {
+			case sink.buffer <- struct{}{}:
+				batch.required++
+			case <-stop:
+				return nil
+			}
+		} else {
+			select {
+			case sink.buffer <- struct{}{}:
+			default:
+				metrics.Count("sink.dropped_batches", 1, tableTag(pi.Schema.Name), sinkTag(sink.Name))
+				log.Warn("Optional sink is too far behind, so it's missing a batch.", "sink", sink.Name, "table", pi.Schema.Name, "interval", pi.Interval.String())
+				continue
+			}
+		}
+		if write == nil {
+			write = sink.send(rows)
+		}
+
+		go func(sink *ManagedSink, write *sinkWrite) {
+			err := sink.deliver(log, pi, write)
+			if !sink.Optional {
+				batch.Acks <- SinkAck{sink, err}
+			} else if err != nil {
+				log.Warn("Optional sink failed to write a batch.", "sink", sink.Name, "table", pi.Schema.Name, "interval", pi.Interval.String(), "error", err)
+			}
+		}(sink, write)
+	}
+	return batch
+}
+
+// Waits for every required sink to answer. If any of them failed, returns the first one and its error.
+func (batch *SinkBatch) Wait() (*ManagedSink, error) {
+	var failed *ManagedSink
+	var err error
+	succeeded := []*ManagedSink{}
+	for i := 0; i < batch.required; i++ {
+		ack := <-batch.Acks
+		if ack.Err == nil {
+			succeeded = append(succeeded, ack.Sink)
+		} else if failed == nil {
+			failed, err = ack.Sink, ack.Err
+		}
+	}
+	// The interval is about to be re-queued, so remember who doesn't need it again.
+	if errors.Is(err, ErrSinkStuck) && config.SinkStuckPolicy == "requeue" {
+		for _, sink := range succeeded {
+			sink.keepWritten(batch.pi)
+		}
+	}
+	return failed, err
+}
+
+func (m *SinkManager) reportMetrics() {
+	for _, sink := range m.Sinks {
+		metrics.Gauge("sink.buffered_batches", float64(len(sink.buffer)), sinkTag(sink.Name))
+	}
+}
+
+// Waits for the sink to answer a write within its write deadline. If it doesn't, the
+// SINK_STUCK_POLICY decides what happens: "retry" gives it another deadline, and "requeue" leaves the write
+// running for the re-queued interval to pick up. Either way, the interval fails for good once the write has
+// missed MAX_RETRIES deadlines.
+func (sink *ManagedSink) deliver(log *slog.Logger, pi PendingInterval, write *sinkWrite) error {
+	tags := []string{tableTag(pi.Schema.Name), sinkTag(sink.Name)}
+	timeout := config.SinkWriteTimeoutFor(sink.Name)
+
+	for {
+		timer := time.NewTimer(timeout)
+		select {
+		case err := <-write.event.ResponseChan:
+			timer.Stop()
+			metrics.Timing("sink.write_time", time.Since(write.sentAt), tags...)
+			if err != nil {
+				metrics.Count("sink.errors", 1, tags...)
+				return fmt.Errorf("Error writing to sink %s: %w", sink.Name, err)
+			}
+			return nil
+
+		case <-timer.C:
+			write.deadlines++
+			metrics.Count("sink.stuck", 1, tags...)
+			log.Warn("Sink is stuck.", "sink", sink.Name, "table", pi.Schema.Name, "interval", pi.Interval.String(),
+				"timeout", timeout, "attempt", write.deadlines, "policy", config.SinkStuckPolicy)
+			if write.deadlines > MAX_RETRIES {
+				return fmt.Errorf("Gave up on sink %s, which missed %d write deadlines of %s in a row", sink.Name, write.deadlines, timeout)
+			} else if config.SinkStuckPolicy == "retry" {
+				continue
+			} else if config.SinkStuckPolicy == "requeue" {
+				sink.keepStuckWrite(pi, write)
+			}
+			return fmt.Errorf("%w: %s didn't answer within %s", ErrSinkStuck, sink.Name, timeout)
+		}
+	}
+}
+
+// Each write gets its own response channel, with room for one answer. That way a sink that answers after we've
+// given up on it never blocks, and its late answer can't be mistaken for an answer to some other batch. WriteRows
+// is called in the background in case the sink is stuck so badly that it can't even take the rows. The batch's
+// buffer token is handed back once the sink answers, and not before, so a stuck sink can't pile up writes.
+func (sink *ManagedSink) send(rows RowsEvent) *sinkWrite {
+	write := &sinkWrite{rows, time.Now(), 0}
+	write.event.ResponseChan = make(chan error, 1)
+	answer := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				answer <- NewPanicError(r)
+			}
+		}()
+		sink.Sink.WriteRows(RowsEvent{answer, rows.Schema, rows.Data, rows.Source})
+	}()
+	go func() {
+		err := <-answer
+		<-sink.buffer
+		write.event.ResponseChan <- err
+	}()
+	return write
+}
+
+func intervalKey(pi PendingInterval) string {
+	return pi.Schema.Name + "/" + pi.Interval.String()
+}
+
+func (sink *ManagedSink) keepStuckWrite(pi PendingInterval, write *sinkWrite) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.stuckWrites[intervalKey(pi)] = write
+}
+
+// Returns the write of an earlier copy of the interval, if the sink was stuck on it, so we can wait for that
+// one instead of sending the rows again.
+func (sink *ManagedSink) takeStuckWrite(pi PendingInterval) *sinkWrite {
+	key := intervalKey(pi)
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	write := sink.stuckWrites[key]
+	delete(sink.stuckWrites, key)
+	return write
+}
+
+func (sink *ManagedSink) keepWritten(pi PendingInterval) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.written[intervalKey(pi)] = true
+}
+
+// True if the sink already wrote an earlier copy of the re-queued interval, and so should skip this one.
+func (sink *ManagedSink) takeWritten(pi PendingInterval) bool {
+	key := intervalKey(pi)
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	written := sink.written[key]
+	delete(sink.written, key)
+	return written
+}
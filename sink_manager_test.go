@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var sinkManagerTestInterval = PendingInterval{&TableSchema{"foo", []Column{{Name: "id", SqlType: "bigint", Width: 20}}}, Interval{0, 100}}
+
+func TestSinkManagerWaitsForRequiredSinks(t *testing.T) {
+	slow := &FakeSink{Delay: 50 * time.Millisecond}
+	broken := &FakeSink{Err: errors.New("disk full")}
+	manager := NewSinkManager([]Sink{slow, broken})
+	manager.Sinks[1].Name = "broken"
+
+	WithRecordingMetrics(func(recorder *RecordingMetrics) {
+		start := time.Now()
+		sink, err := manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, nil).Wait()
+		assert.GreaterOrEqual(t, time.Since(start), 50 * time.Millisecond)
+		assert.Equal(t, "broken", sink.Name)
+		assert.EqualError(t, err, "Error writing to sink broken: disk full")
+		assert.Equal(t, int64(1), recorder.Counts["sink.errors|table:foo|sink:broken"])
+		assert.Zero(t, recorder.Counts["sink.errors|table:foo|sink:FakeSink"])
+		assert.Equal(t, 1, recorder.Timings["sink.write_time|table:foo|sink:FakeSink"])
+	})
+}
+
+func TestSinkManagerOptionalSinks(t *testing.T) {
+	required := &FakeSink{Delay: time.Millisecond}
+	stuck := &FakeSink{Stuck: true}
+	broken := &FakeSink{Err: errors.New("nope")}
+
+	os.Setenv("SINK_BUFFER_SIZE", "2")
+	defer os.Unsetenv("SINK_BUFFER_SIZE")
+	WithConfig("OPTIONAL_SINKS", "stuck,broken", func() {
+		manager := NewSinkManager([]Sink{required, stuck, broken})
+		assert.False(t, manager.Sinks[0].Optional)
+		for i, name := range []string{"FakeSink", "stuck", "broken"} {
+			manager.Sinks[i].Name = name
+			manager.Sinks[i].Optional = StringInList(name, config.OptionalSinks)
+		}
+
+		WithRecordingMetrics(func(recorder *RecordingMetrics) {
+			// The stuck sink falls behind, but the required sink keeps going without it.
+			for i := 0; i < 5; i++ {
+				sink, err := manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, nil).Wait()
+				assert.Nil(t, sink)
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, 5, required.RowsEvents)
+			assert.Eventually(t, func() bool {
+				stuck.Lock.Lock()
+				defer stuck.Lock.Unlock()
+				return stuck.RowsEvents == 2
+			}, time.Second, time.Millisecond)
+			assert.Equal(t, int64(3), recorder.Counts["sink.dropped_batches|table:foo|sink:stuck"])
+			assert.Zero(t, recorder.Counts["sink.dropped_batches|table:foo|sink:FakeSink"])
+
+			manager.reportMetrics()
+			assert.Equal(t, 2.0, recorder.Gauges["sink.buffered_batches|sink:stuck"])
+		})
+	})
+}
+
+func TestSinkManagerWriteDoesntWait(t *testing.T) {
+	slow := &FakeSink{Delay: 50 * time.Millisecond}
+	manager := NewSinkManager([]Sink{slow})
+
+	start := time.Now()
+	batch := manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, nil)
+	assert.Less(t, time.Since(start), 50 * time.Millisecond)
+	_, err := batch.Wait()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50 * time.Millisecond)
+}
+
+// A write we've given up on keeps its place in the buffer until the sink answers it.
+func TestSinkManagerStuckWritesKeepTheirBuffer(t *testing.T) {
+	stuck := &FakeSink{Stuck: true}
+	os.Setenv("SINK_BUFFER_SIZE", "1")
+	defer os.Unsetenv("SINK_BUFFER_SIZE")
+	os.Setenv("SINK_WRITE_TIMEOUT", "20ms")
+	defer os.Unsetenv("SINK_WRITE_TIMEOUT")
+	WithConfig("SINK_STUCK_POLICY", "shutdown", func() {
+		manager := NewSinkManager([]Sink{stuck})
+		_, err := manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, nil).Wait()
+		assert.ErrorIs(t, err, ErrSinkStuck)
+		assert.Len(t, manager.Sinks[0].buffer, 1)
+
+		stop := make(chan struct{})
+		time.AfterFunc(20 * time.Millisecond, func() { close(stop) })
+		assert.Nil(t, manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, stop))
+
+		stuck.Unstick()
+		assert.Eventually(t, func() bool { return len(manager.Sinks[0].buffer) == 0 }, time.Second, time.Millisecond)
+	})
+}
+
+// When an interval is re-queued because one sink is stuck, the healthy sinks don't write it again.
+func TestSinkManagerRequeueSkipsSinksThatWrote(t *testing.T) {
+	healthy := &FakeSink{}
+	stuck := &FakeSink{Stuck: true}
+	os.Setenv("SINK_WRITE_TIMEOUT", "20ms")
+	defer os.Unsetenv("SINK_WRITE_TIMEOUT")
+	WithConfig("SINK_STUCK_POLICY", "requeue", func() {
+		manager := NewSinkManager([]Sink{healthy, stuck})
+		manager.Sinks[1].Name = "stuck"
+		sink, err := manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, nil).Wait()
+		assert.Equal(t, "stuck", sink.Name)
+		assert.ErrorIs(t, err, ErrSinkStuck)
+
+		// The re-queued interval only waits on the stuck sink's original write.
+		batch := manager.Write(snapshotLogger, sinkManagerTestInterval, RowsEvent{}, nil)
+		stuck.Unstick()
+		sink, err = batch.Wait()
+		assert.Nil(t, sink)
+		assert.NoError(t, err)
+
+		healthy.Lock.Lock()
+		assert.Equal(t, 1, healthy.RowsEvents)
+		healthy.Lock.Unlock()
+		stuck.Lock.Lock()
+		assert.Equal(t, 1, stuck.RowsEvents)
+		stuck.Lock.Unlock()
+	})
+}
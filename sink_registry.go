@@ -0,0 +1,96 @@
+// Each type of sink registers a constructor under a name, like "csv". The config file then declares the sinks we
+// actually write to, each with a name of its own, a type, and whatever parameters that type wants:
+//
+//   sinks:
+//     local:
+//       type: csv
+//       directory: /var/export
+//
+// Tables go to every sink unless their `sinks:` setting says otherwise.
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Builds a sink from its parameters. It should complain about any parameters it doesn't understand.
+type SinkConstructor func(params map[string]string) (Sink, error)
+
+var sinkTypes = map[string]SinkConstructor{}
+
+// The names that the configured sinks were given in the config file.
+var configuredSinkNames = map[Sink]string{}
+
+// Called from the init() of each type of sink.
+func RegisterSinkType(sinkType string, constructor SinkConstructor) {
+	if _, ok := sinkTypes[sinkType]; ok {
+		panic(fmt.Errorf("Sink type '%s' is already registered!", sinkType))
+	}
+	sinkTypes[sinkType] = constructor
+}
+
+func registeredSinkTypes() []string {
+	types := make([]string, 0, len(sinkTypes))
+	for sinkType := range sinkTypes {
+		types = append(types, sinkType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Builds all of the sinks in the config, in order of their names.
+func BuildSinks() ([]Sink, error) {
+	names := make([]string, 0, len(config.Sinks))
+	for name := range config.Sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	built := make([]Sink, 0, len(names))
+	for _, name := range names {
+		sinkConfig := config.Sinks[name]
+		constructor, ok := sinkTypes[sinkConfig.Type]
+		if !ok {
+			return nil, fmt.Errorf("Sink '%s' has unknown type '%s'", name, sinkConfig.Type)
+		}
+		sink, err := constructor(sinkConfig.Params)
+		if err != nil {
+			return nil, fmt.Errorf("Can't create sink '%s': %w", name, err)
+		}
+		configuredSinkNames[sink] = name
+		built = append(built, sink)
+	}
+	return built, nil
+}
+
+// True if rows from the given table should go to the named sink.
+func sinkWantsTable(sinkName, tableName string) bool {
+	routed := config.Tables[tableName].Sinks
+	return len(routed) == 0 || StringInList(sinkName, routed)
+}
+
+// The sinks that rows from the given table should go to.
+func sinksForTable(tableName string) []Sink {
+	return slices.DeleteFunc(slices.Clone(sinks), func(sink Sink) bool {
+		return !sinkWantsTable(sinkName(sink), tableName)
+	})
+}
+
+// Returns an error if there are any parameters that the sink doesn't know about.
+func checkSinkParams(params map[string]string, known ...string) error {
+	unknown := []string{}
+	for param := range params {
+		if !StringInList(param, known) {
+			unknown = append(unknown, param)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown parameters: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
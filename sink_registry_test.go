@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	RegisterSinkType("fake", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, "delay"); err != nil {
+			return nil, err
+		}
+		delay, err := time.ParseDuration(params["delay"])
+		if err != nil && params["delay"] != "" {
+			return nil, err
+		}
+		return &FakeSink{Delay: delay}, nil
+	})
+}
+
+func TestBuildSinks(t *testing.T) {
+	filename := writeConfigFile(t, `
+sinks:
+  slow:
+    type: fake
+    delay: 5ms
+  local:
+    type: csv
+    directory: /var/export
+tables:
+  users:
+    sinks: [slow]
+`)
+	WithConfig("CONFIG_FILE", filename, func() {
+		built, err := BuildSinks()
+		assert.NoError(t, err)
+		assert.Len(t, built, 2)
+		assert.Equal(t, "local", sinkName(built[0]))
+		assert.Equal(t, "slow", sinkName(built[1]))
+		assert.Equal(t, 5 * time.Millisecond, built[1].(*FakeSink).Delay)
+
+		sinks = built
+		defer func() { sinks = nil }()
+		assert.Equal(t, []Sink{built[1]}, sinksForTable("users"))
+		assert.Equal(t, built, sinksForTable("posts"))
+		assert.True(t, sinkWantsTable("slow", "users"))
+		assert.False(t, sinkWantsTable("local", "users"))
+
+		// The CSV sink would fail if it got the rows, since it doesn't have the table open.
+		manager := NewSinkManager(built)
+		assert.Equal(t, "slow", manager.Sinks[1].Name)
+		pi := PendingInterval{&TableSchema{"users", []Column{{Name: "id", SqlType: "bigint", Width: 20}}}, Interval{0, 100}}
+		_, err = manager.Write(snapshotLogger, pi, RowsEvent{}, nil).Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, built[1].(*FakeSink).RowsEvents)
+	})
+}
+
+func TestBuildSinksErrors(t *testing.T) {
+	filename := writeConfigFile(t, `
+sinks:
+  slow:
+    type: fake
+    delay: 5ms
+    colour: blue
+`)
+	WithConfig("CONFIG_FILE", filename, func() {
+		_, err := BuildSinks()
+		assert.EqualError(t, err, "Can't create sink 'slow': Unknown parameters: colour")
+	})
+
+	filename = writeConfigFile(t, `
+sinks:
+  nameless: {}
+  weird:
+    type: parquet
+tables:
+  users:
+    sinks: [weird, missing]
+`)
+	_, err := LoadConfigFile(filename)
+	assert.ErrorContains(t, err, "Sink 'nameless' doesn't have a type")
+
+	WithConfig("CONFIG_FILE", filename, func() {
+		err := config.Validate()
+		assert.ErrorContains(t, err, "Sink 'weird' has unknown type 'parquet' (expected one of avro, csv, fake, jsonl, kafka, postgres, redshift, sqlite)")
+		assert.ErrorContains(t, err, "Table 'users' is routed to sink 'missing', which isn't in the config file")
+		assert.NotContains(t, err.Error(), "sink 'weird', which")
+	})
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"container/list"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strconv"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -22,91 +25,176 @@ type PendingInterval struct {
 type SnapshotState interface {
 	GetNextPendingInterval() (PendingInterval, bool)
 	MarkIntervalDone(pendingInterval PendingInterval) error
+	RequeueInterval(pendingInterval PendingInterval)
 	Done() bool
+	AddTable(table *TableSchema) error
+	RemoveTable(tableName string)
+	PendingIntervalCounts() map[string]int
+	TableStatuses() map[string]TableStatus
+	HasTable(tableName string) bool
+	PauseTable(tableName string)
+	ResumeTable(tableName string)
 }
 
 // As opposed to the FakeSnapshotState that we use in some of the tests.
 type RealSnapshotState struct {
 	Tables map[string]*SnapshotTableState
 	PendingIntervals *list.List
+	freshSnapshot bool  // True if we threw away all previous progress at startup.
+	paused map[string]bool
 }
 
-func NewSnapshotState(tables []*TableSchema) SnapshotState {
-	var err error
+// Also returns where the binlog was when we checked, so the snapshot and the streaming that follows it agree on
+// a starting point without locking the tables a second time.
+func NewSnapshotState(tables []*TableSchema) (SnapshotState, BinlogCoordinates, error) {
+	freshSnapshot, position, err := needsSnapshot()
+	if err != nil {
+		return nil, BinlogCoordinates{}, err
+	}
 	state := RealSnapshotState{
 		make(map[string]*SnapshotTableState, len(tables)),
 		list.New(),
+		freshSnapshot,
+		map[string]bool{},
 	}
-	needsSnapshot := needsSnapshot()
 
 	// Populate the list of tables which haven't yet been completely snapshotted.
 	// (If needsSnapshot is true, that's all of them.)
 	for _, table := range tables {
-		progress := ""
-		if needsSnapshot {
-			err := stateStorage.Delete("table_snapshot_progress/" + table.Name)
-			if err != nil {
-				panic(err)
-			}
-		} else {
-			progress, err = stateStorage.Get("table_snapshot_progress/" + table.Name)
-			if err != nil && err != redis.Nil {
-				panic(err)
-			}
+		tableState, err := state.loadTableState(table)
+		if err != nil {
+			return nil, BinlogCoordinates{}, fmt.Errorf("Can't load the snapshot progress of table '%s': %w", table.Name, err)
 		}
-
-		if progress != "done" {
-			tableState := SnapshotTableState{
-				table,
-				ParseIntervalList(progress),
-				ParseIntervalList(progress),
-				getHighestTableId(table.Name),
-			}
-			state.Tables[table.Name] = &tableState
+		if tableState != nil {
+			state.Tables[table.Name] = tableState
 		}
 	}
 
 	// Populate the PendingIntervals list with work that needs to be done for each incomplete table.
 	for _, tableState := range state.Tables {
-		// We want to start with (number of gaps in the completed list) + 1 chunks.
-		chunksToAdd := 1
-		if len(tableState.CompletedIntervals) > 0 {
-			chunksToAdd = len(tableState.CompletedIntervals) + 1
+		state.addInitialPendingIntervals(tableState)
+	}
+
+	return &state, position, nil
+}
+
+// Reads a table's snapshot progress, or throws it away if we're starting a fresh snapshot. Returns nil if the
+// table has already been completely snapshotted.
+func (state *RealSnapshotState) loadTableState(table *TableSchema) (*SnapshotTableState, error) {
+	var err error
+	progress := ""
+	if state.freshSnapshot {
+		err = stateStorage.Delete("table_snapshot_progress/" + table.Name)
+		if err != nil {
+			return nil, err
 		}
-		for i := 0; i < chunksToAdd; i++ {
-			state.addNextPendingInterval(tableState)
+	} else {
+		progress, err = stateStorage.Get("table_snapshot_progress/" + table.Name)
+		if err != nil && err != redis.Nil {
+			return nil, err
 		}
 	}
 
-	return &state
+	if progress == "done" {
+		return nil, nil
+	}
+	// Progress can come from an imported state document, so we don't trust it not to be garbage.
+	completed, err := DecodeIntervalList(progress)
+	if err != nil {
+		return nil, err
+	}
+	maxId, err := getHighestTableId(table.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotTableState{
+		table,
+		completed,
+		slices.Clone(completed),
+		maxId,
+	}, nil
+}
+
+func (state *RealSnapshotState) addInitialPendingIntervals(tableState *SnapshotTableState) {
+	// We want to start with (number of gaps in the completed list) + 1 chunks.
+	chunksToAdd := 1
+	if len(tableState.CompletedIntervals) > 0 {
+		chunksToAdd = len(tableState.CompletedIntervals) + 1
+	}
+	for i := 0; i < chunksToAdd; i++ {
+		state.addNextPendingInterval(tableState)
+	}
+}
+
+// Starts snapshotting a table which wasn't included when we started up. If it was already snapshotted before
+// it was excluded, this does nothing.
+func (state *RealSnapshotState) AddTable(table *TableSchema) error {
+	if _, ok := state.Tables[table.Name]; ok {
+		return nil
+	}
+	tableState, err := state.loadTableState(table)
+	if err != nil || tableState == nil {
+		return err
+	}
+	state.Tables[table.Name] = tableState
+	state.addInitialPendingIntervals(tableState)
+	return nil
+}
+
+// Stops snapshotting a table. Its progress stays in the state storage, so if it's included again later, we'll
+// pick up where we left off.
+func (state *RealSnapshotState) RemoveTable(tableName string) {
+	delete(state.Tables, tableName)
+	for e := state.PendingIntervals.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(PendingInterval).Schema.Name == tableName {
+			state.PendingIntervals.Remove(e)
+		}
+		e = next
+	}
 }
 
-// Returns `false` if there's no more work for any worker to do.
+// Returns `false` if there's no work to hand out right now, either because it's all in progress or because the
+// only tables with work left are paused. Check Done() to see whether there's any work left at all.
 func (state *RealSnapshotState) GetNextPendingInterval() (PendingInterval, bool) {
-	if state.PendingIntervals.Front() == nil {
-		return PendingInterval{}, false
+	for e := state.PendingIntervals.Front(); e != nil; e = e.Next() {
+		nextInterval := e.Value.(PendingInterval)
+		if state.paused[nextInterval.Schema.Name] {
+			continue
+		}
+		state.PendingIntervals.Remove(e)
+		state.addNextPendingInterval(state.Tables[nextInterval.Schema.Name])
+		return nextInterval, true
 	}
-	nextInterval := state.PendingIntervals.Remove(state.PendingIntervals.Front()).(PendingInterval)
-	tableState := state.Tables[nextInterval.Schema.Name]
-	state.addNextPendingInterval(tableState)
-	return nextInterval, true
+	return PendingInterval{}, false
 }
 
 // Mark a chunk of work as done. If this is the last chunk of work for this table,
 // mark the entire table as done.
 func (state *RealSnapshotState) MarkIntervalDone(pi PendingInterval) error {
-	logger.Printf("Started MarkIntervalDone for %s (%v)", pi.Schema.Name, pi.Interval)
-	defer logger.Printf("Finished MarkIntervalDone for %s (%v)", pi.Schema.Name, pi.Interval)
-
-	tableState := state.Tables[pi.Schema.Name]
+	tableState, ok := state.Tables[pi.Schema.Name]
+	if !ok {
+		stateLogger.Info("Ignoring a completed interval for a table which is no longer being snapshotted.", "table", pi.Schema.Name, "interval", pi.Interval.String())
+		return nil
+	}
 	if tableState.CompletedIntervals.Includes(pi.Interval) {
 		panic(fmt.Errorf("Interval %v already completed for table %s (%v)", pi.Interval, tableState.Schema.Name, tableState.CompletedIntervals))
 	}
 	tableState.CompletedIntervals = tableState.CompletedIntervals.Merge(pi.Interval)
+	stateLogger.Debug("Marked interval done.", "table", pi.Schema.Name, "interval", pi.Interval.String())
 	if tableState.CompletedIntervals.HighestContiguous() > tableState.MaxId {
 		return state.markTableDone(tableState.Schema.Name)
 	}
-	return stateStorage.Set("table_snapshot_progress/" + tableState.Schema.Name, tableState.CompletedIntervals.String())
+	return stateStorage.Set("table_snapshot_progress/" + tableState.Schema.Name, tableState.CompletedIntervals.Encode())
+}
+
+// Puts an interval which we couldn't finish back at the front of the queue, so that it's handed out again next.
+// If its table isn't being snapshotted any more, we forget about it.
+func (state *RealSnapshotState) RequeueInterval(pi PendingInterval) {
+	if _, ok := state.Tables[pi.Schema.Name]; !ok {
+		return
+	}
+	state.PendingIntervals.PushFront(pi)
 }
 
 // Returns true if all tables have been fully snapshotted.
@@ -114,11 +202,64 @@ func (state *RealSnapshotState) Done() bool {
 	return len(state.Tables) == 0
 }
 
+// Returns the number of intervals of each table that haven't been handed out yet. Intervals we haven't queued
+// yet are assumed to be full-sized, so this is an estimate.
+func (state *RealSnapshotState) PendingIntervalCounts() map[string]int {
+	counts := make(map[string]int, len(state.Tables))
+	for e := state.PendingIntervals.Front(); e != nil; e = e.Next() {
+		counts[e.Value.(PendingInterval).Schema.Name]++
+	}
+	for tableName, table := range state.Tables {
+		busy := table.BusyIntervals.Size()
+		if table.MaxId + 1 > busy {
+			chunkSize := config.ChunkSize(tableName)
+			counts[tableName] += int((table.MaxId + 1 - busy + chunkSize - 1) / chunkSize)
+		}
+	}
+	return counts
+}
+
+// True if the table is still being snapshotted.
+func (state *RealSnapshotState) HasTable(tableName string) bool {
+	_, ok := state.Tables[tableName]
+	return ok
+}
+
+// Stops handing out intervals for a table until ResumeTable() is called. Intervals which are already in
+// progress still finish.
+func (state *RealSnapshotState) PauseTable(tableName string) {
+	state.paused[tableName] = true
+}
+
+func (state *RealSnapshotState) ResumeTable(tableName string) {
+	delete(state.paused, tableName)
+}
+
+// Reports the progress of each table which hasn't been completely snapshotted. The busy intervals are the
+// ones which have been handed out (or are about to be), but aren't done yet.
+func (state *RealSnapshotState) TableStatuses() map[string]TableStatus {
+	statuses := make(map[string]TableStatus, len(state.Tables))
+	for tableName, table := range state.Tables {
+		busy := table.BusyIntervals
+		for _, interval := range table.CompletedIntervals {
+			busy = busy.Subtract(interval)
+		}
+		statuses[tableName] = TableStatus{
+			MaxId: table.MaxId,
+			CompletedIntervals: table.CompletedIntervals.String(),
+			BusyIntervals: busy.String(),
+			Paused: state.paused[tableName],
+			completedIds: table.CompletedIntervals.Size(),
+		}
+	}
+	return statuses
+}
+
 // If there's still work to do on this table (any gaps in the list of completed
 // chunks, or chunks between the highest completed chunk and the upper bound),
 // add a new chunk to the work queue.
 func (state *RealSnapshotState) addNextPendingInterval(table *SnapshotTableState) {
-	gap := table.BusyIntervals.NextGap(config.SnapshotChunkSize)
+	gap := table.BusyIntervals.NextGap(config.ChunkSize(table.Schema.Name))
 	if gap.Start <= table.MaxId {
 		if gap.End > table.MaxId {
 			gap.End = table.MaxId + 1
@@ -131,49 +272,63 @@ func (state *RealSnapshotState) addNextPendingInterval(table *SnapshotTableState
 // Mark a table as done. This means that the entire table has been snapshotted and
 // there are no more chunks to process.
 func (state *RealSnapshotState) markTableDone(tableName string) error {
-	logger.Printf("Snapshot of table '%s' is complete.", tableName)
+	stateLogger.Info("Snapshot of table is complete.", "table", tableName)
 	delete(state.Tables, tableName)
 	return stateStorage.Set("table_snapshot_progress/" + tableName, "done")
 }
 
+// Remembers the schema the sinks were given for a table, so that it goes along with the rest of the state when
+// the state is exported. Binlog replay will need it to tell which schema change an event comes after.
+func saveTableSchema(schema *TableSchema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return stateStorage.Set("table_schema/" + schema.Name, string(data))
+}
+
 // True if we're out of sync with the replica and should start a new snapshot of
-// all tables from scratch.
-func needsSnapshot() bool {
+// all tables from scratch. Also returns the current binlog coordinates.
+func needsSnapshot() (bool, BinlogCoordinates, error) {
 	strpos, err := stateStorage.Get("last_committed_position")
 	if err != nil && err != redis.Nil {
-		panic(fmt.Errorf("Can't read last_committed_position from state storage: %s", err))
+		return false, BinlogCoordinates{}, fmt.Errorf("Can't read last_committed_position from state storage: %w", err)
 	}
-	position := 0
+	var position int64
 	if len(strpos) > 0 {
-		position = MustParseInt(strpos)
+		position, err = strconv.ParseInt(strpos, 10, 64)
+		if err != nil {
+			return false, BinlogCoordinates{}, fmt.Errorf("Bogus last_committed_position in state storage: '%s'", strpos)
+		}
 	}
 	gtids, err := stateStorage.Get("last_committed_gtid_set")
 	if err != nil && err != redis.Nil {
-		panic(fmt.Errorf("Can't read last_committed_gtid_set from state storage: %s", err))
+		return false, BinlogCoordinates{}, fmt.Errorf("Can't read last_committed_gtid_set from state storage: %w", err)
 	}
 
-	currentPosition, currentGtids, err := GetBinlogPosition()
+	coords, err := GetBinlogCoordinates()
 	if err != nil {
-		panic(err)
+		return false, BinlogCoordinates{}, err
 	}
-	purgedGtidsExist, err := DoPurgedGtidsExist(gtids, currentGtids)
+	currentPosition := ParseBinlogPosition(coords.File, coords.Pos)
+	purgedGtidsExist, err := DoPurgedGtidsExist(gtids, coords.GtidSet)
 	if err != nil {
-		panic(err)
+		return false, BinlogCoordinates{}, err
 	}
 
 	// If the current binlog position is less than the last committed position, it probably means that
 	// the MySQL server was rebuilt from scratch after some sort of catastrophe.
-	return currentPosition < uint64(position) || purgedGtidsExist
+	return currentPosition < uint64(position) || purgedGtidsExist, coords, nil
 }
 
-func getHighestTableId(tableName string) uint64 {
+func getHighestTableId(tableName string) (uint64, error) {
 	result, err := pool.Execute("SELECT MAX(id) FROM `" + tableName + "`")
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("Can't get the highest ID of table '%s': %w", tableName, err)
 	}
 	signedMaxId, err := result.GetInt(0, 0)
 	if err != nil {
-		panic(err)
+		return 0, fmt.Errorf("Can't get the highest ID of table '%s': %w", tableName, err)
 	}
-	return uint64(signedMaxId)
+	return uint64(signedMaxId), nil
 }
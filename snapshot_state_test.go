@@ -17,6 +17,12 @@ func FakeTableSchemas() []*TableSchema {
 	return schemas
 }
 
+func mustNewSnapshotState(t *testing.T, tables []*TableSchema) *RealSnapshotState {
+	state, _, err := NewSnapshotState(tables)
+	assert.NoError(t, err)
+	return state.(*RealSnapshotState)
+}
+
 // Helper to create a fake MySQL connection with the boilerplate responses needed by NewSnapshotState().
 func SetFakeSnapshotResponses(binlogPos int, gtidMax int, purgedGtids bool) {
 	var isSubset int64 = 1
@@ -42,7 +48,10 @@ func TestSnapshotStateNeedsSnapshot(t *testing.T) {
 		"last_committed_position": "1099511659000",
 		"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
 	}, func() {
-		assert.False(t, needsSnapshot())
+		needed, position, err := needsSnapshot()
+		assert.NoError(t, err)
+		assert.False(t, needed)
+		assert.Equal(t, BinlogCoordinates{"honk-bin-log.00001", 31337, "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-35000"}, position)
 	})
 
 	// Case where the server has purged GTIDs we need (purgedGtids == true)
@@ -51,7 +60,9 @@ func TestSnapshotStateNeedsSnapshot(t *testing.T) {
 		"last_committed_position": "1099511659000",
 		"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
 	}, func() {
-		assert.True(t, needsSnapshot())
+		needed, _, err := needsSnapshot()
+		assert.NoError(t, err)
+		assert.True(t, needed)
 	})
 
 	// Case where the binlog position has reset (currentPosition < lastCommittedPosition)
@@ -60,7 +71,9 @@ func TestSnapshotStateNeedsSnapshot(t *testing.T) {
 		"last_committed_position": "1099511659555",
 		"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
 	}, func() {
-		assert.True(t, needsSnapshot())
+		needed, _, err := needsSnapshot()
+		assert.NoError(t, err)
+		assert.True(t, needed)
 	})
 }
 
@@ -80,7 +93,7 @@ func TestSnapshotStateConcurrent(t *testing.T) {
 			FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(10_006)}}},
 			FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(10_006)}}},
 		)
-		state := NewSnapshotState(tables).(*RealSnapshotState)
+		state := mustNewSnapshotState(t, tables)
 		for _, table := range tables {
 			tableState := state.Tables[table.Name]
 			assert.Equal(t, IntervalList{}, tableState.CompletedIntervals)
@@ -149,7 +162,7 @@ func TestSnapshotStatePicksUpFromLastStop(t *testing.T) {
 			FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(999)}}},
 			FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(999)}}},
 		)
-		state := NewSnapshotState(tables).(*RealSnapshotState)
+		state := mustNewSnapshotState(t, tables)
 
 		for i := 0; i < 10; i++ {
 			interval, ok := state.GetNextPendingInterval()
@@ -169,7 +182,7 @@ func TestSnapshotStatePicksUpFromLastStop(t *testing.T) {
 			FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(999)}}},
 			FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(999)}}},
 		)
-		state = NewSnapshotState(tables).(*RealSnapshotState)
+		state = mustNewSnapshotState(t, tables)
 
 		count := 0
 		for !state.Done() {
@@ -181,3 +194,67 @@ func TestSnapshotStatePicksUpFromLastStop(t *testing.T) {
 		assert.Equal(t, 50, count)
 	})
 }
+
+func TestSnapshotStateCorruptProgress(t *testing.T) {
+	SetFakeSnapshotResponses(31337, 35000, false)
+	WithStateStorage(map[string]string{
+		"last_committed_position": "1099511659000",
+		"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
+		"table_snapshot_progress/foo": "~!!!",
+	}, func() {
+		_, _, err := NewSnapshotState(FakeTableSchemas()[:1])
+		assert.ErrorContains(t, err, "Can't load the snapshot progress of table 'foo': Can't decode compact interval list")
+	})
+}
+
+func TestSnapshotStateAddAndRemoveTables(t *testing.T) {
+	stateStorage.ClearAll()
+	WithConfig("SNAPSHOT_CHUNK_SIZE", "100", func() {
+		tables := FakeTableSchemas()
+		SetFakeSnapshotResponses(31337, 35000, false)
+		AddFakeResponses(FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(999)}}})
+		state := mustNewSnapshotState(t, tables[:1])
+
+		assert.Equal(t, map[string]int{"foo": 10}, state.PendingIntervalCounts())
+		interval, ok := state.GetNextPendingInterval()
+		assert.True(t, ok)
+		assert.Equal(t, map[string]int{"foo": 9}, state.PendingIntervalCounts())
+		assert.Equal(t, map[string]TableStatus{
+			"foo": {MaxId: 999, CompletedIntervals: "", BusyIntervals: "0-200"},
+		}, state.TableStatuses())
+		state.RemoveTable("foo")
+		assert.True(t, state.Done())
+		assert.Equal(t, 0, state.PendingIntervals.Len())
+		assert.NoError(t, state.MarkIntervalDone(interval))
+
+		AddFakeResponses(FakeMysqlResponse{false, 0, []string{"MAX(id)"}, [][]any{{int64(999)}}})
+		assert.NoError(t, state.AddTable(tables[1]))
+		assert.False(t, state.Done())
+		assert.Equal(t, 1, state.PendingIntervals.Len())
+
+		// Paused tables keep their pending intervals, but don't hand them out.
+		state.PauseTable("bar")
+		assert.True(t, state.HasTable("bar"))
+		assert.True(t, state.TableStatuses()["bar"].Paused)
+		_, ok = state.GetNextPendingInterval()
+		assert.False(t, ok)
+		assert.False(t, state.Done())
+		state.ResumeTable("bar")
+		interval, ok = state.GetNextPendingInterval()
+		assert.True(t, ok)
+		assert.Equal(t, "bar", interval.Schema.Name)
+
+		// A re-queued interval is handed out again before anything else.
+		state.RequeueInterval(interval)
+		requeued, ok := state.GetNextPendingInterval()
+		assert.True(t, ok)
+		assert.Equal(t, interval, requeued)
+		state.RequeueInterval(PendingInterval{tables[0], interval.Interval})
+		assert.NotEqual(t, "foo", state.PendingIntervals.Front().Value.(PendingInterval).Schema.Name)
+
+		// A table that was finished before it was excluded doesn't need to be snapshotted again.
+		stateStorage.Set("table_snapshot_progress/baz", "done")
+		assert.NoError(t, state.AddTable(tables[2]))
+		assert.NotContains(t, state.Tables, "baz")
+	})
+}
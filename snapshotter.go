@@ -1,48 +1,134 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"math/big"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
 const MAX_RETRIES = 10   // Picked this number out of the air. Let's revisit this later.
+const SNAPSHOT_METRICS_INTERVAL = 10 * time.Second
 
 type Snapshotter struct {
 	State SnapshotState
 	Workers *WorkerGroup
+	Sinks *SinkManager                     // Set up by Run(), since the sinks may not exist yet when we're created.
+	Position BinlogCoordinates             // Where the binlog was when the snapshot started.
 	PendingIntervalsChan chan PendingInterval
 	CompletedIntervalsChan chan PendingInterval
+	FailedIntervalsChan chan PendingInterval  // Intervals the workers gave up on, which need to be done again.
 	ExitChan chan struct{}
+	ReloadChan chan struct{}
+	exitOnce sync.Once
+	statusChan chan chan SnapshotStatus
+	adminChan chan adminCommand
+	stopped chan struct{}                  // Closed once Run() is out of its loop, after setting finalStatus.
+	finalStatus SnapshotStatus
+
+	nextInterval PendingInterval           // The interval we'll hand out next, if haveNext is true.
+	haveNext bool
+	pendingClosed bool                     // True once PendingIntervalsChan is closed because all the work is done.
+	paused bool                            // True if an admin has paused the whole snapshot.
+	resnapshots map[string]string          // Tables being re-snapshotted, with the progress they had before.
+
+	stopWorkerChan chan struct{}
+	workerCount int                        // Workers which haven't been told to stop.
+	workersToStop int                      // Workers we still need to send a stop to.
+	workersStarted int                     // Gives each worker its own ID for the logs.
+	busyIntervals map[string]int           // How many intervals of each table the workers are working on.
+	drainingTables map[string]*TableSchema // Excluded tables that still have intervals in progress.
+	startedAt time.Time
+	completedThisRun map[string]uint64     // How many IDs of each table we've snapshotted since we started.
+	gaugedTables map[string]bool           // Tables we last reported interval gauges for.
 }
 
-func NewSnapshotter() *Snapshotter {
+func NewSnapshotter() (*Snapshotter, error) {
 	tables, err := ListTables()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	schemas := []*TableSchema{}
 	for _, tableName := range tables {
 		schema, err := GetTableSchema(tableName)
 		if err != nil {
-			panic(err)
+			return nil, &SnapshotError{Err: err, Table: tableName}
 		}
 		schemas = append(schemas, schema)
 	}
 
-	return NewCustomSnapshotter(NewSnapshotState(schemas))
+	// Every table gets opened, even if it's already been snapshotted, since the binlog streaming will need them.
+	for _, schema := range schemas {
+		if err := openTableOnSinks(schema); err != nil {
+			return nil, &SnapshotError{Err: err, Table: schema.Name}
+		}
+	}
+	return newSnapshotterForTables(schemas)
+}
+
+// Snapshots the tables an admin asked to re-snapshot after the first snapshot was over. They're already open
+// on the sinks.
+func NewResnapshotter() (*Snapshotter, error) {
+	keys, err := stateStorage.Keys("table_resnapshot/")
+	if err != nil {
+		return nil, err
+	}
+	schemas := []*TableSchema{}
+	for _, key := range keys {
+		tableName := strings.TrimPrefix(key, "table_resnapshot/")
+		schema, err := GetTableSchema(tableName)
+		if err != nil {
+			return nil, &SnapshotError{Err: err, Table: tableName}
+		}
+		schemas = append(schemas, schema)
+	}
+	return newSnapshotterForTables(schemas)
+}
+
+func newSnapshotterForTables(schemas []*TableSchema) (*Snapshotter, error) {
+	state, position, err := NewSnapshotState(schemas)
+	if err != nil {
+		return nil, err
+	}
+	s := NewCustomSnapshotter(state)
+	s.Position = position
+	if err := s.loadAdminState(); err != nil {
+		return nil, fmt.Errorf("Can't load the admin state: %w", err)
+	}
+	return s, nil
 }
 
 func NewCustomSnapshotter(state SnapshotState) *Snapshotter {
 	return &Snapshotter{
 		state,
 		NewWorkerGroup(),
+		nil,
+		BinlogCoordinates{},
 		make(chan PendingInterval),
 		make(chan PendingInterval),
+		make(chan PendingInterval),
+		make(chan struct{}),
+		make(chan struct{}, 1),
+		sync.Once{},
+		make(chan chan SnapshotStatus),
+		make(chan adminCommand),
+		make(chan struct{}),
+		SnapshotStatus{},
+		PendingInterval{}, false, false, false,
+		map[string]string{},
 		make(chan struct{}),
+		0, 0, 0,
+		map[string]int{},
+		map[string]*TableSchema{},
+		time.Time{},
+		map[string]uint64{},
+		map[string]bool{},
 	}
 }
 
@@ -50,102 +136,412 @@ func NewCustomSnapshotter(state SnapshotState) *Snapshotter {
 func (s *Snapshotter) Run() bool {
 	successfulExit := true
 	if s.State.Done() {
+		s.stop()
 		return true
 	}
 
-	for i := 0; i < int(config.SnapshotWorkers); i++ {
-		s.Workers.Go(s.runWorker)
-	}
-	logger.Printf("Started %d snapshot workers.", config.SnapshotWorkers)
+	s.startedAt = time.Now()
+	s.Sinks = NewSinkManager(sinks)
+	s.resizeWorkers(int(config.Workers()))
+	snapshotLogger.Info("Started snapshot workers.", "workers", config.Workers())
 
-	nextInterval, ok := s.State.GetNextPendingInterval()
-	if !ok {
+	s.refillNextInterval()
+	if !s.haveNext {
 		panic(fmt.Errorf("No pending intervals at the start of the snapshot?"))
 	}
 
-	pendingChan := s.PendingIntervalsChan
+	metricsTicker := time.NewTicker(SNAPSHOT_METRICS_INTERVAL)
+	defer metricsTicker.Stop()
+
 	loop: for {
+		var pendingChan chan PendingInterval
+		if s.haveNext && !s.paused && s.handingOutWork() {
+			pendingChan = s.PendingIntervalsChan
+		}
+		var stopChan chan struct{}
+		if s.workersToStop > 0 {
+			stopChan = s.stopWorkerChan
+		}
+
 		select {
-		case pendingChan <- nextInterval:
-			nextInterval, ok = s.State.GetNextPendingInterval()
-			if !ok {
-				close(s.PendingIntervalsChan)
-				pendingChan = nil
-			}
+		case pendingChan <- s.nextInterval:
+			s.busyIntervals[s.nextInterval.Schema.Name]++
+			s.haveNext = false
+			s.refillNextInterval()
 
 		case completedInterval := <- s.CompletedIntervalsChan:
+			tableName := completedInterval.Schema.Name
+			s.busyIntervals[tableName]--
+			metrics.Count("snapshot.intervals_completed", 1, tableTag(tableName))
+			s.completedThisRun[tableName] += completedInterval.Interval.End - completedInterval.Interval.Start
 			err := s.State.MarkIntervalDone(completedInterval)
 			if err != nil {
-				panic(err)
+				// The workers will all exit, and we'll stop handing out work.
+				s.Workers.Exit(&SnapshotError{Err: err, Table: tableName, Interval: completedInterval.Interval.String()})
 			}
+			s.intervalFinished(tableName)
+			s.refillNextInterval()
+
+		case failedInterval := <- s.FailedIntervalsChan:
+			tableName := failedInterval.Schema.Name
+			s.busyIntervals[tableName]--
+			metrics.Count("snapshot.intervals_requeued", 1, tableTag(tableName))
+			s.State.RequeueInterval(failedInterval)
+			s.intervalFinished(tableName)
+			s.refillNextInterval()
+
+		case <-metricsTicker.C:
+			s.reportMetrics()
+
+		case replyChan := <-s.statusChan:
+			replyChan <- s.status()
+
+		case stopChan <- struct{}{}:
+			s.workersToStop--
+			s.workerCount--
+
+		case <-s.ReloadChan:
+			s.reload()
+			// The interval we were about to hand out may belong to a table that was just excluded.
+			if s.haveNext && config.IsExcluded(s.nextInterval.Schema.Name) {
+				s.haveNext = false
+			}
+			s.refillNextInterval()
+
+		case command := <-s.adminChan:
+			command.reply <- s.runAdminCommand(command)
+			s.refillNextInterval()
 
 		case <-s.ExitChan:
-			logger.Printf("Signalling all workers to exit.")
+			// Workers that are in the middle of an interval will finish it, and we keep marking intervals done
+			// until they've all exited, so that none of their work is lost.
+			snapshotLogger.Info("Signalling all workers to exit once their current intervals are done.")
 			s.Workers.Exit(nil)
 			s.ExitChan = nil
 			successfulExit = false
 
 		case <-s.Workers.DoneSignal():
-			logger.Printf("The snapshot is complete.")
+			snapshotLogger.Info("The snapshot is complete.")
 			break loop
 		}
 	}
+	s.stop()
 
 	err := s.Workers.Wait()
-	fmt.Printf("Snapshot.Run() is done: %s.\n", err)
+	if err != nil {
+		snapshotLogger.Error("The snapshot failed", "error", err)
+		reportError(err)
+	}
 	return err == nil && successfulExit
 }
 
+// Tidies up after a worker is done with an interval of a table, whether it succeeded or not.
+func (s *Snapshotter) intervalFinished(tableName string) {
+	if s.busyIntervals[tableName] == 0 {
+		delete(s.busyIntervals, tableName)
+		s.finishDraining(tableName)
+	}
+	if _, ok := s.resnapshots[tableName]; ok && !s.State.HasTable(tableName) && s.drainingTables[tableName] == nil {
+		snapshotLogger.Info("Finished re-snapshotting table.", "table", tableName)
+		s.forgetResnapshot(tableName)
+	}
+}
+
+// Makes sure we have an interval ready to hand out, if there's one available. Once there's no work left at all,
+// we close PendingIntervalsChan so that the workers exit.
+func (s *Snapshotter) refillNextInterval() {
+	if s.haveNext || !s.handingOutWork() {
+		return
+	}
+	s.nextInterval, s.haveNext = s.State.GetNextPendingInterval()
+	if !s.haveNext && s.State.Done() {
+		close(s.PendingIntervalsChan)
+		s.pendingClosed = true
+	}
+}
+
+// False once all the work is done, we've been told to exit, or a worker has failed.
+func (s *Snapshotter) handingOutWork() bool {
+	select {
+	case <-s.Workers.ExitSignal():
+		return false
+	default:
+		return !s.pendingClosed && s.ExitChan != nil
+	}
+}
+
+// Tells the snapshot to stop handing out work. Safe to call more than once.
 func (s *Snapshotter) Exit() {
-	close(s.ExitChan)
+	s.exitOnce.Do(func() {
+		close(s.ExitChan)
+	})
 }
 
-func (s *Snapshotter) runWorker() error {
+// Asks the Run loop how the snapshot is going. Returns false if it doesn't answer within the timeout.
+func (s *Snapshotter) Status(timeout time.Duration) (SnapshotStatus, bool) {
+	replyChan := make(chan SnapshotStatus, 1)
+	select {
+	case s.statusChan <- replyChan:
+		return <-replyChan, true
+	case <-s.stopped:
+		return s.finalStatus, true
+	case <-time.After(timeout):
+		return SnapshotStatus{}, false
+	}
+}
+
+func (s *Snapshotter) status() SnapshotStatus {
+	busyWorkers := 0
+	for _, count := range s.busyIntervals {
+		busyWorkers += count
+	}
+	status := SnapshotStatus{
+		s.paused,
+		WorkerStatus{config.Workers(), s.workerCount - s.workersToStop, busyWorkers},
+		s.State.TableStatuses(),
+		false,
+	}
+	elapsed := time.Since(s.startedAt)
+	for tableName, tableStatus := range status.Tables {
+		estimateProgress(&tableStatus, s.completedThisRun[tableName], elapsed)
+		_, tableStatus.Resnapshot = s.resnapshots[tableName]
+		status.Tables[tableName] = tableStatus
+	}
+	return status
+}
+
+// Nobody's going to answer statusChan any more, so Status() answers with how things were when we stopped.
+func (s *Snapshotter) stop() {
+	s.finalStatus = s.status()
+	s.finalStatus.Done = true
+	close(s.stopped)
+}
+
+// Tables that are finished or taken out of the snapshot get zeroes one last time, so that their gauges don't stay
+// stuck at whatever they were when we stopped reporting them.
+func (s *Snapshotter) reportMetrics() {
+	pending := s.State.PendingIntervalCounts()
+	tables := map[string]bool{}
+	for tableName := range pending {
+		tables[tableName] = true
+	}
+	for tableName := range s.busyIntervals {
+		tables[tableName] = true
+	}
+	for tableName := range s.gaugedTables {
+		if !tables[tableName] {
+			metrics.Gauge("snapshot.intervals_pending", 0, tableTag(tableName))
+			metrics.Gauge("snapshot.intervals_busy", 0, tableTag(tableName))
+		}
+	}
+	for tableName := range tables {
+		metrics.Gauge("snapshot.intervals_pending", float64(pending[tableName]), tableTag(tableName))
+		metrics.Gauge("snapshot.intervals_busy", float64(s.busyIntervals[tableName]), tableTag(tableName))
+	}
+	s.gaugedTables = tables
+	metrics.Gauge("snapshot.workers", float64(s.workerCount - s.workersToStop))
+	s.Sinks.reportMetrics()
+}
+
+// Asks the Run loop to re-read the configuration. If a reload is already waiting, this does nothing.
+func (s *Snapshotter) Reload() {
+	select {
+	case s.ReloadChan <- struct{}{}:
+	default:
+	}
+}
+
+// Re-reads the configuration and applies the changes to the running snapshot. Only call this from Run().
+func (s *Snapshotter) reload() {
+	current := config.Copy()
+	newConfig, err := ReloadConfig(current)
+	if err != nil {
+		snapshotLogger.Error("Not reloading the configuration, because it's invalid", "error", err)
+		return
+	}
+	newlyExcluded, newlyIncluded := excludedTableChanges(current, newConfig)
+	config.ApplyReload(newConfig)
+	snapshotLogger.Info("Reloaded the configuration.")
+
+	s.resizeWorkers(int(config.Workers()))
+
+	for _, tableName := range newlyExcluded {
+		schema, err := GetTableSchema(tableName)
+		if err != nil {
+			snapshotLogger.Error("Can't stop snapshotting table", "table", tableName, "error", err)
+			continue
+		}
+		snapshotLogger.Info("Table is now excluded. Stopping its snapshot.", "table", tableName)
+		s.State.RemoveTable(tableName)
+		s.forgetResnapshot(tableName)
+		s.drainingTables[tableName] = schema
+		if s.busyIntervals[tableName] == 0 {
+			s.finishDraining(tableName)
+		}
+	}
+
+	if len(newlyIncluded) == 0 {
+		return
+	}
+	tables, err := ListTables()
+	if err != nil {
+		snapshotLogger.Error("Can't list tables, so newly included tables won't be snapshotted", "error", err)
+		return
+	}
+	for _, tableName := range newlyIncluded {
+		if !StringInList(tableName, tables) {
+			continue
+		} else if _, draining := s.drainingTables[tableName]; draining {
+			continue  // We'll add it back once its in-progress intervals are done.
+		}
+		s.includeTable(tableName, true)
+	}
+}
+
+// Starts or stops workers until we have 'count' of them. We can't start new ones once we've stopped handing out
+// work, since the WorkerGroup may already be done by then.
+func (s *Snapshotter) resizeWorkers(count int) {
+	running := s.workerCount - s.workersToStop
+	if count < running {
+		s.workersToStop += running - count
+	} else if count > running {
+		// Take back any stops we haven't delivered yet before starting new workers.
+		cancelled := min(s.workersToStop, count - running)
+		s.workersToStop -= cancelled
+		if s.handingOutWork() {
+			for i := running + cancelled; i < count; i++ {
+				workerId := s.workersStarted
+				s.Workers.Go(func() error { return s.runWorker(workerId) })
+				s.workerCount++
+				s.workersStarted++
+			}
+		}
+	}
+	if running != count && s.workerCount > 0 {
+		snapshotLogger.Info("Resizing the snapshot workers.", "workers", count)
+	}
+}
+
+// Called once a removed table has no more intervals in progress. If it's been excluded, we close it on the
+// sinks; if it was included again in the meantime (or is being re-snapshotted), we start snapshotting it again.
+func (s *Snapshotter) finishDraining(tableName string) {
+	schema, ok := s.drainingTables[tableName]
+	if !ok {
+		return
+	}
+	delete(s.drainingTables, tableName)
+
+	if config.IsExcluded(tableName) {
+		if err := closeTableOnSinks(schema); err != nil {
+			snapshotLogger.Error("Can't close table on the sinks", "table", tableName, "error", err)
+		}
+	} else {
+		s.includeTable(tableName, false)  // We never closed it on the sinks.
+	}
+}
+
+// Starts snapshotting a table, or picks up where its stored progress left off. 'openSinks' is false if the table
+// is still open on the sinks.
+func (s *Snapshotter) includeTable(tableName string, openSinks bool) {
+	if !s.handingOutWork() {
+		snapshotLogger.Info("Table is now included, but this snapshot is finishing. It'll be snapshotted at the next restart.", "table", tableName)
+		return
+	}
+
+	schema, err := GetTableSchema(tableName)
+	if err == nil && openSinks {
+		err = openTableOnSinks(schema)
+	}
+	if err == nil {
+		err = s.State.AddTable(schema)
+	}
+	if err != nil {
+		snapshotLogger.Error("Can't start snapshotting table", "table", tableName, "error", err)
+		return
+	}
+	snapshotLogger.Info("Table is now included. Starting its snapshot.", "table", tableName)
+}
+
+func (s *Snapshotter) runWorker(workerId int) error {
+	log := snapshotLogger.With("worker", workerId)
 	for {
 		select {
 		case pi, ok := <-s.PendingIntervalsChan:
 			if !ok {
-				fmt.Printf("Snapshot worker exited because all pending intervals are done\n")
+				log.Debug("Snapshot worker exited because all pending intervals are done.")
 				return nil
 			}
+			log.Debug("Snapshotting interval.", "table", pi.Schema.Name, "interval", pi.Interval.String())
 
 			result, err := getRowChunk(pi)
 			if err != nil {
-				panic(err)
+				return s.workerError(log, err, pi, rowChunkSql(pi), "")
 			}
-			rowsEvent := rowsEventFromMysqlResult(pi.Schema, result)
-
-			for _, sink := range sinks {
-				sink.WriteRows(rowsEvent)
+			rowsEvent, err := rowsEventFromMysqlResult(pi.Schema, result)
+			if err != nil {
+				return s.workerError(log, err, pi, rowChunkSql(pi), "")
 			}
-			for _, sink := range sinks {
-				// FIXME: We probably want a timeout here to make some timing guarantees!
-				// We want to know if it looks like a sink has gotten stuck.
-				if err := <-rowsEvent.ResponseChan; err != nil {
-					panic(fmt.Errorf("Error writing to %s sink: %s", reflect.TypeOf(sink).Kind(), err))
-				}
+			rowsEvent.Source = RowsSource{true, s.Position}
+			metrics.Count("snapshot.rows", int64(len(rowsEvent.Data)), tableTag(pi.Schema.Name))
+
+			// This only blocks if a sink's buffer is full, so we can get on with the next interval while the sinks
+			// write this one.
+			batch := s.Sinks.Write(log, pi, rowsEvent, s.Workers.ExitSignal())
+			if batch == nil {
+				log.Debug("Snapshot worker exited with ExitSignal while waiting for a sink.")
+				return nil
 			}
+			s.Workers.Go(func() error { return s.finishInterval(log, pi, batch) })
 
-			s.CompletedIntervalsChan <- pi
+		case <-s.stopWorkerChan:
+			log.Debug("Snapshot worker exited because there are too many workers.")
+			return nil
 
 		case <-s.Workers.ExitSignal():
-			fmt.Printf("Snapshot worker exited with ExitSignal\n")
+			log.Debug("Snapshot worker exited with ExitSignal.")
 			return nil
 		}
 	}
 }
 
+// Waits for the sinks to acknowledge an interval, then tells Run() how it went.
+func (s *Snapshotter) finishInterval(log *slog.Logger, pi PendingInterval, batch *SinkBatch) error {
+	sink, err := batch.Wait()
+	if errors.Is(err, ErrSinkStuck) && config.SinkStuckPolicy == "requeue" {
+		log.Warn("Re-queueing the interval, since a sink is stuck.", "table", pi.Schema.Name, "interval", pi.Interval.String(), "sink", sink.Name)
+		s.FailedIntervalsChan <- pi
+		return nil
+	}
+	if err != nil {
+		return s.workerError(log, err, pi, "", sink.Name)
+	}
+	s.CompletedIntervalsChan <- pi
+	return nil
+}
+
+// Wraps an error with everything we know about what the worker was doing, so that it gets into the logs and
+// the Bugsnag report. Returning it makes all the workers exit.
+func (s *Snapshotter) workerError(log *slog.Logger, err error, pi PendingInterval, sql, sink string) error {
+	err = &SnapshotError{err, pi.Schema.Name, pi.Interval.String(), sql, sink}
+	log.Error("Snapshot worker failed", "error", err)
+	return err
+}
+
 func getRowChunk(pi PendingInterval) (IMysqlResult, error) {
 	retries := 0
 	var result IMysqlResult
 	var err error
 
+	sql := rowChunkSql(pi)
+	table := tableTag(pi.Schema.Name)
 	for retries < MAX_RETRIES {
-		sql := fmt.Sprintf("SELECT * FROM `%s` WHERE `id` >= %d AND `id` < %d", pi.Schema.Name, pi.Interval.Start, pi.Interval.End)
+		start := time.Now()
 		result, err = pool.Execute(sql)
+		metrics.Timing("snapshot.chunk_query_time", time.Since(start), table)
 		if err == nil {
 			return result, nil
 		} else {
+			metrics.Count("snapshot.chunk_query_retries", 1, table)
 			// FIXME: If the error looks like a schema issue, re-fetch the CREATE TABLE and parse the schema
 			// again before we retry. (We also need some way to notify the sink that this has happened, so
 			// this might need to be done at the runWorker level.)
@@ -163,87 +559,126 @@ func getRowChunk(pi PendingInterval) (IMysqlResult, error) {
 	return nil, err
 }
 
-func rowsEventFromMysqlResult(schema *TableSchema, result IMysqlResult) RowsEvent {
-	event := RowsEvent{make(chan error), schema, make([][]any, result.RowNumber())}
+// We list the columns explicitly, since the config file may have told us to leave some of them out.
+func rowChunkSql(pi PendingInterval) string {
+	columns := make([]string, 0, len(pi.Schema.Columns))
+	for _, column := range pi.Schema.Columns {
+		if !isSyntheticColumn(column) {
+			columns = append(columns, "`" + column.Name + "`")
+		}
+	}
+	sql := fmt.Sprintf(
+		"SELECT %s FROM `%s` WHERE `id` >= %d AND `id` < %d",
+		strings.Join(columns, ", "), pi.Schema.Name, pi.Interval.Start, pi.Interval.End,
+	)
+	if filter := config.RowFilter(pi.Schema.Name); filter != "" {
+		sql += " AND (" + filter + ")"
+	}
+	return sql
+}
 
-	for r := 0; r < result.RowNumber(); r++ {
-		event.Data[r] = make([]any, len(schema.Columns))
+// Rows with values we can't convert get dead-lettered and left out. Returns an error if we can't read the result,
+// or if we've gone over the dead-letter budget.
+func rowsEventFromMysqlResult(schema *TableSchema, result IMysqlResult) (RowsEvent, error) {
+	event := RowsEvent{nil, schema, make([][]any, 0, result.RowNumber()), RowsSource{}}  // Each sink gets its own ResponseChan.
+
+	rows: for r := 0; r < result.RowNumber(); r++ {
+		row := make([]any, len(schema.Columns))
+		resultColumn := 0
 		for c, column := range schema.Columns {
-			value, err := result.GetValue(r, c)
+			if isSyntheticColumn(column) {
+				row[c] = syntheticColumnValue(column)
+				continue
+			}
+			value, err := result.GetValue(r, resultColumn)
 			if err != nil {
-				panic(err)
+				return event, fmt.Errorf("Row %d, column '%s': %w", r, column.Name, err)
 			}
-			event.Data[r][c] = convertValueFromMysql(value, column)
+			row[c], err = convertValueFromMysql(value, column)
+			if err != nil {
+				letter := DeadLetter{time.Now(), schema.Name, rowPrimaryKey(schema, result, r), column.Name, rawMysqlBytes(value), err.Error()}
+				if err = deadLetters.Add(letter); err != nil {
+					return event, fmt.Errorf("Row %d, column '%s': %w", r, column.Name, err)
+				}
+				continue rows
+			}
+			resultColumn++
 		}
+		event.Data = append(event.Data, row)
 	}
-	return event
+	return event, nil
 }
 
-func convertValueFromMysql(value any, column Column) any {
-	if value == nil {
+// The row's primary key, for the dead letters. It's the raw value from MySQL, since the key might be the thing we
+// couldn't convert.
+func rowPrimaryKey(schema *TableSchema, result IMysqlResult, r int) any {
+	pk := schema.PrimaryKeyIndex()
+	if pk < 0 {
 		return nil
 	}
+	value, err := result.GetValue(r, pk)
+	if err != nil {
+		return nil
+	}
+	if bytes, ok := value.([]byte); ok {
+		return string(bytes)
+	}
+	return value
+}
+
+func convertValueFromMysql(value any, column Column) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
 
 	switch value.(type) {
 	case uint64:
 		switch column.SqlType {
-		case "bigint": return value.(uint64)
-		case "int": return uint32(value.(uint64))
-		case "mediumint": return uint32(value.(uint64))
-		case "smallint": return uint16(value.(uint64))
-		case "tinyint": return uint8(value.(uint64))
-		default: panic(fmt.Errorf("Unknown type for uint64 MySQL value: %s / %s ('%d')", reflect.TypeOf(value).String(), column.SqlType, value.(uint64)))
+		case "bigint": return value.(uint64), nil
+		case "int": return uint32(value.(uint64)), nil
+		case "mediumint": return uint32(value.(uint64)), nil
+		case "smallint": return uint16(value.(uint64)), nil
+		case "tinyint": return uint8(value.(uint64)), nil
+		default: return nil, fmt.Errorf("Unknown type for uint64 MySQL value: %s / %s ('%d')", reflect.TypeOf(value).String(), column.SqlType, value.(uint64))
 		}
 	case int64:
 		switch column.SqlType {
-		case "bigint": return value.(int64)
-		case "int": return int32(value.(int64))
-		case "mediumint": return int32(value.(int64))
-		case "smallint": return int16(value.(int64))
-		case "tinyint": return int8(value.(int64))
-		default: panic(fmt.Errorf("Unknown type for int64 MySQL value: %s / %s ('%d')", reflect.TypeOf(value).String(), column.SqlType, value.(int64)))
+		case "bigint": return value.(int64), nil
+		case "int": return int32(value.(int64)), nil
+		case "mediumint": return int32(value.(int64)), nil
+		case "smallint": return int16(value.(int64)), nil
+		case "tinyint": return int8(value.(int64)), nil
+		default: return nil, fmt.Errorf("Unknown type for int64 MySQL value: %s / %s ('%d')", reflect.TypeOf(value).String(), column.SqlType, value.(int64))
 		}
 	case float64:
 		switch column.SqlType {
-		case "float": return float32(value.(float64))
-		case "double": return value.(float64)
-		default: panic(fmt.Errorf("Unknown type for float64 MySQL value: %s / %s ('%f')", reflect.TypeOf(value).String(), column.SqlType, value.(float64)))
+		case "float": return float32(value.(float64)), nil
+		case "double": return value.(float64), nil
+		default: return nil, fmt.Errorf("Unknown type for float64 MySQL value: %s / %s ('%f')", reflect.TypeOf(value).String(), column.SqlType, value.(float64))
 		}
 	case []uint8:
 		s := string(value.([]uint8))
 		switch column.SqlType {
 		case "char", "varchar", "text", "mediumtext", "longtext":
-			return s
+			return s, nil
 		case "binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob":
-			return value.([]uint8)
+			return value.([]uint8), nil
 		case "decimal":
 			dec, success := big.NewRat(0, 1).SetString(s)
 			if !success {
-				panic(fmt.Errorf("Can't convert string '%s' to decimal", s))
+				return nil, fmt.Errorf("Can't convert string '%s' to decimal", s)
 			}
-			return dec
+			return dec, nil
 		case "date":
-			t, err := time.Parse("2006-01-02", s)
-			if err != nil {
-				panic(err)
-			}
-			return t
+			return time.Parse("2006-01-02", s)
 		case "time":
-			t, err := time.Parse("15:04:05", s)
-			if err != nil {
-				panic(err)
-			}
-			return t
+			return time.Parse("15:04:05", s)
 		case "datetime", "timestamp":
-			t, err := time.Parse("2006-01-02 15:04:05", s)
-			if err != nil {
-				panic(err)
-			}
-			return t
+			return time.Parse("2006-01-02 15:04:05", s)
 		default:
-			panic(fmt.Errorf("Unknown type for string MySQL value: %s / %s ('%s')", reflect.TypeOf(value).String(), column.SqlType, s))
+			return nil, fmt.Errorf("Unknown type for string MySQL value: %s / %s ('%s')", reflect.TypeOf(value).String(), column.SqlType, s)
 		}
 	default:
-		panic("Unknown type for MySQL value: " + reflect.TypeOf(value).String())
+		return nil, fmt.Errorf("Unknown type for MySQL value: %s", reflect.TypeOf(value).String())
 	}
 }
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"math"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/bugsnag/bugsnag-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -41,8 +42,117 @@ func TestSnapshotterCompletes(t *testing.T) {
 	})
 }
 
+func TestSnapshotterWorkerError(t *testing.T) {
+	SetFakeResponses(
+		FakeMysqlResponse{false, math.MaxInt, []string{"id"}, [][]any{{"not an integer"}}},
+	)
+	state := NewFakeSnapshotState([]string{"foo"}, 10)
+	snapshotter := NewCustomSnapshotter(state)
+
+	WithDeadLetters(0, func(letters *[]DeadLetter) {
+		WithBugsnagReports(func(reports *[]bugsnag.MetaData) {
+			assert.False(t, snapshotter.Run())
+			assert.Len(t, *reports, 1)
+			metadata := (*reports)[0]["snapshot"]
+			assert.Equal(t, "foo", metadata["table"])
+			assert.Equal(t, "SELECT `id` FROM `foo` WHERE `id` >= 0 AND `id` < 100000", metadata["sql"])
+			assert.Equal(t, "0-100000", metadata["interval"])
+		})
+		assert.Len(t, *letters, 1)
+	})
+}
+
+// Snapshots 10 intervals of "foo" into the sink, which has a 20ms write deadline.
+func runWithStuckSink(t *testing.T, sink *FakeSink, policy string, fn func(ok bool, state SnapshotState, recorder *RecordingMetrics)) {
+	sinks = []Sink{sink}
+	defer func() { sinks = nil }()
+	SetFakeResponses(
+		FakeMysqlResponse{false, math.MaxInt, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+
+	os.Setenv("SNAPSHOT_CHUNK_SIZE", "100")
+	defer os.Unsetenv("SNAPSHOT_CHUNK_SIZE")
+	os.Setenv("SINK_WRITE_TIMEOUTS", "CsvSink=1h,FakeSink=20ms")
+	defer os.Unsetenv("SINK_WRITE_TIMEOUTS")
+	WithRecordingMetrics(func(recorder *RecordingMetrics) {
+		WithConfig("SINK_STUCK_POLICY", policy, func() {
+			state := NewFakeSnapshotState([]string{"foo"}, 1000)
+			ok := NewCustomSnapshotter(state).Run()
+			fn(ok, state, recorder)
+		})
+	})
+}
+
+// Every write misses a deadline or two, but the re-queued intervals wait for the writes that are already
+// running, rather than sending the rows again.
+func TestSnapshotterStuckSinkRequeue(t *testing.T) {
+	sink := &FakeSink{Delay: 50 * time.Millisecond}
+	runWithStuckSink(t, sink, "requeue", func(ok bool, state SnapshotState, recorder *RecordingMetrics) {
+		assert.True(t, ok)
+		assert.True(t, state.Done())
+		assert.Equal(t, 10, sink.RowsEvents)
+		stuck := recorder.Counts["sink.stuck|table:foo|sink:FakeSink"]
+		assert.GreaterOrEqual(t, stuck, int64(10))
+		assert.Equal(t, stuck, recorder.Counts["snapshot.intervals_requeued|table:foo"])
+		assert.Equal(t, int64(10), recorder.Counts["snapshot.intervals_completed|table:foo"])
+	})
+}
+
+func TestSnapshotterStuckSinkRetry(t *testing.T) {
+	sink := &FakeSink{Delay: 50 * time.Millisecond}
+	runWithStuckSink(t, sink, "retry", func(ok bool, state SnapshotState, recorder *RecordingMetrics) {
+		assert.True(t, ok)
+		assert.True(t, state.Done())
+		assert.Equal(t, 10, sink.RowsEvents)
+		assert.GreaterOrEqual(t, recorder.Counts["sink.stuck|table:foo|sink:FakeSink"], int64(10))
+		assert.Zero(t, recorder.Counts["snapshot.intervals_requeued|table:foo"])
+	})
+}
+
+// Neither policy keeps going forever, or sends an interval again while the sink is still sitting on it.
+func TestSnapshotterStuckSinkGivesUp(t *testing.T) {
+	for _, policy := range []string{"retry", "requeue"} {
+		sink := &FakeSink{Stuck: true}
+		runWithStuckSink(t, sink, policy, func(ok bool, state SnapshotState, recorder *RecordingMetrics) {
+			assert.False(t, ok, policy)
+			assert.False(t, state.Done(), policy)
+			sink.Lock.Lock()
+			assert.LessOrEqual(t, sink.RowsEvents, 10, policy)
+			sink.Lock.Unlock()
+			assert.Zero(t, recorder.Counts["snapshot.intervals_completed|table:foo"], policy)
+		})
+		sink.Unstick()
+	}
+}
+
+func TestSnapshotterStuckSinkShutdown(t *testing.T) {
+	// The sink answers, but too late. The late answers mustn't block the sink or confuse the workers.
+	sink := &FakeSink{Delay: 100 * time.Millisecond}
+	WithBugsnagReports(func(reports *[]bugsnag.MetaData) {
+		runWithStuckSink(t, sink, "shutdown", func(ok bool, state SnapshotState, recorder *RecordingMetrics) {
+			assert.False(t, ok)
+			assert.False(t, state.Done())
+			assert.Zero(t, recorder.Counts["snapshot.intervals_completed|table:foo"])
+		})
+		assert.Len(t, *reports, 1)
+		assert.Equal(t, "FakeSink", (*reports)[0]["snapshot"]["sink"])
+	})
+}
+
+func TestConvertValueFromMysqlErrors(t *testing.T) {
+	_, err := convertValueFromMysql(uint64(1), Column{Name: "id", SqlType: "varchar", Width: 10})
+	assert.ErrorContains(t, err, "Unknown type for uint64 MySQL value")
+	_, err = convertValueFromMysql([]byte("yesterday"), Column{Name: "created_at", SqlType: "date"})
+	assert.Error(t, err)
+	_, err = convertValueFromMysql([]byte("1.2.3"), Column{Name: "price", SqlType: "decimal", Width: 10, Scale: 2})
+	assert.ErrorContains(t, err, "Can't convert string '1.2.3' to decimal")
+	value, err := convertValueFromMysql(int64(-3), Column{Name: "delta", SqlType: "tinyint", Width: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, int8(-3), value)
+}
+
 func TestSnapshotterCompletesWithSink(t *testing.T) {
-	sinks = []Sink{NewCsvSink()}
+	sinks = []Sink{NewTestCsvSink(t)}
 	defer func() { sinks = nil }()
 
 	SetFakeResponses(
@@ -59,14 +169,27 @@ func TestSnapshotterCompletesWithSink(t *testing.T) {
 	})
 }
 
+func TestRowChunkSql(t *testing.T) {
+	schema := &TableSchema{"users", []Column{
+		{"id", "bigint", 20, 0, false, false},
+		{"name", "varchar", 255, 0, true, true},
+	}}
+	pi := PendingInterval{schema, Interval{100, 200}}
+	assert.Equal(t, "SELECT `id`, `name` FROM `users` WHERE `id` >= 100 AND `id` < 200", rowChunkSql(pi))
+
+	oldTables := config.Tables
+	defer func() { config.Tables = oldTables }()
+	config.Tables = map[string]TableConfig{"users": {RowFilter: "deleted_at IS NULL"}}
+	assert.Equal(t, "SELECT `id`, `name` FROM `users` WHERE `id` >= 100 AND `id` < 200 AND (deleted_at IS NULL)", rowChunkSql(pi))
+}
+
 func TestSnapshotterIntegration(t *testing.T) {
 	var err error
-	sinks = []Sink{NewCsvSink()}
+	sinks = []Sink{NewTestCsvSink(t)}
 	defer func() { sinks = nil }()
 
 	WithIntegrationTestSetup(func () {
-		assert.NoError(t, os.RemoveAll(fmt.Sprintf("/tmp/%d", os.Getpid())))
-		logger.Printf("Writing to /tmp/%d", os.Getpid())
+		logger.Info("Writing CSV files.", "directory", sinks[0].(*CsvSink).Directory)
 
 		tables := []string{"all_date_types", "all_number_types", "all_string_types"}
 		schemas := make([]*TableSchema, len(tables))
@@ -75,8 +198,81 @@ func TestSnapshotterIntegration(t *testing.T) {
 			assert.NoError(t, sinks[0].Open(schemas[i]))
 			assert.NoError(t, err)
 		}
-		state := NewSnapshotState(schemas)
+		state, _, err := NewSnapshotState(schemas)
+		assert.NoError(t, err)
 		snapshotter := NewCustomSnapshotter(state)
 		assert.True(t, snapshotter.Run())
 	})
 }
+
+func TestSnapshotterResizeWorkers(t *testing.T) {
+	snapshotter := NewCustomSnapshotter(NewFakeSnapshotState([]string{}, 0))
+
+	snapshotter.resizeWorkers(3)
+	assert.Equal(t, 3, snapshotter.workerCount)
+	assert.Equal(t, 0, snapshotter.workersToStop)
+
+	snapshotter.resizeWorkers(1)
+	assert.Equal(t, 3, snapshotter.workerCount)
+	assert.Equal(t, 2, snapshotter.workersToStop)
+
+	snapshotter.resizeWorkers(2)
+	assert.Equal(t, 3, snapshotter.workerCount)
+	assert.Equal(t, 1, snapshotter.workersToStop)
+
+	// We can't start more workers once we've stopped handing out work.
+	snapshotter.pendingClosed = true
+	snapshotter.resizeWorkers(5)
+	assert.Equal(t, 3, snapshotter.workerCount)
+	assert.Equal(t, 0, snapshotter.workersToStop)
+
+	snapshotter.pendingClosed = false
+	snapshotter.resizeWorkers(5)
+	assert.Equal(t, 5, snapshotter.workerCount)
+
+	snapshotter.Workers.Exit(nil)
+	assert.NoError(t, snapshotter.Workers.Wait())
+}
+
+func TestSnapshotterReloadExcludesTable(t *testing.T) {
+	settings := map[string]string{
+		"MYSQL_HOST": "mysql", "MYSQL_DATABASE": "test_db", "MYSQL_USER": "warehouse", "REDIS_HOST": "redis",
+		"SNAPSHOT_CHUNK_SIZE": "100", "SNAPSHOT_WORKERS": "4",
+	}
+	for key, value := range settings {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	oldConfig := config
+	config = NewConfig()
+	defer func() { config = oldConfig }()
+
+	sink := NewTestCsvSink(t)
+	sinks = []Sink{sink}
+	defer func() { sinks = nil }()
+
+	SetFakeResponses(
+		FakeMysqlResponse{false, math.MaxInt, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+	state := NewFakeSnapshotState([]string{"foo", "bar"}, 10000)
+	for _, table := range state.(*FakeSnapshotState).Tables {
+		sink.Open(table.Schema)
+		tableSchemaCache[table.Schema.Name] = table.Schema
+	}
+	defer delete(tableSchemaCache, "foo")
+	defer delete(tableSchemaCache, "bar")
+
+	os.Setenv("EXCLUDE_TABLES", "bar")
+	os.Setenv("SNAPSHOT_WORKERS", "2")
+	defer os.Unsetenv("EXCLUDE_TABLES")
+
+	snapshotter := NewCustomSnapshotter(state)
+	snapshotter.Reload()
+	assert.True(t, snapshotter.Run())
+
+	assert.Equal(t, []string{"bar"}, config.ExcludeTables)
+	assert.Equal(t, int64(2), config.SnapshotWorkers)
+	assert.Equal(t, 2, snapshotter.workerCount - snapshotter.workersToStop)
+	assert.Contains(t, sink.Files, "foo")
+	assert.NotContains(t, sink.Files, "bar")
+}
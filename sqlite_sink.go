@@ -0,0 +1,290 @@
+// Keeps a copy of each table in a single SQLite file, for poking at with plain SQL or comparing against MySQL in the
+// integration tests. Rows are upserted on the primary key, so writing the same rows twice is harmless.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	RegisterSinkType("sqlite", func(params map[string]string) (Sink, error) {
+		if err := checkSinkParams(params, "path"); err != nil {
+			return nil, err
+		}
+		if params["path"] == "" {
+			return nil, fmt.Errorf("Missing parameter: path")
+		}
+		return NewSqliteSink(params["path"])
+	})
+}
+
+type SqliteSink struct {
+	Lock sync.Mutex
+	DB *sql.DB
+	Tables map[string]*TableSchema
+}
+
+// Creates the file if it isn't there yet.
+func NewSqliteSink(path string) (*SqliteSink, error) {
+	db, err := sql.Open("sqlite3", "file:" + path + "?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only has one writer at a time anyway, and this saves us from fighting over the lock.
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Can't open SQLite database '%s': %w", path, err)
+	}
+	return &SqliteSink{sync.Mutex{}, db, make(map[string]*TableSchema)}, nil
+}
+
+// Reopening the same file picks up the tables we made last time. We don't check that they still match the schema.
+func (sink *SqliteSink) Open(ts *TableSchema) error {
+	ddl, err := SqliteCreateTable(ts)
+	if err != nil {
+		return err
+	}
+	if _, err = sink.DB.Exec(ddl); err != nil {
+		return fmt.Errorf("Can't create table '%s': %w", ts.Name, err)
+	}
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	sink.Tables[ts.Name] = ts
+	return nil
+}
+
+func (sink *SqliteSink) Close(ts *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	delete(sink.Tables, ts.Name)
+	return nil
+}
+
+func (sink *SqliteSink) WriteRows(rows RowsEvent) {
+	rows.ResponseChan <- sink.upsertRows(rows)
+}
+
+// Each batch goes in one transaction, which is a lot faster than letting SQLite commit every row.
+func (sink *SqliteSink) upsertRows(rows RowsEvent) error {
+	tx, err := sink.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(rows.Schema.Columns)), ", ")
+	upsert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", sqliteIdentifier(rows.Schema.Name),
+		sqliteColumnList(rows.Schema), placeholders) + sqliteOnConflict(rows.Schema)
+	statement, err := tx.Prepare(upsert)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	values := make([]any, len(rows.Schema.Columns))
+	for _, row := range rows.Data {
+		for i, column := range rows.Schema.Columns {
+			if values[i], err = sqliteValue(row[i], column); err != nil {
+				return err
+			}
+		}
+		if _, err = statement.Exec(values...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Deletes rows by their primary keys. Nothing calls this yet; it's for deletes from the binlog, once we're
+// streaming it.
+func (sink *SqliteSink) DeleteRows(ts *TableSchema, keys []any) error {
+	pk := ts.PrimaryKeyIndex()
+	if pk < 0 {
+		return fmt.Errorf("Can't delete rows from table '%s', which has no primary key", ts.Name)
+	}
+	tx, err := sink.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", sqliteIdentifier(ts.Name), sqliteIdentifier(ts.Columns[pk].Name))
+	for _, key := range keys {
+		value, err := sqliteValue(key, ts.Columns[pk])
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(sql, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Adds and drops columns to match the new schema. SQLite doesn't care what type a column is declared as, so we
+// don't bother changing those.
+func (sink *SqliteSink) SchemaChange(newSchema *TableSchema) error {
+	sink.Lock.Lock()
+	defer sink.Lock.Unlock()
+	oldSchema, ok := sink.Tables[newSchema.Name]
+	if !ok {
+		return fmt.Errorf("Can't change the schema of table '%s', which was never opened", newSchema.Name)
+	}
+	statements, err := SqliteAlterTable(oldSchema, newSchema)
+	if err != nil {
+		return err
+	}
+
+	tx, err := sink.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return fmt.Errorf("Can't alter table '%s': %w", newSchema.Name, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	sink.Tables[newSchema.Name] = newSchema
+	return nil
+}
+
+func (sink *SqliteSink) Exit() error {
+	return sink.DB.Close()
+}
+
+func SqliteCreateTable(ts *TableSchema) (string, error) {
+	lines := []string{}
+	for _, column := range ts.Columns {
+		sqliteType, err := sqliteType(column)
+		if err != nil {
+			return "", err
+		}
+		line := sqliteIdentifier(column.Name) + " " + sqliteType
+		if !column.Nullable {
+			line += " NOT NULL"
+		}
+		lines = append(lines, line)
+	}
+	if pk := ts.PrimaryKeyIndex(); pk >= 0 {
+		lines = append(lines, "PRIMARY KEY (" + sqliteIdentifier(ts.Columns[pk].Name) + ")")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n  %s\n)", sqliteIdentifier(ts.Name), strings.Join(lines, ",\n  ")), nil
+}
+
+// SQLite won't add a NOT NULL column without a default, so new columns are always nullable. DROP COLUMN needs
+// SQLite 3.35, and go-sqlite3 bundles a newer one than that.
+func SqliteAlterTable(oldSchema, newSchema *TableSchema) ([]string, error) {
+	table := sqliteIdentifier(newSchema.Name)
+	oldColumns := map[string]bool{}
+	for _, column := range oldSchema.Columns {
+		oldColumns[column.Name] = true
+	}
+
+	statements := []string{}
+	for _, column := range newSchema.Columns {
+		if oldColumns[column.Name] {
+			delete(oldColumns, column.Name)
+			continue
+		}
+		sqliteType, err := sqliteType(column)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, sqliteIdentifier(column.Name), sqliteType))
+	}
+	for _, column := range oldSchema.Columns {
+		if oldColumns[column.Name] {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, sqliteIdentifier(column.Name)))
+		}
+	}
+	return statements, nil
+}
+
+// SQLite only really has integers, floats, text, and blobs. Decimals are stored as text so that they stay exact,
+// and dates and times are text in the formats that SQLite's date functions understand.
+func sqliteType(column Column) (string, error) {
+	switch column.SqlType {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		return "INTEGER", nil
+	case "float", "double":
+		return "REAL", nil
+	case "decimal", "char", "varchar", "text", "mediumtext", "longtext", "date", "time", "datetime", "timestamp":
+		return "TEXT", nil
+	case "binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob":
+		return "BLOB", nil
+	default:
+		return "", fmt.Errorf("Can't convert SQL type '%s' of column '%s' to SQLite", column.SqlType, column.Name)
+	}
+}
+
+func sqliteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func sqliteColumnList(ts *TableSchema) string {
+	names := make([]string, len(ts.Columns))
+	for i, column := range ts.Columns {
+		names[i] = sqliteIdentifier(column.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// SQLite's upsert clause, where "excluded" is the row we tried to insert. A table that's nothing but its primary
+// key has nothing to update, so rows that are already there are just skipped.
+func sqliteOnConflict(ts *TableSchema) string {
+	pk := ts.PrimaryKeyIndex()
+	if pk < 0 {
+		return ""
+	}
+	updates := []string{}
+	for i, column := range ts.Columns {
+		if i != pk {
+			name := sqliteIdentifier(column.Name)
+			updates = append(updates, name + " = excluded." + name)
+		}
+	}
+	conflict := " ON CONFLICT (" + sqliteIdentifier(ts.Columns[pk].Name) + ") DO "
+	if len(updates) == 0 {
+		return conflict + "NOTHING"
+	}
+	return conflict + "UPDATE SET " + strings.Join(updates, ", ")
+}
+
+// Converts a value to what we store in the column's SQLite type.
+func sqliteValue(datum any, column Column) (any, error) {
+	switch datum.(type) {
+	case uint64:
+		if datum.(uint64) > math.MaxInt64 {
+			return nil, fmt.Errorf("Value %d of column '%s' is too big for SQLite", datum.(uint64), column.Name)
+		}
+		return int64(datum.(uint64)), nil
+
+	case time.Time:
+		t := datum.(time.Time)
+		switch column.SqlType {
+		case "date": return t.Format("2006-01-02"), nil
+		case "time": return t.Format("15:04:05.999999"), nil
+		default: return t.UTC().Format("2006-01-02 15:04:05.999999"), nil
+		}
+
+	case *big.Rat:
+		return datum.(*big.Rat).FloatString(column.Scale), nil
+
+	default:
+		return datum, nil
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSqliteCreateTable(t *testing.T) {
+	ddl, err := SqliteCreateTable(ParseSchema(MustReadFile("test_schemas/all_date_types.sql")))
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "all_date_types" (
+  "id" INTEGER NOT NULL,
+  "date_o" TEXT,
+  "date_r" TEXT NOT NULL,
+  "time_o" TEXT,
+  "time_r" TEXT NOT NULL,
+  "datetime_o" TEXT,
+  "datetime_r" TEXT NOT NULL,
+  "timestamp_o" TEXT,
+  "timestamp_r" TEXT NOT NULL,
+  PRIMARY KEY ("id")
+)`, ddl)
+
+	_, err = SqliteCreateTable(ParseSchema("CREATE TABLE `foo` (\n`shape` geometry NOT NULL\n)"))
+	assert.ErrorContains(t, err, "Can't convert SQL type 'geometry' of column 'shape' to SQLite")
+}
+
+func TestSqliteValue(t *testing.T) {
+	value, err := sqliteValue(big.NewRat(-12345, 100), Column{Name: "price", SqlType: "decimal", Width: 6, Scale: 3, Signed: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "-123.450", value)
+	_, err = sqliteValue(uint64(18446744073709551615), Column{Name: "id", SqlType: "bigint", Width: 20})
+	assert.ErrorContains(t, err, "Value 18446744073709551615 of column 'id' is too big for SQLite")
+
+	alarm, _ := time.Parse("15:04:05", "06:30:00")
+	value, _ = sqliteValue(alarm, Column{Name: "alarm", SqlType: "time", Signed: true})
+	assert.Equal(t, "06:30:00", value)
+	value, _ = sqliteValue(time.Date(1973, 8, 30, 0, 0, 0, 0, time.UTC), Column{Name: "born", SqlType: "date", Signed: true})
+	assert.Equal(t, "1973-08-30", value)
+	value, _ = sqliteValue(time.Date(2021, 10, 29, 6, 5, 22, 123000000, time.UTC), Column{Name: "at", SqlType: "datetime", Width: 3, Signed: true})
+	assert.Equal(t, "2021-10-29 06:05:22.123", value)
+}
+
+func TestSqliteSink(t *testing.T) {
+	sink, err := NewSqliteSink(filepath.Join(t.TempDir(), "export.db"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sink.Exit()
+	schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`price` decimal(6,2) DEFAULT NULL,\n" +
+		"`name` varchar(10) NOT NULL,\n`data` varbinary(10)\n)")
+	assert.NoError(t, sink.Open(schema))
+	bar := ParseSchema("CREATE TABLE `bar` (\n`id` bigint unsigned NOT NULL\n)")
+	assert.EqualError(t, sink.SchemaChange(bar), "Can't change the schema of table 'bar', which was never opened")
+
+	readRows := func() map[int64]string {
+		rows, err := sink.DB.Query(`SELECT id, coalesce(price, 'NULL') || ' ' || name || ' ' || quote(data) FROM foo`)
+		assert.NoError(t, err)
+		defer rows.Close()
+		result := map[int64]string{}
+		for rows.Next() {
+			var id int64
+			var value string
+			assert.NoError(t, rows.Scan(&id, &value))
+			result[id] = value
+		}
+		return result
+	}
+	write := func(data ...[]any) {
+		rows := RowsEvent{make(chan error, 1), schema, data, RowsSource{}}
+		sink.WriteRows(rows)
+		assert.NoError(t, <-rows.ResponseChan)
+	}
+
+	write([]any{uint64(1), big.NewRat(150, 100), "honk", []byte{0, 1}}, []any{uint64(2), nil, "bonk", nil})
+	write([]any{uint64(2), big.NewRat(1, 4), "bonk", []byte("hi")}, []any{uint64(3), nil, "new", nil})
+	assert.Equal(t, map[int64]string{1: "1.50 honk X'0001'", 2: "0.25 bonk X'6869'", 3: "NULL new NULL"}, readRows())
+
+	assert.NoError(t, sink.DeleteRows(schema, []any{uint64(2), uint64(3)}))
+	assert.Equal(t, map[int64]string{1: "1.50 honk X'0001'"}, readRows())
+
+	newSchema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint unsigned NOT NULL,\n`name` varchar(10) NOT NULL,\n" +
+		"`added` int NOT NULL\n)")
+	assert.NoError(t, sink.SchemaChange(newSchema))
+	var columns string
+	assert.NoError(t, sink.DB.QueryRow(`SELECT group_concat(name, ',') FROM pragma_table_info('foo')`).Scan(&columns))
+	assert.Equal(t, "id,name,added", columns)
+}
+
+func TestSqliteSinkParams(t *testing.T) {
+	_, err := sinkTypes["sqlite"](map[string]string{})
+	assert.ErrorContains(t, err, "Missing parameter: path")
+
+	_, err = sinkTypes["sqlite"](map[string]string{"path": filepath.Join(t.TempDir(), "nope", "export.db")})
+	assert.ErrorContains(t, err, "Can't open SQLite database")
+}
@@ -0,0 +1,258 @@
+// Dumps and restores all of the exporter's state, for moving it to new infrastructure or recovering from a
+// disaster. The dump is a versioned JSON document which can be restored into any StateStorage back end.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const STATE_DOCUMENT_VERSION = 1
+
+// Every key the exporter owns, either by name or by prefix. Anything we store in StateStorage must be covered
+// here, or it won't survive a migration. (The leader lease is deliberately left out; it belongs to a process,
+// not to the database.)
+var ownedStateKeys = []string{"last_committed_position", "last_committed_gtid_set", "snapshot_paused"}
+var ownedStatePrefixes = []string{"table_snapshot_progress/", "table_schema/", "table_snapshot_paused/", "table_resnapshot/"}
+
+type StateDocument struct {
+	Version int `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Database string `json:"database"`
+	State map[string]string `json:"state"`
+}
+
+// Reads every key we own from the current StateStorage.
+func ExportState() (StateDocument, error) {
+	doc := StateDocument{STATE_DOCUMENT_VERSION, time.Now().In(UTC), config.MysqlDatabase, map[string]string{}}
+
+	keys, err := listOwnedStateKeys()
+	if err != nil {
+		return doc, err
+	}
+	for _, key := range keys {
+		value, err := stateStorage.Get(key)
+		if err != nil {
+			return doc, fmt.Errorf("Can't read '%s' from state storage: %s", key, err)
+		}
+		doc.State[key] = value
+	}
+	return doc, nil
+}
+
+// Checks that a state document makes sense for the MySQL server we're connected to. Returns all of the
+// problems at once, so that the operator doesn't have to play whack-a-mole.
+func ValidateStateDocument(doc StateDocument) error {
+	errs := []error{}
+	if doc.Version != STATE_DOCUMENT_VERSION {
+		return fmt.Errorf("Unsupported state document version %d (expected %d)", doc.Version, STATE_DOCUMENT_VERSION)
+	}
+	if doc.Database != config.MysqlDatabase {
+		errs = append(errs, fmt.Errorf("State was exported from database '%s', but we're connected to '%s'", doc.Database, config.MysqlDatabase))
+	}
+
+	for key := range doc.State {
+		if !isOwnedStateKey(key) {
+			errs = append(errs, fmt.Errorf("Unknown state key '%s'", key))
+		}
+	}
+
+	tables, err := ListTables()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for key, value := range doc.State {
+		if tableName, found := strings.CutPrefix(key, "table_snapshot_progress/"); found {
+			if !StringInList(tableName, tables) {
+				errs = append(errs, fmt.Errorf("Table '%s' has snapshot progress but doesn't exist on the server", tableName))
+			}
+			if value != "done" {
+				if err := validateProgress(value); err != nil {
+					errs = append(errs, fmt.Errorf("Bad snapshot progress for table '%s': %s", tableName, err))
+				}
+			}
+		} else if tableName, found := strings.CutPrefix(key, "table_resnapshot/"); found {
+			if !StringInList(tableName, tables) {
+				errs = append(errs, fmt.Errorf("Table '%s' is being re-snapshotted but doesn't exist on the server", tableName))
+			}
+			if value != "done" {
+				if err := validateProgress(value); err != nil {
+					errs = append(errs, fmt.Errorf("Bad saved progress for re-snapshotted table '%s': %s", tableName, err))
+				}
+			}
+		} else if tableName, found := strings.CutPrefix(key, "table_snapshot_paused/"); found {
+			if !StringInList(tableName, tables) {
+				errs = append(errs, fmt.Errorf("Table '%s' is paused but doesn't exist on the server", tableName))
+			}
+		} else if tableName, found := strings.CutPrefix(key, "table_schema/"); found {
+			if !StringInList(tableName, tables) {
+				errs = append(errs, fmt.Errorf("Table '%s' has a schema but doesn't exist on the server", tableName))
+			}
+			var schema TableSchema
+			if err := json.Unmarshal([]byte(value), &schema); err != nil {
+				errs = append(errs, fmt.Errorf("Bad schema for table '%s': %s", tableName, err))
+			} else if schema.Name != tableName || len(schema.Columns) == 0 {
+				errs = append(errs, fmt.Errorf("Bad schema for table '%s': it's for table '%s' with %d columns", tableName, schema.Name, len(schema.Columns)))
+			}
+		}
+	}
+
+	currentPosition, currentGtids, err := GetBinlogPosition()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if strpos, ok := doc.State["last_committed_position"]; ok && strpos != "" {
+		position, err := strconv.ParseUint(strpos, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Bad last_committed_position '%s': %s", strpos, err))
+		} else if position > currentPosition {
+			errs = append(errs, fmt.Errorf("last_committed_position %d is ahead of the server's binlog position %d", position, currentPosition))
+		}
+	}
+	if gtids, ok := doc.State["last_committed_gtid_set"]; ok && gtids != "" {
+		purged, err := DoPurgedGtidsExist(gtids, currentGtids)
+		if err != nil {
+			errs = append(errs, err)
+		} else if purged {
+			errs = append(errs, fmt.Errorf("The server has purged binlogs we haven't processed since GTID set '%s'", gtids))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Replaces every key we own in the current StateStorage with the contents of the document, in one atomic step.
+// We take the leader lease first, so that we can't pull the rug out from under a running exporter.
+func ImportState(doc StateDocument) error {
+	if err := ValidateStateDocument(doc); err != nil {
+		return fmt.Errorf("Refusing to import invalid state:\n%w", err)
+	}
+
+	election := NewLeaderElection()
+	acquired, err := stateStorage.AcquireLease(election.Key, election.Owner, election.TTL)
+	if err != nil {
+		return fmt.Errorf("Can't acquire the leader lease: %s", err)
+	} else if !acquired {
+		return errors.New("An exporter is running against this state storage. Stop it before importing.")
+	}
+	defer election.Exit()
+
+	keys, err := listOwnedStateKeys()
+	if err != nil {
+		return err
+	}
+	if err := stateStorage.Replace(keys, doc.State); err != nil {
+		return fmt.Errorf("Can't write the state to state storage: %s", err)
+	}
+	return nil
+}
+
+// Handles the "export-state [FILE]" and "import-state FILE" commands. Returns the process exit status.
+func RunStateCommand(args []string) int {
+	var err error
+	switch args[0] {
+	case "export-state":
+		var out io.Writer = os.Stdout
+		if len(args) > 1 {
+			file, ferr := os.Create(args[1])
+			if ferr != nil {
+				logger.Error("Can't create the export file", "file", args[1], "error", ferr)
+				return 1
+			}
+			defer file.Close()
+			out = file
+		}
+		var doc StateDocument
+		if doc, err = ExportState(); err == nil {
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(doc)
+		}
+
+	case "import-state":
+		if len(args) < 2 {
+			logger.Error("Usage: import-state FILE")
+			return 2
+		}
+		var doc StateDocument
+		if doc, err = ReadStateDocument(args[1]); err == nil {
+			err = ImportState(doc)
+		}
+
+	default:
+		logger.Error("Unknown command. Expected 'export-state' or 'import-state'.", "command", args[0])
+		return 2
+	}
+
+	if err != nil {
+		logger.Error("Command failed", "command", args[0], "error", err)
+		return 1
+	}
+	logger.Info("Command succeeded.", "command", args[0])
+	return 0
+}
+
+func ReadStateDocument(filename string) (StateDocument, error) {
+	var doc StateDocument
+	file, err := os.Open(filename)
+	if err != nil {
+		return doc, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&doc); err != nil {
+		return doc, fmt.Errorf("Can't parse %s: %s", filename, err)
+	}
+	return doc, nil
+}
+
+func listOwnedStateKeys() ([]string, error) {
+	keys := []string{}
+	for _, key := range ownedStateKeys {
+		value, err := stateStorage.Get(key)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read '%s' from state storage: %s", key, err)
+		}
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	for _, prefix := range ownedStatePrefixes {
+		prefixed, err := stateStorage.Keys(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Can't list '%s' keys in state storage: %s", prefix, err)
+		}
+		keys = append(keys, prefixed...)
+	}
+	return keys, nil
+}
+
+func isOwnedStateKey(key string) bool {
+	if StringInList(key, ownedStateKeys) {
+		return true
+	}
+	for _, prefix := range ownedStatePrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Imported progress has to be a list we could have written ourselves, or Encode() will mangle it later.
+func validateProgress(value string) error {
+	list, err := DecodeIntervalList(value)
+	if err != nil {
+		return err
+	}
+	return list.Validate()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testStateContents = map[string]string{
+	"last_committed_position": "1099511659000",
+	"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
+	"table_snapshot_progress/foo": "done",
+	"table_snapshot_progress/bar": "0-200,500-600",
+	"table_schema/foo": `{"Name":"foo","Columns":[{"Name":"id","SqlType":"bigint","Width":20,"Scale":0,"Signed":false,"Nullable":false}]}`,
+	"snapshot_paused": "true",
+	"table_snapshot_paused/bar": "true",
+	"table_resnapshot/bar": "0-1000",
+	"leader_lease_is_not_ours": "honk",
+}
+
+// The queries that ValidateStateDocument makes, in order.
+func SetFakeValidationResponses(tables []string, binlogPos int, purgedGtids bool) {
+	tableRows := [][]any{}
+	for _, table := range tables {
+		tableRows = append(tableRows, []any{table})
+	}
+	SetFakeSnapshotResponses(binlogPos, 35000, purgedGtids)
+	client := &pool.(*FakeMysqlPool).Client
+	client.Responses = append([]FakeMysqlResponse{{false, 0, []string{"Tables_in_honk"}, tableRows}}, client.Responses...)
+}
+
+func TestExportState(t *testing.T) {
+	WithStateStorage(testStateContents, func() {
+		doc, err := ExportState()
+		assert.NoError(t, err)
+		assert.Equal(t, STATE_DOCUMENT_VERSION, doc.Version)
+		assert.Equal(t, map[string]string{
+			"last_committed_position": "1099511659000",
+			"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
+			"table_snapshot_progress/foo": "done",
+			"table_snapshot_progress/bar": "0-200,500-600",
+			"table_schema/foo": testStateContents["table_schema/foo"],
+			"snapshot_paused": "true",
+			"table_snapshot_paused/bar": "true",
+			"table_resnapshot/bar": "0-1000",
+		}, doc.State)
+	})
+}
+
+func TestExportStateIncludesSchemas(t *testing.T) {
+	WithStateStorage(map[string]string{}, func() {
+		schema := ParseSchema("CREATE TABLE `foo` (\n`id` bigint(20) unsigned NOT NULL\n)")
+		assert.NoError(t, openTableOnSinks(schema))
+		doc, err := ExportState()
+		assert.NoError(t, err)
+		var exported TableSchema
+		assert.NoError(t, json.Unmarshal([]byte(doc.State["table_schema/foo"]), &exported))
+		assert.Equal(t, *schema, exported)
+	})
+}
+
+func TestImportStateRoundTrip(t *testing.T) {
+	var doc StateDocument
+	var err error
+	filename := filepath.Join(t.TempDir(), "state.json")
+
+	WithStateStorage(testStateContents, func() {
+		assert.Equal(t, 0, RunStateCommand([]string{"export-state", filename}))
+	})
+	doc, err = ReadStateDocument(filename)
+	assert.NoError(t, err)
+
+	WithStateStorage(map[string]string{"table_snapshot_progress/stale": "0-100"}, func() {
+		SetFakeValidationResponses([]string{"foo", "bar"}, 31337, false)
+		assert.NoError(t, ImportState(doc))
+
+		restored, err := ExportState()
+		assert.NoError(t, err)
+		assert.Equal(t, doc.State, restored.State)
+
+		// The lease we took for the import should have been released.
+		acquired, err := stateStorage.AcquireLease(LEADER_LEASE_KEY, "someone", config.LeaderLeaseTTL)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+	})
+}
+
+func TestImportStateReportsAllProblems(t *testing.T) {
+	doc := StateDocument{STATE_DOCUMENT_VERSION, time.Now(), "some_other_db", map[string]string{
+		"last_committed_position": "1099511659555",
+		"last_committed_gtid_set": "3a1b9647-46ad-11ee-8a65-0242c0a89007:1-30000",
+		"table_snapshot_progress/missing": "done",
+		"table_snapshot_progress/foo": "5-10,1-3",
+		"table_schema/foo": `{"Name":"bar","Columns":[]}`,
+		"table_schema/missing": "{",
+		"table_snapshot_paused/missing": "true",
+		"table_resnapshot/missing": "~!!!",
+		"mystery_key": "honk",
+	}}
+
+	WithStateStorage(map[string]string{"table_snapshot_progress/foo": "done"}, func() {
+		SetFakeValidationResponses([]string{"foo"}, 31337, true)
+		err := ImportState(doc)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "some_other_db")
+		assert.Contains(t, err.Error(), "mystery_key")
+		assert.Contains(t, err.Error(), "'missing' has snapshot progress but doesn't exist")
+		assert.Contains(t, err.Error(), "Bad snapshot progress for table 'foo': Interval 1-3 overlaps or comes before 5-10")
+		assert.Contains(t, err.Error(), "Bad schema for table 'foo'")
+		assert.Contains(t, err.Error(), "'missing' has a schema but doesn't exist")
+		assert.Contains(t, err.Error(), "Bad schema for table 'missing'")
+		assert.Contains(t, err.Error(), "'missing' is paused but doesn't exist")
+		assert.Contains(t, err.Error(), "'missing' is being re-snapshotted but doesn't exist")
+		assert.Contains(t, err.Error(), "Bad saved progress for re-snapshotted table 'missing'")
+		assert.Contains(t, err.Error(), "ahead of the server's binlog position")
+		assert.Contains(t, err.Error(), "purged binlogs")
+
+		// Nothing should have been touched.
+		value, _ := stateStorage.Get("table_snapshot_progress/foo")
+		assert.Equal(t, "done", value)
+	})
+}
+
+func TestImportStateWhileExporterRunning(t *testing.T) {
+	doc := StateDocument{STATE_DOCUMENT_VERSION, time.Now(), config.MysqlDatabase, map[string]string{}}
+	WithStateStorage(map[string]string{}, func() {
+		acquired, err := stateStorage.AcquireLease(LEADER_LEASE_KEY, "running_exporter", config.LeaderLeaseTTL)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		SetFakeValidationResponses([]string{}, 31337, false)
+		assert.ErrorContains(t, ImportState(doc), "An exporter is running")
+	})
+}
+
+func TestReadStateDocumentRejectsUnknownFields(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	data, _ := json.Marshal(map[string]any{"version": 1, "state": map[string]string{}, "surprise": true})
+	assert.NoError(t, os.WriteFile(filename, data, 0644))
+
+	_, err := ReadStateDocument(filename)
+	assert.ErrorContains(t, err, "surprise")
+}
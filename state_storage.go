@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -18,10 +21,27 @@ type StateStorage interface {
 	Set(key string, val string) error
 	Delete(key string) error
 	ClearAll() error
+	Keys(prefix string) ([]string, error)
+	// Deletes the keys and then sets the values, all in one go, so nobody ever sees half of it.
+	Replace(keys []string, values map[string]string) error
+
+	// Leases are keys which are owned by a single process and expire if they aren't renewed
+	// within their TTL. AcquireLease succeeds if the lease is free or we already hold it;
+	// RenewLease only succeeds if we still hold it.
+	AcquireLease(key, owner string, ttl time.Duration) (bool, error)
+	RenewLease(key, owner string, ttl time.Duration) (bool, error)
+	ReleaseLease(key, owner string) error
+}
+
+type memoryLease struct {
+	owner string
+	expiresAt time.Time
 }
 
 type StateStorageMemory struct {
+	lock sync.Mutex
 	contents map[string]string
+	leases map[string]memoryLease
 }
 
 type StateStorageRedis struct {
@@ -37,25 +57,95 @@ func NewStateStorage() StateStorage {
 }
 
 func NewStateStorageMemory() *StateStorageMemory {
-	return &StateStorageMemory{make(map[string]string)}
+	return &StateStorageMemory{sync.Mutex{}, make(map[string]string), make(map[string]memoryLease)}
 }
 
 func (ssm *StateStorageMemory) Get(key string) (string, error) {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
 	return ssm.contents[key], nil
 }
 
 func (ssm *StateStorageMemory) Set(key string, val string) error {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
 	ssm.contents[key] = val
 	return nil
 }
 
 func (ssm *StateStorageMemory) Delete(key string) error {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
 	delete(ssm.contents, key)
 	return nil
 }
 
 func (ssm *StateStorageMemory) ClearAll() error {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
 	clear(ssm.contents)
+	clear(ssm.leases)
+	return nil
+}
+
+func (ssm *StateStorageMemory) Replace(keys []string, values map[string]string) error {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
+	for _, key := range keys {
+		delete(ssm.contents, key)
+	}
+	for key, val := range values {
+		ssm.contents[key] = val
+	}
+	return nil
+}
+
+// Returns all keys starting with the given prefix, sorted.
+func (ssm *StateStorageMemory) Keys(prefix string) ([]string, error) {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
+
+	keys := []string{}
+	for key := range ssm.contents {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (ssm *StateStorageMemory) AcquireLease(key, owner string, ttl time.Duration) (bool, error) {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
+
+	lease, ok := ssm.leases[key]
+	if ok && lease.owner != owner && time.Now().Before(lease.expiresAt) {
+		return false, nil
+	}
+	ssm.leases[key] = memoryLease{owner, time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (ssm *StateStorageMemory) RenewLease(key, owner string, ttl time.Duration) (bool, error) {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
+
+	lease, ok := ssm.leases[key]
+	if !ok || lease.owner != owner || !time.Now().Before(lease.expiresAt) {
+		return false, nil
+	}
+	ssm.leases[key] = memoryLease{owner, time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (ssm *StateStorageMemory) ReleaseLease(key, owner string) error {
+	ssm.lock.Lock()
+	defer ssm.lock.Unlock()
+
+	if lease, ok := ssm.leases[key]; ok && lease.owner == owner {
+		delete(ssm.leases, key)
+	}
 	return nil
 }
 
@@ -99,3 +189,88 @@ func (ssr *StateStorageRedis) ClearAll() error {
 	defer cancel()
 	return ssr.Client.FlushAll(ctx).Err()
 }
+
+// Returns all keys starting with the given prefix, sorted. Uses SCAN, so it won't block Redis on big databases.
+func (ssr *StateStorageRedis) Keys(prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), REDIS_TIMEOUT, errors.New("Redis scan timeout"))
+	defer cancel()
+
+	keys := []string{}
+	iter := ssr.Client.Scan(ctx, 0, redisGlobEscape(prefix) + "*", 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Uses MULTI/EXEC, so it all happens or none of it does.
+func (ssr *StateStorageRedis) Replace(keys []string, values map[string]string) error {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), REDIS_TIMEOUT, errors.New("Redis replace timeout"))
+	defer cancel()
+	_, err := ssr.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(keys) > 0 {
+			pipe.Del(ctx, keys...)
+		}
+		for key, value := range values {
+			pipe.Set(ctx, key, value, 0)
+		}
+		return nil
+	})
+	return err
+}
+
+var redisGlobReplacer = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func redisGlobEscape(s string) string {
+	return redisGlobReplacer.Replace(s)
+}
+
+// These scripts make the check-and-set of a lease atomic, so that two processes can't both
+// think they own it.
+var acquireLeaseScript = redis.NewScript(`
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var renewLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func (ssr *StateStorageRedis) AcquireLease(key, owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), REDIS_TIMEOUT, errors.New("Redis lease acquire timeout"))
+	defer cancel()
+	result, err := acquireLeaseScript.Run(ctx, ssr.Client, []string{key}, owner, ttl.Milliseconds()).Int()
+	return result == 1, err
+}
+
+func (ssr *StateStorageRedis) RenewLease(key, owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), REDIS_TIMEOUT, errors.New("Redis lease renew timeout"))
+	defer cancel()
+	result, err := renewLeaseScript.Run(ctx, ssr.Client, []string{key}, owner, ttl.Milliseconds()).Int()
+	return result == 1, err
+}
+
+func (ssr *StateStorageRedis) ReleaseLease(key, owner string) error {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), REDIS_TIMEOUT, errors.New("Redis lease release timeout"))
+	defer cancel()
+	return releaseLeaseScript.Run(ctx, ssr.Client, []string{key}, owner).Err()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -24,6 +25,25 @@ func runStateStorageTest(t *testing.T, storage StateStorage) {
 	err = storage.Set("bar", "bonk")
 	assert.NoError(t, err)
 
+	err = storage.Set("b*r", "bunk")
+	assert.NoError(t, err)
+	keys, err := storage.Keys("b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b*r", "bar"}, keys)
+	keys, err = storage.Keys("b*")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b*r"}, keys)
+	err = storage.Delete("b*r")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.Set("baz", "old"))
+	assert.NoError(t, storage.Replace([]string{"baz", "nope"}, map[string]string{"quux": "new"}))
+	s, _ = storage.Get("baz")
+	assert.Equal(t, "", s)
+	s, _ = storage.Get("quux")
+	assert.Equal(t, "new", s)
+	assert.NoError(t, storage.Delete("quux"))
+
 	err = storage.Delete("foo")
 	assert.NoError(t, err)
 	s, err = storage.Get("foo")
@@ -40,6 +60,29 @@ func runStateStorageTest(t *testing.T, storage StateStorage) {
 	s, err = storage.Get("bar")
 	assert.NoError(t, err)
 	assert.Equal(t, "", s)
+
+	ok, err := storage.AcquireLease("lease", "me", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = storage.AcquireLease("lease", "you", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = storage.RenewLease("lease", "me", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = storage.RenewLease("lease", "you", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, storage.ReleaseLease("lease", "you"))
+	ok, err = storage.AcquireLease("lease", "you", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, storage.ReleaseLease("lease", "me"))
+	ok, err = storage.AcquireLease("lease", "you", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, storage.ClearAll())
 }
 
 func TestStateStorage(t *testing.T) {
@@ -0,0 +1,145 @@
+// Health checks and a status report, served by the HttpServer:
+//   /healthz answers as long as the process is alive.
+//   /readyz checks that we can reach MySQL and the state storage.
+//   /status reports the snapshot's progress as JSON.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const STATUS_TIMEOUT = 5 * time.Second
+
+const (
+	PHASE_STANDBY = "standby"        // Waiting for the leader lease.
+	PHASE_STARTING = "starting"      // Figuring out what needs to be snapshotted.
+	PHASE_SNAPSHOT = "snapshot"
+	PHASE_DONE = "done"              // The snapshot is over, but we haven't moved on to streaming yet.
+	PHASE_STREAMING = "streaming"
+	PHASE_SHUTTING_DOWN = "shutting_down"
+)
+
+var currentPhase atomic.Value
+
+func setPhase(phase string) {
+	currentPhase.Store(phase)
+}
+
+func getPhase() string {
+	if shutdown != nil && shutdown.Requested() {
+		return PHASE_SHUTTING_DOWN
+	}
+	phase, ok := currentPhase.Load().(string)
+	if !ok {
+		return PHASE_STARTING
+	}
+	return phase
+}
+
+type TableStatus struct {
+	MaxId uint64 `json:"max_id"`
+	CompletedIntervals string `json:"completed_intervals"`
+	BusyIntervals string `json:"busy_intervals"`
+	PercentDone float64 `json:"percent_done"`
+	EtaSeconds *float64 `json:"eta_seconds"`  // Null until we've finished some work on this table.
+	Paused bool `json:"paused"`
+	Resnapshot bool `json:"resnapshot"`  // True if this is a re-snapshot requested through the admin API.
+	completedIds uint64                 // How many IDs CompletedIntervals covers.
+}
+
+type WorkerStatus struct {
+	Configured int64 `json:"configured"`
+	Running int `json:"running"`
+	Busy int `json:"busy"`
+}
+
+// What the Snapshotter reports about itself.
+type SnapshotStatus struct {
+	Paused bool
+	Workers WorkerStatus
+	Tables map[string]TableStatus
+	Done bool  // True once Run() has left its loop, so this is the last status we'll have.
+}
+
+type ExporterStatus struct {
+	Phase string `json:"phase"`
+	Paused bool `json:"paused"`
+	Workers WorkerStatus `json:"workers"`
+	Tables map[string]TableStatus `json:"tables"`
+	DeadLetters int64 `json:"dead_letters"`  // Rows we've skipped since we started, because we couldn't convert them.
+}
+
+func RegisterStatusHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/status", handleStatus)
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"mysql": "ok", "state_storage": "ok"}
+	status := http.StatusOK
+
+	if pool == nil {
+		checks["mysql"] = "not connected yet"
+		status = http.StatusServiceUnavailable
+	} else if _, err := pool.Execute("SELECT 1"); err != nil {
+		checks["mysql"] = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	if _, err := stateStorage.Get("last_committed_position"); err != nil {
+		checks["state_storage"] = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	writeJson(w, status, checks)
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := ExporterStatus{getPhase(), false, WorkerStatus{Configured: config.Workers()}, map[string]TableStatus{}, deadLetters.Count()}
+	if s := snapshotter.Load(); s != nil && status.Phase != PHASE_STARTING && status.Phase != PHASE_STANDBY {
+		snapshotStatus, ok := s.Status(STATUS_TIMEOUT)
+		if !ok {
+			writeJson(w, http.StatusServiceUnavailable, map[string]string{"error": "The snapshot didn't answer in time."})
+			return
+		}
+		if snapshotStatus.Done && status.Phase == PHASE_SNAPSHOT {
+			status.Phase = PHASE_DONE
+		}
+		status.Paused = snapshotStatus.Paused
+		status.Workers = snapshotStatus.Workers
+		status.Tables = snapshotStatus.Tables
+	}
+	writeJson(w, http.StatusOK, status)
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		httpLogger.Warn("Can't write HTTP response", "error", err)
+	}
+}
+
+// Works out what fraction of a table is done, and how long the rest should take at the rate we've been going
+// since the snapshot started.
+func estimateProgress(status *TableStatus, completedThisRun uint64, elapsed time.Duration) {
+	total := status.MaxId + 1
+	completed := status.completedIds
+	if completed > total {
+		completed = total
+	}
+	status.PercentDone = float64(completed) * 100 / float64(total)
+
+	if completedThisRun > 0 && elapsed > 0 {
+		rate := float64(completedThisRun) / elapsed.Seconds()
+		eta := float64(total - completed) / rate
+		status.EtaSeconds = &eta
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getJson(t *testing.T, path string, body any) int {
+	mux := http.NewServeMux()
+	RegisterStatusHandlers(mux)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), body))
+	return recorder.Code
+}
+
+func TestHealthz(t *testing.T) {
+	var body map[string]string
+	assert.Equal(t, http.StatusOK, getJson(t, "/healthz", &body))
+	assert.Equal(t, "ok", body["status"])
+}
+
+func TestReadyz(t *testing.T) {
+	var body map[string]string
+	SetFakeResponses(FakeMysqlResponse{false, 0, []string{"1"}, [][]any{{int64(1)}}})
+	assert.Equal(t, http.StatusOK, getJson(t, "/readyz", &body))
+	assert.Equal(t, map[string]string{"mysql": "ok", "state_storage": "ok"}, body)
+
+	SetFakeResponses()
+	pool.(*FakeMysqlPool).Client.AddErrorResponse("Connection refused")
+	assert.Equal(t, http.StatusServiceUnavailable, getJson(t, "/readyz", &body))
+	assert.Equal(t, "Connection refused", body["mysql"])
+	assert.Equal(t, "ok", body["state_storage"])
+}
+
+func TestStatus(t *testing.T) {
+	var body ExporterStatus
+	setPhase(PHASE_STARTING)
+	assert.Equal(t, http.StatusOK, getJson(t, "/status", &body))
+	assert.Equal(t, PHASE_STARTING, body.Phase)
+	assert.Empty(t, body.Tables)
+
+	sink := &FakeSink{Delay: time.Millisecond}
+	sinks = []Sink{sink}
+	defer func() {
+		sinks = nil
+		snapshotter.Store(nil)
+	}()
+	SetFakeResponses(
+		FakeMysqlResponse{false, math.MaxInt, []string{"id"}, [][]any{{uint64(31337)}}},
+	)
+
+	WithConfig("SNAPSHOT_CHUNK_SIZE", "100", func() {
+		s := NewCustomSnapshotter(NewFakeSnapshotState([]string{"foo"}, 1_000_000))
+		snapshotter.Store(s)
+		setPhase(PHASE_SNAPSHOT)
+		defer setPhase(PHASE_STARTING)
+		done := make(chan bool)
+		go func() { done <- s.Run() }()
+		assert.Eventually(t, func() bool {
+			sink.Lock.Lock()
+			defer sink.Lock.Unlock()
+			return sink.RowsEvents > 10
+		}, 5 * time.Second, time.Millisecond)
+
+		assert.Equal(t, http.StatusOK, getJson(t, "/status", &body))
+		s.Exit()
+		<-done
+
+		// Once Run() is out of its loop, we answer straight away with the last status it had.
+		var doneBody ExporterStatus
+		started := time.Now()
+		assert.Equal(t, http.StatusOK, getJson(t, "/status", &doneBody))
+		assert.Less(t, time.Since(started), time.Second)
+		assert.Equal(t, PHASE_DONE, doneBody.Phase)
+		assert.Contains(t, doneBody.Tables, "foo")
+	})
+
+	assert.Equal(t, PHASE_SNAPSHOT, body.Phase)
+	assert.Equal(t, int64(DEFAULT_SNAPSHOT_WORKERS), body.Workers.Configured)
+	assert.Equal(t, DEFAULT_SNAPSHOT_WORKERS, body.Workers.Running)
+	assert.Contains(t, body.Tables, "foo")
+	assert.Equal(t, uint64(999_999), body.Tables["foo"].MaxId)
+	assert.Greater(t, body.Tables["foo"].PercentDone, 0.0)
+	assert.NotNil(t, body.Tables["foo"].EtaSeconds)
+}
+
+func TestEstimateProgress(t *testing.T) {
+	status := TableStatus{MaxId: 999, CompletedIntervals: "0-250", completedIds: 250}
+	estimateProgress(&status, 0, time.Minute)
+	assert.Equal(t, 25.0, status.PercentDone)
+	assert.Nil(t, status.EtaSeconds)
+
+	// We did 100 IDs in the last 10 seconds, so the other 750 should take 75 seconds.
+	estimateProgress(&status, 100, 10 * time.Second)
+	assert.Equal(t, 75.0, *status.EtaSeconds)
+}
@@ -0,0 +1,88 @@
+// Synthetic columns are extra columns which don't exist in MySQL, like a region or tenant ID. They're configured
+// with SYNTHETIC_COLUMNS, and every table schema and row that the sinks receive has them tacked on the end.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type SyntheticColumn struct {
+	Column Column
+	Value any
+}
+
+// Builds a synthetic column from its SQL type and a SQL literal for its value, like "char(4)" and "'honk'".
+// The value is converted with the same rules we use for values we read from MySQL.
+func NewSyntheticColumn(name, sqlTypeString, literal string) (def SyntheticColumn, err error) {
+	sqlType, width, scale, err := tryParseSqlType(sqlTypeString)
+	if err != nil {
+		return def, err
+	}
+	literal = strings.TrimSpace(literal)
+	isNull := strings.EqualFold(literal, "NULL")
+	def.Column = Column{Name: name, SqlType: sqlType, Width: width, Scale: scale, Signed: true, Nullable: isNull}
+	if isNull {
+		return def, nil
+	}
+
+	var raw any
+	switch sqlType {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		raw, err = strconv.ParseInt(literal, 10, 64)
+	case "float", "double":
+		raw, err = strconv.ParseFloat(literal, 64)
+	default:
+		if len(literal) < 2 || !strings.HasPrefix(literal, "'") || !strings.HasSuffix(literal, "'") {
+			return def, fmt.Errorf("%s value must be quoted: %s", sqlType, literal)
+		}
+		raw = []byte(strings.ReplaceAll(literal[1:len(literal) - 1], "''", "'"))
+	}
+	if err != nil {
+		return def, err
+	}
+
+	def.Value, err = convertValueFromMysql(raw, def.Column)
+	return def, err
+}
+
+// Returns a copy of the schema with the configured synthetic columns added to the end.
+func AddSyntheticColumns(schema *TableSchema, defs []SyntheticColumn) (*TableSchema, error) {
+	if len(defs) == 0 {
+		return schema, nil
+	}
+
+	withSynthetics := NewTableSchema(schema.Name)
+	withSynthetics.Columns = append(withSynthetics.Columns, schema.Columns...)
+	for _, def := range defs {
+		for _, column := range schema.Columns {
+			if column.Name == def.Column.Name {
+				return nil, fmt.Errorf("Synthetic column '%s' has the same name as a column in table '%s'", column.Name, schema.Name)
+			}
+		}
+		withSynthetics.AddColumn(def.Column)
+	}
+	return &withSynthetics, nil
+}
+
+// Synthetic columns can't share a name with a real one (AddSyntheticColumns makes sure), so the name is enough.
+func isSyntheticColumn(column Column) bool {
+	for _, def := range config.SyntheticColumnDefs {
+		if def.Column.Name == column.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the configured value for a synthetic column.
+func syntheticColumnValue(column Column) any {
+	for _, def := range config.SyntheticColumnDefs {
+		if def.Column.Name == column.Name {
+			return def.Value
+		}
+	}
+	panic(fmt.Errorf("No value configured for synthetic column '%s'", column.Name))
+}
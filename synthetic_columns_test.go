@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSyntheticColumn(t *testing.T) {
+	def, err := NewSyntheticColumn("tenant", "int", "42")
+	assert.NoError(t, err)
+	assert.Equal(t, Column{Name: "tenant", SqlType: "int", Signed: true}, def.Column)
+	assert.Equal(t, int32(42), def.Value)
+
+	def, err = NewSyntheticColumn("region", "varchar(16)", "'it''s'")
+	assert.NoError(t, err)
+	assert.Equal(t, "it's", def.Value)
+
+	def, err = NewSyntheticColumn("rate", "decimal(5,2)", "'1.25'")
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewRat(5, 4), def.Value)
+
+	def, err = NewSyntheticColumn("nothing", "char(1)", "NULL")
+	assert.NoError(t, err)
+	assert.True(t, def.Column.Nullable)
+	assert.Nil(t, def.Value)
+
+	_, err = NewSyntheticColumn("tenant", "int", "'one'")
+	assert.Error(t, err)
+	_, err = NewSyntheticColumn("region", "varchar(16)", "us")
+	assert.ErrorContains(t, err, "must be quoted")
+	_, err = NewSyntheticColumn("day", "date", "'yesterday'")
+	assert.Error(t, err)
+}
+
+func TestConfigSyntheticColumnDefs(t *testing.T) {
+	os.Setenv("SYNTHETIC_COLUMNS", "tenant,int,7;region,varchar(8),'us'")
+	defer os.Unsetenv("SYNTHETIC_COLUMNS")
+	c := NewConfig()
+
+	assert.Len(t, c.SyntheticColumnDefs, 2)
+	assert.Equal(t, "tenant", c.SyntheticColumnDefs[0].Column.Name)
+	assert.Equal(t, int32(7), c.SyntheticColumnDefs[0].Value)
+	assert.Equal(t, "us", c.SyntheticColumnDefs[1].Value)
+
+	os.Setenv("SYNTHETIC_COLUMNS", "tenant,int,'seven'")
+	c = NewConfig()
+	assert.ErrorContains(t, c.Validate(), "Bad value for synthetic column 'tenant'")
+}
+
+func TestAddSyntheticColumns(t *testing.T) {
+	schema := &TableSchema{"users", []Column{{Name: "id", SqlType: "bigint", Width: 20}}}
+	unchanged, err := AddSyntheticColumns(schema, nil)
+	assert.NoError(t, err)
+	assert.Same(t, schema, unchanged)
+
+	def, _ := NewSyntheticColumn("region", "varchar(8)", "'us'")
+	withSynthetics, err := AddSyntheticColumns(schema, []SyntheticColumn{def})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id", "region"}, columnNames(withSynthetics))
+	assert.Equal(t, def.Column, withSynthetics.Columns[1])
+	assert.Len(t, schema.Columns, 1)
+
+	def, _ = NewSyntheticColumn("id", "int", "1")
+	_, err = AddSyntheticColumns(schema, []SyntheticColumn{def})
+	assert.ErrorContains(t, err, "same name")
+}
+
+func TestSyntheticColumnsInRows(t *testing.T) {
+	WithConfig("SYNTHETIC_COLUMNS", "region,varchar(8),'us'", func() {
+		schema, err := AddSyntheticColumns(
+			&TableSchema{"users", []Column{{Name: "id", SqlType: "bigint", Width: 20}}},
+			config.SyntheticColumnDefs,
+		)
+		assert.NoError(t, err)
+		assert.False(t, isSyntheticColumn(schema.Columns[0]))
+		assert.True(t, isSyntheticColumn(schema.Columns[1]))
+		pi := PendingInterval{schema, Interval{0, 10}}
+		assert.Equal(t, "SELECT `id` FROM `users` WHERE `id` >= 0 AND `id` < 10", rowChunkSql(pi))
+
+		result := &FakeMysqlResponse{false, 1, []string{"id"}, [][]any{{uint64(1)}, {uint64(2)}}}
+		event, err := rowsEventFromMysqlResult(schema, result)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]any{{uint64(1), "us"}, {uint64(2), "us"}}, event.Data)
+	})
+}
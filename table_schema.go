@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -27,6 +28,17 @@ func (ts *TableSchema) AddColumn(col Column) {
 	ts.Columns = append(ts.Columns, col)
 }
 
+// The index of the primary key column, or -1 if there isn't one. Like Rails, we assume it's always called `id`.
+// (Synthetic columns go on the end, so this is also its index in the MySQL results.)
+func (ts *TableSchema) PrimaryKeyIndex() int {
+	for i, column := range ts.Columns {
+		if column.Name == "id" {
+			return i
+		}
+	}
+	return -1
+}
+
 func ParseSchema(s string) *TableSchema {
 	strings.TrimSpace(s)
 	if !strings.HasPrefix(s, "CREATE TABLE `") {
@@ -60,21 +72,46 @@ func ParseSchema(s string) *TableSchema {
 	return &schema
 }
 
+// Every SQL type that we know how to convert from MySQL's representation.
+var SUPPORTED_SQL_TYPES = []string{
+	"tinyint", "smallint", "mediumint", "int", "bigint", "float", "double", "decimal",
+	"char", "varchar", "text", "mediumtext", "longtext",
+	"binary", "varbinary", "blob", "tinyblob", "mediumblob", "longblob",
+	"date", "time", "datetime", "timestamp",
+}
+
 // Break down a SQL type string like "int", "varchar(11)" or "decimal(20,10)".
 func parseSqlType(s string) (sqlType string, width, scale int) {
+	sqlType, width, scale, err := tryParseSqlType(s)
+	if err != nil {
+		panic(err)
+	}
+	return sqlType, width, scale
+}
+
+// Like parseSqlType, but for strings we didn't get from MySQL, which might be garbage.
+func tryParseSqlType(s string) (sqlType string, width, scale int, err error) {
 	if !strings.Contains(s, "(") {
-		return s, 0, 0
+		if s == "" || strings.ContainsAny(s, ") ,") {
+			return "", 0, 0, fmt.Errorf("Can't parse SQL type '%s'", s)
+		}
+		return s, 0, 0, nil
 	}
 	splitString := strings.SplitN(s, "(", 2)
+	if splitString[0] == "" || !strings.HasSuffix(splitString[1], ")") {
+		return "", 0, 0, fmt.Errorf("Can't parse SQL type '%s'", s)
+	}
 	splitString[1] = strings.TrimSuffix(splitString[1], ")")
-	commaIndex := strings.Index(splitString[1], ",")
-	if commaIndex >= 0 {
-		widthString := splitString[1][0:commaIndex]
-		scaleString := splitString[1][commaIndex+1:len(splitString[1])]
-		return splitString[0], MustParseInt(widthString), MustParseInt(scaleString)
-	} else {
-		return splitString[0], MustParseInt(splitString[1]), 0
+	widthString, scaleString, hasScale := strings.Cut(splitString[1], ",")
+	if width, err = strconv.Atoi(widthString); err != nil {
+		return "", 0, 0, fmt.Errorf("Can't parse width of SQL type '%s'", s)
+	}
+	if hasScale {
+		if scale, err = strconv.Atoi(scaleString); err != nil {
+			return "", 0, 0, fmt.Errorf("Can't parse scale of SQL type '%s'", s)
+		}
 	}
+	return splitString[0], width, scale, nil
 }
 
 func NewColumn(name, sqlType string, width, scale int, signed, nullable bool) Column {
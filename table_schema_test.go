@@ -23,3 +23,21 @@ func TestParseSchema(t *testing.T) {
 	assert.Equal(t, Column{"default_email", "tinyint", 1, 0, true, true}, schema.Columns[7])
 	assert.Equal(t, Column{"account_id", "bigint", 20, 0, false, true}, schema.Columns[8])
 }
+
+func TestTryParseSqlType(t *testing.T) {
+	sqlType, width, scale, err := tryParseSqlType("decimal(20,10)")
+	assert.NoError(t, err)
+	assert.Equal(t, "decimal", sqlType)
+	assert.Equal(t, 20, width)
+	assert.Equal(t, 10, scale)
+
+	sqlType, width, scale, err = tryParseSqlType("int")
+	assert.NoError(t, err)
+	assert.Equal(t, "int", sqlType)
+	assert.Equal(t, 0, width)
+
+	for _, bogus := range []string{"", "char(", "char(4", "(4)", "varchar(x)", "decimal(10,y)", "int unsigned"} {
+		_, _, _, err = tryParseSqlType(bogus)
+		assert.Error(t, err, bogus)
+	}
+}
@@ -0,0 +1,130 @@
+// File sinks write to local files, then hand each finished file to an Uploader to send it wherever it's going:
+// another local directory (like a mounted volume) or an S3 bucket.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type Uploader interface {
+	// Copies a finished local file to the destination, under the given name. The name can contain slashes.
+	Upload(localPath, name string) error
+	// Describes where an uploaded file ended up, like "/archive/users/users.1.jsonl".
+	Location(name string) string
+}
+
+// Picks an uploader for a destination like "s3://bucket/prefix", "file:///archive" or just "/archive".
+func NewUploader(destination string) (Uploader, error) {
+	scheme, location, found := strings.Cut(destination, "://")
+	if !found {
+		return NewLocalUploader(destination), nil
+	}
+	switch scheme {
+	case "file":
+		return NewLocalUploader(location), nil
+	case "s3":
+		return NewS3Uploader(location)
+	default:
+		return nil, fmt.Errorf("Can't upload to '%s': unsupported scheme '%s'", destination, scheme)
+	}
+}
+
+// Copies files into a local directory.
+type LocalUploader struct {
+	Directory string
+}
+
+func NewLocalUploader(directory string) *LocalUploader {
+	return &LocalUploader{directory}
+}
+
+func (u *LocalUploader) Upload(localPath, name string) error {
+	destination := u.Location(name)
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return err
+	}
+	source, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	// Nobody should see a half-copied file, so we copy it to a temporary name first.
+	temp, err := os.CreateTemp(filepath.Dir(destination), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err = io.Copy(temp, source); err != nil {
+		temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), destination)
+}
+
+func (u *LocalUploader) Location(name string) string {
+	return filepath.Join(u.Directory, filepath.FromSlash(name))
+}
+
+// Puts files in an S3 bucket, under an optional prefix. It uses the usual AWS settings from the environment:
+// credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, ~/.aws/credentials, or the instance's IAM role;
+// the bucket's region from AWS_REGION; and AWS_ENDPOINT_URL_S3 for S3-compatible storage that isn't S3.
+type S3Uploader struct {
+	Client *minio.Client
+	Bucket string
+	Prefix string
+}
+
+func NewS3Uploader(bucketAndPrefix string) (*S3Uploader, error) {
+	bucket, prefix, _ := strings.Cut(bucketAndPrefix, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("Can't upload to S3 without a bucket name")
+	}
+
+	endpoint, secure := "s3.amazonaws.com", true
+	if custom := os.Getenv("AWS_ENDPOINT_URL_S3"); custom != "" {
+		parsed, err := url.Parse(custom)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("Bogus value for AWS_ENDPOINT_URL_S3: '%s'", custom)
+		}
+		endpoint, secure = parsed.Host, parsed.Scheme != "http"
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+	client, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: secure, Region: os.Getenv("AWS_REGION")})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Uploader{client, bucket, strings.Trim(prefix, "/")}, nil
+}
+
+func (u *S3Uploader) key(name string) string {
+	return path.Join(u.Prefix, name)
+}
+
+func (u *S3Uploader) Upload(localPath, name string) error {
+	_, err := u.Client.FPutObject(context.Background(), u.Bucket, u.key(name), localPath, minio.PutObjectOptions{})
+	return err
+}
+
+func (u *S3Uploader) Location(name string) string {
+	return "s3://" + u.Bucket + "/" + u.key(name)
+}
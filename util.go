@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"math/big"
 	"os"
 	"strconv"
 	"strings"
@@ -63,13 +65,28 @@ func InIntegrationTestMode() bool {
 	return InTestMode() && StringToBool(os.Getenv("INTEGRATION_TESTS"))
 }
 
-func FormatEpochDate(epochDate int32) string {
-	seconds := int64(epochDate) * 24 * 60 * 60
-	date := time.Unix(seconds, 0).In(UTC)
-	return date.Format("2006-01-02")
+// A decimal's digits without the decimal point, at the column's scale. Extra digits after the decimal point
+// get rounded off the way FloatString does it, but too many before it are an error.
+func UnscaledDecimal(value *big.Rat, column Column) (*big.Int, error) {
+	scaled := new(big.Rat).Mul(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(column.Scale)), nil)))
+	unscaled, remainder := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// Round half away from zero.
+	if new(big.Int).Lsh(remainder.Abs(remainder), 1).Cmp(scaled.Denom()) >= 0 {
+		unscaled.Add(unscaled, big.NewInt(int64(scaled.Sign())))
+	}
+	if column.Width > 0 && len(new(big.Int).Abs(unscaled).String()) > column.Width {
+		return nil, fmt.Errorf("Decimal %s doesn't fit in a decimal(%d,%d) column", value.FloatString(column.Scale), column.Width, column.Scale)
+	}
+	return unscaled, nil
 }
 
-func FormatMillisecondTime(millisSinceMidnight int32) string {
-	epochTime := time.UnixMilli(int64(millisSinceMidnight)).In(UTC)
-	return epochTime.Format("15:04:05")
+// Days since 1970-01-01, rounding down so that dates before then come out negative. Avro and Debezium both
+// count dates this way.
+func EpochDays(t time.Time) int32 {
+	seconds := t.Unix()
+	days := seconds / (24 * 60 * 60)
+	if seconds % (24 * 60 * 60) < 0 {
+		days--
+	}
+	return int32(days)
 }
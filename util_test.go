@@ -2,6 +2,7 @@ package main
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,16 +23,11 @@ func TestDeleteFromSlice(t *testing.T) {
 	assert.Equal(t, []int{2, 4, 5, 6}, slice)
 }
 
-func TestFormatEpochDate(t *testing.T) {
-	assert.Equal(t, "1970-01-01", FormatEpochDate(0))
-	assert.Equal(t, "1970-01-02", FormatEpochDate(1))
-	assert.Equal(t, "2022-12-08", FormatEpochDate(19334))
-	assert.Equal(t, "2022-12-09", FormatEpochDate(19335))
+func TestEpochDays(t *testing.T) {
+	assert.Equal(t, int32(0), EpochDays(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, int32(1337), EpochDays(time.Date(1973, 8, 30, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, int32(-1), EpochDays(time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, int32(-1), EpochDays(time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC)))
+	assert.Equal(t, int32(-365), EpochDays(time.Date(1969, 1, 1, 0, 0, 0, 0, time.UTC)))
 }
 
-func TestFormatMillisecondTime(t *testing.T) {
-	assert.Equal(t, "00:00:00", FormatMillisecondTime(0))
-	assert.Equal(t, "00:00:01", FormatMillisecondTime(1000))
-	assert.Equal(t, "13:52:49", FormatMillisecondTime(49969098))
-	assert.Equal(t, "23:41:30", FormatMillisecondTime(85290923))
-}
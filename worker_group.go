@@ -14,16 +14,18 @@ type WorkerGroup struct {
 	exitChan chan struct{}
 	doneChan chan struct{}
 	count atomic.Int32
+	lock sync.Mutex
+	exiting bool
 	err error
 }
 
 func NewWorkerGroup() *WorkerGroup {
-	return &WorkerGroup{sync.WaitGroup{}, make(chan struct{}), make(chan struct{}), atomic.Int32{}, nil}
+	return &WorkerGroup{sync.WaitGroup{}, make(chan struct{}), make(chan struct{}), atomic.Int32{}, sync.Mutex{}, false, nil}
 }
 
 // This registers a new goroutine with the WorkerGroup and starts it. (Just a wrapper around the 'go'
-// keyword.) If the goroutine returns an error, the WorkerGroup will signal all other goroutines to exit,
-// then return the error from Wait().
+// keyword.) If the goroutine returns an error or panics, the WorkerGroup will signal all other goroutines to
+// exit, then return the error (or a PanicError) from Wait().
 func (ac *WorkerGroup) Go(fn func() error) {
 	ac.waitGroup.Add(1)
 	ac.count.Add(1)
@@ -35,6 +37,11 @@ func (ac *WorkerGroup) Go(fn func() error) {
 				close(ac.doneChan)
 			}
 		}()
+		defer func() {
+			if r := recover(); r != nil {
+				ac.Exit(NewPanicError(r))
+			}
+		}()
 
 		err := fn()
 		if err != nil {
@@ -55,16 +62,26 @@ func (ac *WorkerGroup) ExitSignal() <-chan struct{} {
 }
 
 // This signals all goroutines to exit. Supply 'err' if the group is dying because of an error; otherwise,
-// just pass nil.
+// just pass nil. It's safe to call this more than once, which happens when several goroutines fail at once;
+// the first error wins.
 func (ac *WorkerGroup) Exit(err error) {
-	ac.err = err
-	close(ac.exitChan)
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if ac.err == nil {
+		ac.err = err
+	}
+	if !ac.exiting {
+		ac.exiting = true
+		close(ac.exitChan)
+	}
 }
 
 // This blocks until all goroutines have exited. If the caller gave an error to Exit(), this returns the error
 // that they passed. If called multiple times, subsequent calls will return nil immediately and do nothing.
 func (ac *WorkerGroup) Wait() error {
 	ac.waitGroup.Wait()
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
 	err := ac.err
 	ac.err = nil
 	return err
@@ -96,3 +96,35 @@ func TestWorkerGroupExitsOnGoroutineError(t *testing.T) {
 	err := ac.Wait()
 	assert.Equal(t, err.Error(), "HONK")
 }
+
+func TestWorkerGroupMultipleErrors(t *testing.T) {
+	ac := NewWorkerGroup()
+	// BONK can't fail until HONK's error has made the group exit.
+	ac.Go(func() error {
+		<-ac.ExitSignal()
+		return errors.New("BONK")
+	})
+	ac.Go(func() error {
+		return errors.New("HONK")
+	})
+	err := ac.Wait()
+
+	assert.Equal(t, "HONK", err.Error())
+}
+
+func TestWorkerGroupPanic(t *testing.T) {
+	ac := NewWorkerGroup()
+	ac.Go(func() error {
+		<-ac.ExitSignal()
+		return nil
+	})
+	ac.Go(func() error {
+		panic("OH NO")
+	})
+	err := ac.Wait()
+
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &panicErr)
+	assert.Equal(t, "panic: OH NO", err.Error())
+	assert.Contains(t, string(panicErr.Stack), "worker_group_test.go")
+}